@@ -0,0 +1,283 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	cli "gopkg.in/urfave/cli.v1"
+
+	"github.com/champii/babble/crypto"
+	hg "github.com/champii/babble/hashgraph"
+	"github.com/champii/babble/net"
+	"github.com/champii/babble/proxy"
+	aproxy "github.com/champii/babble/proxy/app"
+)
+
+const exportBatchSize = 100
+
+var (
+	FromFlag = cli.IntFlag{
+		Name:  "from",
+		Usage: "Index of the first Block",
+		Value: 0,
+	}
+	ToFlag = cli.IntFlag{
+		Name:  "to",
+		Usage: "Index of the last Block (-1 for the last committed Block)",
+		Value: -1,
+	}
+	FormatFlag = cli.StringFlag{
+		Name:  "format",
+		Usage: "jsonl, binary",
+		Value: hg.JSONLArchive,
+	}
+	OutputFlag = cli.StringFlag{
+		Name:  "out",
+		Usage: "File to write the archive to (- for stdout)",
+		Value: "-",
+	}
+	InputFlag = cli.StringFlag{
+		Name:  "in",
+		Usage: "File to read the archive from (- for stdin)",
+		Value: "-",
+	}
+)
+
+//exportBlocks writes the Blocks of an existing badger database to an archive.
+//The database must not be in use by a running node.
+func exportBlocks(c *cli.Context) error {
+	logger := logrus.New()
+	logger.Level = logLevel(c.String(LogLevelFlag.Name))
+
+	datadir := c.String(DataDirFlag.Name)
+	storePath := c.String(StorePathFlag.Name)
+	cacheSize := c.Int(CacheSizeFlag.Name)
+	from := c.Int(FromFlag.Name)
+	to := c.Int(ToFlag.Name)
+	format := c.String(FormatFlag.Name)
+	out := c.String(OutputFlag.Name)
+
+	logger.WithFields(logrus.Fields{
+		"datadir":    datadir,
+		"store_path": storePath,
+		"from":       from,
+		"to":         to,
+		"format":     format,
+		"out":        out,
+	}).Debug("EXPORT BLOCKS")
+
+	storeKey, err := crypto.NewStoreKey(datadir).ReadKey()
+	if err != nil {
+		return cli.NewExitError(err, 1)
+	}
+
+	store, err := hg.LoadEncryptedBadgerStore(cacheSize, storePath, storeKey)
+	if err != nil {
+		return cli.NewExitError(
+			fmt.Sprintf("failed to load BadgerStore: %s", err),
+			1)
+	}
+	defer store.Close()
+
+	var w io.Writer = os.Stdout
+	if out != "-" {
+		file, err := os.Create(out)
+		if err != nil {
+			return cli.NewExitError(err, 1)
+		}
+		defer file.Close()
+		w = file
+	}
+
+	writer, err := hg.NewBlockWriter(w, format)
+	if err != nil {
+		return cli.NewExitError(err, 1)
+	}
+
+	count := 0
+	for to < 0 || from <= to {
+		end := from + exportBatchSize - 1
+		if to >= 0 && end > to {
+			end = to
+		}
+
+		blocks, err := store.GetBlocks(from, end)
+		if err != nil {
+			return cli.NewExitError(err, 1)
+		}
+		for _, b := range blocks {
+			if err := writer.Write(b); err != nil {
+				return cli.NewExitError(err, 1)
+			}
+		}
+		count += len(blocks)
+
+		//there are no Blocks after the last committed one
+		if len(blocks) < end-from+1 {
+			break
+		}
+		from = end + 1
+	}
+
+	if err := writer.Flush(); err != nil {
+		return cli.NewExitError(err, 1)
+	}
+
+	logger.WithField("blocks", count).Info("Exported Blocks")
+
+	return nil
+}
+
+//replayBlocks commits the Blocks of an archive to an App, in order, and checks
+//that the resulting state hashes are those signed by the validators. The
+//archive must hold consecutive Blocks, starting at --from if it is set.
+func replayBlocks(c *cli.Context) error {
+	logger := logrus.New()
+	logger.Level = logLevel(c.String(LogLevelFlag.Name))
+
+	datadir := c.String(DataDirFlag.Name)
+	noclient := c.Bool(NoClientFlag.Name)
+	proxyAddress := c.String(ProxyAddressFlag.Name)
+	clientAddress := c.String(ClientAddressFlag.Name)
+	tcpTimeout := c.Int(TcpTimeoutFlag.Name)
+	format := c.String(FormatFlag.Name)
+	in := c.String(InputFlag.Name)
+	//-1 until the first Block if --from is not set
+	next := -1
+	if c.IsSet(FromFlag.Name) {
+		next = c.Int(FromFlag.Name)
+	}
+
+	logger.WithFields(logrus.Fields{
+		"datadir":     datadir,
+		"no_client":   noclient,
+		"proxy_addr":  proxyAddress,
+		"client_addr": clientAddress,
+		"tcp_timeout": tcpTimeout,
+		"format":      format,
+		"in":          in,
+		"from":        next,
+	}).Debug("REPLAY BLOCKS")
+
+	//Only the signatures of the participants in peers.json are accepted
+	peers, err := net.NewJSONPeers(datadir).Peers()
+	if err != nil {
+		return cli.NewExitError(
+			fmt.Sprintf("reading the validators from peers.json: %s", err),
+			1)
+	}
+	if len(peers) == 0 {
+		return cli.NewExitError("peers.json lists no validators", 1)
+	}
+	validators := make(map[string]bool)
+	for _, p := range peers {
+		validators[p.PubKeyHex] = true
+	}
+
+	var r io.Reader = os.Stdin
+	if in != "-" {
+		file, err := os.Open(in)
+		if err != nil {
+			return cli.NewExitError(err, 1)
+		}
+		defer file.Close()
+		r = file
+	}
+
+	reader, err := hg.NewBlockReader(r, format)
+	if err != nil {
+		return cli.NewExitError(err, 1)
+	}
+
+	var prox proxy.AppProxy
+	if noclient {
+		prox = aproxy.NewInmemAppProxy(logger)
+	} else {
+		prox = aproxy.NewSocketAppProxy(clientAddress, proxyAddress,
+			time.Duration(tcpTimeout)*time.Millisecond, logger)
+	}
+
+	count := 0
+	for {
+		block, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return cli.NewExitError(err, 1)
+		}
+
+		//a missing, repeated or misplaced Block would corrupt the state of the
+		//App, and is only noticed later if at all
+		if next >= 0 && block.Index() != next {
+			return cli.NewExitError(
+				fmt.Sprintf("expected Block %d, got %d", next, block.Index()),
+				1)
+		}
+		next = block.Index() + 1
+
+		if err := replayBlock(prox, block, validators); err != nil {
+			return cli.NewExitError(
+				fmt.Sprintf("Block %d: %s", block.Index(), err),
+				1)
+		}
+
+		logger.WithFields(logrus.Fields{
+			"index":      block.Index(),
+			"txs":        len(block.Transactions()),
+			"state_hash": fmt.Sprintf("0x%X", block.StateHash()),
+			"signatures": len(block.Signatures),
+		}).Debug("Replayed Block")
+		count++
+	}
+
+	logger.WithField("blocks", count).Info("Replayed Blocks")
+
+	return nil
+}
+
+//replayBlock checks that a Block is signed by more than a third of the
+//validators, so that at least one honest validator vouches for it, then commits
+//it to the App and checks the resulting state hash.
+func replayBlock(prox proxy.AppProxy, block hg.Block, validators map[string]bool) error {
+	valid := 0
+	for validator := range block.Signatures {
+		if !validators[validator] {
+			continue
+		}
+		sig, err := block.GetSignature(validator)
+		if err != nil {
+			return err
+		}
+		ok, err := block.Verify(sig)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return fmt.Errorf("invalid signature from %s", validator)
+		}
+		valid++
+	}
+	if threshold := len(validators)/3 + 1; valid < threshold {
+		return fmt.Errorf("%d valid signatures, %d required", valid, threshold)
+	}
+
+	//The App receives Blocks before they are signed and their StateHash is set
+	stateHash, err := prox.CommitBlock(hg.NewBlock(block.Index(),
+		block.RoundReceived(),
+		block.Transactions()))
+	if err != nil {
+		return err
+	}
+
+	if !bytes.Equal(stateHash, block.StateHash()) {
+		return fmt.Errorf("state hash 0x%X does not match archived state hash 0x%X",
+			stateHash, block.StateHash())
+	}
+
+	return nil
+}
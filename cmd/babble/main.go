@@ -3,10 +3,12 @@ package main
 import (
 	"fmt"
 	"os"
+	"os/signal"
 	"os/user"
 	"path/filepath"
 	"runtime"
 	"sort"
+	"syscall"
 	"time"
 
 	_ "net/http/pprof"
@@ -16,6 +18,7 @@ import (
 
 	"github.com/champii/babble/crypto"
 	hg "github.com/champii/babble/hashgraph"
+	"github.com/champii/babble/mempool"
 	"github.com/champii/babble/net"
 	"github.com/champii/babble/node"
 	"github.com/champii/babble/proxy"
@@ -32,13 +35,29 @@ var (
 	}
 	NodeAddressFlag = cli.StringFlag{
 		Name:  "node_addr",
-		Usage: "IP:Port to bind Babble",
+		Usage: "IP:Port to bind Babble, or unix:///path/to/socket for a Unix domain socket",
 		Value: "127.0.0.1:1337",
 	}
+	ListenFlag = cli.StringFlag{
+		Name:  "listen",
+		Usage: "Address to bind Babble if it differs from node_addr, like 0.0.0.0:1337",
+	}
+	AdvertiseFlag = cli.StringFlag{
+		Name:  "advertise",
+		Usage: "IP:Port where other nodes reach Babble, if it differs from the bind address",
+	}
+	TLSFlag = cli.BoolFlag{
+		Name:  "tls",
+		Usage: "Encrypt and authenticate gossip with TLS, using the node key",
+	}
 	NoClientFlag = cli.BoolFlag{
 		Name:  "no_client",
 		Usage: "Run Babble with dummy in-memory App client",
 	}
+	AppCmdFlag = cli.StringFlag{
+		Name:  "app_cmd",
+		Usage: "Command that runs the App as a child process, exchanging JSON messages over stdin and stdout",
+	}
 	ProxyAddressFlag = cli.StringFlag{
 		Name:  "proxy_addr",
 		Usage: "IP:Port to bind Proxy Server",
@@ -69,11 +88,41 @@ var (
 		Usage: "Max number of pooled connections",
 		Value: 2,
 	}
+	PoolIdleTimeoutFlag = cli.IntFlag{
+		Name:  "pool_idle_timeout",
+		Usage: "Milliseconds a pooled connection may stay unused before it is closed (0 for no limit)",
+		Value: int(net.DefaultIdleTimeout / time.Millisecond),
+	}
 	TcpTimeoutFlag = cli.IntFlag{
 		Name:  "tcp_timeout",
 		Usage: "TCP timeout milliseconds",
 		Value: 1000,
 	}
+	MaxConnsPerAddrFlag = cli.IntFlag{
+		Name:  "max_conns_per_addr",
+		Usage: "Max number of inbound connections per remote address (0 for no limit)",
+		Value: net.DefaultLimits().MaxConnsPerAddr,
+	}
+	MaxRequestBytesFlag = cli.Int64Flag{
+		Name:  "max_request_bytes",
+		Usage: "Max size of an inbound request in bytes (0 for no limit)",
+		Value: net.DefaultLimits().MaxRequestBytes,
+	}
+	MaxRequestRateFlag = cli.Float64Flag{
+		Name:  "max_request_rate",
+		Usage: "Max inbound requests per second per remote address (0 for no limit)",
+		Value: net.DefaultLimits().RequestsPerSecond,
+	}
+	RequestBurstFlag = cli.IntFlag{
+		Name:  "request_burst",
+		Usage: "Number of inbound requests allowed at once above max_request_rate",
+		Value: net.DefaultLimits().RequestBurst,
+	}
+	ReadTimeoutFlag = cli.IntFlag{
+		Name:  "read_timeout",
+		Usage: "Milliseconds allowed to read an inbound request (0 for no limit)",
+		Value: int(net.DefaultLimits().ReadTimeout / time.Millisecond),
+	}
 	CacheSizeFlag = cli.IntFlag{
 		Name:  "cache_size",
 		Usage: "Number of items in LRU caches",
@@ -84,6 +133,26 @@ var (
 		Usage: "Max number of events for sync",
 		Value: 1000,
 	}
+	PeerSelectorFlag = cli.StringFlag{
+		Name:  "peer_selector",
+		Usage: "random, health",
+		Value: node.RandomPeerSelectorType,
+	}
+	MempoolMaxTxsFlag = cli.IntFlag{
+		Name:  "mempool_max_txs",
+		Usage: "Max number of pending transactions (0 for no limit)",
+		Value: mempool.DefaultLimits().MaxTxs,
+	}
+	MempoolMaxBytesFlag = cli.IntFlag{
+		Name:  "mempool_max_bytes",
+		Usage: "Max total size of pending transactions in bytes (0 for no limit)",
+		Value: mempool.DefaultLimits().MaxBytes,
+	}
+	MempoolMaxPerSubmitterFlag = cli.IntFlag{
+		Name:  "mempool_max_per_submitter",
+		Usage: "Max number of pending transactions per App connection (0 for no limit)",
+		Value: mempool.DefaultLimits().MaxPerSubmitter,
+	}
 	StoreFlag = cli.StringFlag{
 		Name:  "store",
 		Usage: "badger, inmem",
@@ -114,20 +183,65 @@ func main() {
 			Flags: []cli.Flag{
 				DataDirFlag,
 				NodeAddressFlag,
+				ListenFlag,
+				AdvertiseFlag,
+				TLSFlag,
 				NoClientFlag,
+				AppCmdFlag,
 				ProxyAddressFlag,
 				ClientAddressFlag,
 				ServiceAddressFlag,
 				LogLevelFlag,
 				HeartbeatFlag,
 				MaxPoolFlag,
+				PoolIdleTimeoutFlag,
 				TcpTimeoutFlag,
+				MaxConnsPerAddrFlag,
+				MaxRequestBytesFlag,
+				MaxRequestRateFlag,
+				RequestBurstFlag,
+				ReadTimeoutFlag,
 				CacheSizeFlag,
 				SyncLimitFlag,
+				PeerSelectorFlag,
+				MempoolMaxTxsFlag,
+				MempoolMaxBytesFlag,
+				MempoolMaxPerSubmitterFlag,
 				StoreFlag,
 				StorePathFlag,
 			},
 		},
+		{
+			Name:   "export-blocks",
+			Usage:  "Export committed Blocks from the database to an archive",
+			Action: exportBlocks,
+			Flags: []cli.Flag{
+				DataDirFlag,
+				StorePathFlag,
+				CacheSizeFlag,
+				LogLevelFlag,
+				FromFlag,
+				ToFlag,
+				FormatFlag,
+				OutputFlag,
+			},
+		},
+		{
+			Name:   "replay-blocks",
+			Usage:  "Commit the Blocks of an archive to an App and check state hashes",
+			Action: replayBlocks,
+			Flags: []cli.Flag{
+				DataDirFlag,
+				NoClientFlag,
+				ProxyAddressFlag,
+				ClientAddressFlag,
+				TcpTimeoutFlag,
+				LogLevelFlag,
+				FromFlag,
+				FormatFlag,
+				InputFlag,
+			},
+		},
 		{
 			Name:   "version",
 			Usage:  "Show version info",
@@ -158,36 +272,64 @@ func run(c *cli.Context) error {
 
 	datadir := c.String(DataDirFlag.Name)
 	addr := c.String(NodeAddressFlag.Name)
+	listen := c.String(ListenFlag.Name)
+	advertise := c.String(AdvertiseFlag.Name)
+	useTLS := c.Bool(TLSFlag.Name)
 	noclient := c.Bool(NoClientFlag.Name)
+	appCmd := c.String(AppCmdFlag.Name)
 	proxyAddress := c.String(ProxyAddressFlag.Name)
 	clientAddress := c.String(ClientAddressFlag.Name)
 	serviceAddress := c.String(ServiceAddressFlag.Name)
 	heartbeat := c.Int(HeartbeatFlag.Name)
 	maxPool := c.Int(MaxPoolFlag.Name)
+	poolIdleTimeout := c.Int(PoolIdleTimeoutFlag.Name)
 	tcpTimeout := c.Int(TcpTimeoutFlag.Name)
+	limits := net.Limits{
+		MaxConnsPerAddr:   c.Int(MaxConnsPerAddrFlag.Name),
+		MaxRequestBytes:   c.Int64(MaxRequestBytesFlag.Name),
+		RequestsPerSecond: c.Float64(MaxRequestRateFlag.Name),
+		RequestBurst:      c.Int(RequestBurstFlag.Name),
+		ReadTimeout:       time.Duration(c.Int(ReadTimeoutFlag.Name)) * time.Millisecond,
+	}
 	cacheSize := c.Int(CacheSizeFlag.Name)
 	syncLimit := c.Int(SyncLimitFlag.Name)
+	peerSelector := c.String(PeerSelectorFlag.Name)
+	mempoolLimits := mempool.Limits{
+		MaxTxs:          c.Int(MempoolMaxTxsFlag.Name),
+		MaxBytes:        c.Int(MempoolMaxBytesFlag.Name),
+		MaxPerSubmitter: c.Int(MempoolMaxPerSubmitterFlag.Name),
+	}
 	storeType := c.String(StoreFlag.Name)
 	storePath := c.String(StorePathFlag.Name)
 
 	logger.WithFields(logrus.Fields{
-		"datadir":      datadir,
-		"node_addr":    addr,
-		"no_client":    noclient,
-		"proxy_addr":   proxyAddress,
-		"client_addr":  clientAddress,
-		"service_addr": serviceAddress,
-		"heartbeat":    heartbeat,
-		"max_pool":     maxPool,
-		"tcp_timeout":  tcpTimeout,
-		"cache_size":   cacheSize,
-		"store":        storeType,
-		"store_path":   storePath,
+		"datadir":           datadir,
+		"node_addr":         addr,
+		"listen":            listen,
+		"advertise":         advertise,
+		"tls":               useTLS,
+		"no_client":         noclient,
+		"app_cmd":           appCmd,
+		"proxy_addr":        proxyAddress,
+		"client_addr":       clientAddress,
+		"service_addr":      serviceAddress,
+		"heartbeat":         heartbeat,
+		"max_pool":          maxPool,
+		"pool_idle_timeout": poolIdleTimeout,
+		"tcp_timeout":       tcpTimeout,
+		"limits":            limits,
+		"cache_size":        cacheSize,
+		"peer_selector":     peerSelector,
+		"mempool":           mempoolLimits,
+		"store":             storeType,
+		"store_path":        storePath,
 	}).Debug("RUN")
 
 	conf := node.NewConfig(time.Duration(heartbeat)*time.Millisecond,
 		time.Duration(tcpTimeout)*time.Millisecond,
 		cacheSize, syncLimit, storeType, storePath, logger)
+	conf.PeerSelector = peerSelector
+	conf.Mempool = mempoolLimits
 
 	// Create the PEM key
 	pemKey := crypto.NewPemKey(datadir)
@@ -236,10 +378,20 @@ func run(c *cli.Context) error {
 	case "inmem":
 		store = hg.NewInmemStore(pmap, conf.CacheSize)
 	case "badger":
+		//Values are encrypted if a store key is found in the datadir or in
+		//the environment
+		storeKey, err := crypto.NewStoreKey(datadir).ReadKey()
+		if err != nil {
+			return cli.NewExitError(err, 1)
+		}
+		if storeKey != nil {
+			logger.Debug("badger store encryption enabled")
+		}
+
 		//If the file already exists, load and bootstrap the store using the file
 		if _, err := os.Stat(conf.StorePath); err == nil {
 			logger.Debug("loading badger store from existing database")
-			store, err = hg.LoadBadgerStore(conf.CacheSize, conf.StorePath)
+			store, err = hg.LoadEncryptedBadgerStore(conf.CacheSize, conf.StorePath, storeKey)
 			if err != nil {
 				return cli.NewExitError(
 					fmt.Sprintf("failed to load BadgerStore from existing file: %s", err),
@@ -249,7 +401,7 @@ func run(c *cli.Context) error {
 		} else {
 			//Otherwise create a new one
 			logger.Debug("creating new badger store from fresh database")
-			store, err = hg.NewBadgerStore(pmap, conf.CacheSize, conf.StorePath)
+			store, err = hg.NewEncryptedBadgerStore(pmap, conf.CacheSize, conf.StorePath, storeKey)
 			if err != nil {
 				return cli.NewExitError(
 					fmt.Sprintf("failed to create new BadgerStore: %s", err),
@@ -260,14 +412,42 @@ func run(c *cli.Context) error {
 		return cli.NewExitError(fmt.Sprintf("invalid store option: %s", storeType), 1)
 	}
 
-	trans, err := net.NewTCPTransport(addr,
-		nil, maxPool, conf.TCPTimeout, logger)
+	//node_addr is both the bind and the advertised address unless listen or
+	//advertise say otherwise
+	if listen == "" {
+		listen = addr
+	}
+	advertiseAddr, err := net.ResolveAdvertise(advertise)
 	if err != nil {
 		return cli.NewExitError(err, 1)
 	}
 
+	var trans *net.NetworkTransport
+	if useTLS {
+		if scheme, _ := net.SplitAddr(listen); scheme != net.TCPScheme {
+			return cli.NewExitError(
+				fmt.Sprintf("TLS requires a TCP listen address, got %s", listen),
+				1)
+		}
+		trans, err = net.NewTLSTransport(listen,
+			advertiseAddr, key, peerStore, maxPool, conf.TCPTimeout, logger)
+	} else {
+		trans, err = net.NewTransport(listen, advertise, maxPool, conf.TCPTimeout, logger)
+	}
+	if err != nil {
+		return cli.NewExitError(err, 1)
+	}
+	trans.SetLimits(limits)
+	trans.SetIdleTimeout(time.Duration(poolIdleTimeout) * time.Millisecond)
+
 	var prox proxy.AppProxy
-	if noclient {
+	var stdioProxy *aproxy.StdioAppProxy
+	if appCmd != "" {
+		if stdioProxy, err = aproxy.NewStdioAppProxy(appCmd, conf.TCPTimeout, logger); err != nil {
+			return cli.NewExitError(err, 1)
+		}
+		prox = stdioProxy
+	} else if noclient {
 		prox = aproxy.NewInmemAppProxy(logger)
 	} else {
 		prox = aproxy.NewSocketAppProxy(clientAddress, proxyAddress,
@@ -275,17 +455,35 @@ func run(c *cli.Context) error {
 	}
 
 	node := node.NewNode(conf, nodeID, key, peers, store, trans, prox)
+	node.SetPeerStore(peerStore)
 	if err := node.Init(needBootstrap); err != nil {
 		return cli.NewExitError(
 			fmt.Sprintf("failed to initialize node: %s", err),
 			1)
 	}
 
+	//the App can handshake once the node is initialized
+	if stdioProxy != nil {
+		stdioProxy.Start()
+	}
+
 	serviceServer := service.NewService(serviceAddress, node, logger)
 	go serviceServer.Serve()
 
+	//stop the node, and then the App it runs, on SIGINT or SIGTERM
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sigCh
+		node.Shutdown()
+	}()
+
 	node.Run(true)
 
+	if stdioProxy != nil {
+		stdioProxy.Close()
+	}
+
 	return nil
 }
 
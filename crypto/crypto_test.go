@@ -127,3 +127,81 @@ func TestSignatureEncoding(t *testing.T) {
 	}
 
 }
+
+func TestStoreKey(t *testing.T) {
+	dir, err := ioutil.TempDir("test_data", "babble")
+	if err != nil {
+		t.Fatalf("err: %v ", err)
+	}
+	defer os.RemoveAll(dir)
+
+	storeKey := NewStoreKey(dir)
+
+	// No key configured
+	key, err := storeKey.ReadKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key != nil {
+		t.Fatalf("key should be nil")
+	}
+
+	key, _ = GenerateStoreKey()
+	if err := storeKey.WriteKey(key); err != nil {
+		t.Fatal(err)
+	}
+
+	nKey, err := storeKey.ReadKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(nKey, key) {
+		t.Fatalf("Keys do not match")
+	}
+
+	// The environment variable takes precedence over the file
+	envKey, _ := GenerateStoreKey()
+	os.Setenv(StoreKeyEnv, fmt.Sprintf("%x", envKey))
+	defer os.Unsetenv(StoreKeyEnv)
+
+	nKey, err = storeKey.ReadKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(nKey, envKey) {
+		t.Fatalf("Key should be read from %s", StoreKeyEnv)
+	}
+}
+
+func TestCipher(t *testing.T) {
+	key, _ := GenerateStoreKey()
+	cipher, err := NewCipher(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg := []byte("the customer data")
+	sealed, err := cipher.Seal(msg, []byte("key 1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opened, err := cipher.Open(sealed, []byte("key 1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(opened, msg) {
+		t.Fatalf("Opened message should be %s, not %s", msg, opened)
+	}
+
+	// Data sealed for a key can not be opened for another
+	if _, err := cipher.Open(sealed, []byte("key 2")); err == nil {
+		t.Fatal("Opening data with other additional data should fail")
+	}
+
+	// Tampering with the sealed data must be detected
+	sealed[len(sealed)-1] ^= 0xFF
+	if _, err := cipher.Open(sealed, []byte("key 1")); err == nil {
+		t.Fatal("Opening tampered data should fail")
+	}
+}
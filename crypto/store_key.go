@@ -0,0 +1,133 @@
+package crypto
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+const (
+	storeKeyPath = "store_key"
+
+	//StoreKeyEnv is the environment variable that can be used instead of the
+	//store_key file to provide the key of the Store encryption
+	StoreKeyEnv = "BABBLE_STORE_KEY"
+
+	//StoreKeySize is the size in bytes of a Store encryption key (AES-256)
+	StoreKeySize = 32
+)
+
+//StoreKey reads and writes the hex-encoded symmetric key used to encrypt the
+//values of the Store database. The key lives in the store_key file of the
+//datadir, or in the BABBLE_STORE_KEY environment variable which takes
+//precedence.
+type StoreKey struct {
+	l    sync.Mutex
+	path string
+}
+
+func NewStoreKey(base string) *StoreKey {
+	path := filepath.Join(base, storeKeyPath)
+	storeKey := &StoreKey{
+		path: path,
+	}
+	return storeKey
+}
+
+//ReadKey returns nil, without error, if no key is configured
+func (k *StoreKey) ReadKey() ([]byte, error) {
+	k.l.Lock()
+	defer k.l.Unlock()
+
+	if env := os.Getenv(StoreKeyEnv); env != "" {
+		return ReadStoreKeyFromBuf([]byte(env))
+	}
+
+	buf, err := ioutil.ReadFile(k.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return ReadStoreKeyFromBuf(buf)
+}
+
+func (k *StoreKey) WriteKey(key []byte) error {
+	k.l.Lock()
+	defer k.l.Unlock()
+
+	data := []byte(hex.EncodeToString(key))
+	return ioutil.WriteFile(k.path, data, 0600)
+}
+
+func ReadStoreKeyFromBuf(buf []byte) ([]byte, error) {
+	s := strings.TrimSpace(string(buf))
+	if s == "" {
+		return nil, nil
+	}
+
+	key, err := hex.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("Error decoding store key: %s", err)
+	}
+	if len(key) != StoreKeySize {
+		return nil, fmt.Errorf("Store key should be %d bytes, not %d", StoreKeySize, len(key))
+	}
+	return key, nil
+}
+
+func GenerateStoreKey() ([]byte, error) {
+	key := make([]byte, StoreKeySize)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+//------------------------------------------------------------------------------
+
+//Cipher provides authenticated encryption (AES-GCM) of arbitrary values. The
+//random nonce is prepended to the sealed data. The additional data, like the
+//key a value is stored at, is authenticated but not sealed; Open fails unless
+//it is given the same additional data as Seal.
+type Cipher struct {
+	aead cipher.AEAD
+}
+
+func NewCipher(key []byte) (*Cipher, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &Cipher{aead: aead}, nil
+}
+
+func (c *Cipher) Seal(plaintext, additionalData []byte) ([]byte, error) {
+	nonce := make([]byte, c.aead.NonceSize(), c.aead.NonceSize()+len(plaintext)+c.aead.Overhead())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return c.aead.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+func (c *Cipher) Open(data, additionalData []byte) ([]byte, error) {
+	nonceSize := c.aead.NonceSize()
+	if len(data) < nonceSize {
+		return nil, fmt.Errorf("Sealed data is too short")
+	}
+	return c.aead.Open(nil, data[:nonceSize], data[nonceSize:], additionalData)
+}
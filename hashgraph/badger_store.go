@@ -1,12 +1,14 @@
 package hashgraph
 
 import (
+	"bytes"
 	"fmt"
 	"os"
 	"strconv"
 
 	"github.com/dgraph-io/badger"
 	cm "github.com/champii/babble/common"
+	"github.com/champii/babble/crypto"
 )
 
 var (
@@ -15,6 +17,8 @@ var (
 	roundPrefix       = "round"
 	topoPrefix        = "topo"
 	blockPrefix       = "block"
+	consensusPrefix   = "consensus"
+	deliveredKey      = "delivered_block"
 )
 
 type BadgerStore struct {
@@ -22,11 +26,22 @@ type BadgerStore struct {
 	inmemStore   *InmemStore
 	db           *badger.DB
 	path         string
+	cipher       *crypto.Cipher //nil if values are stored in the clear
 }
 
 //NewBadgerStore creates a brand new Store with a new database
 func NewBadgerStore(participants map[string]int, cacheSize int, path string) (*BadgerStore, error) {
+	return NewEncryptedBadgerStore(participants, cacheSize, path, nil)
+}
+
+//NewEncryptedBadgerStore creates a brand new Store with a new database whose
+//values are encrypted with key. A nil key disables encryption.
+func NewEncryptedBadgerStore(participants map[string]int, cacheSize int, path string, key []byte) (*BadgerStore, error) {
 	inmemStore := NewInmemStore(participants, cacheSize)
+	cipher, err := newStoreCipher(key)
+	if err != nil {
+		return nil, err
+	}
 	opts := badger.DefaultOptions
 	opts.Dir = path
 	opts.ValueDir = path
@@ -40,6 +55,7 @@ func NewBadgerStore(participants map[string]int, cacheSize int, path string) (*B
 		inmemStore:   inmemStore,
 		db:           handle,
 		path:         path,
+		cipher:       cipher,
 	}
 	if err := store.dbSetParticipants(participants); err != nil {
 		return nil, err
@@ -52,11 +68,22 @@ func NewBadgerStore(participants map[string]int, cacheSize int, path string) (*B
 
 //LoadBadgerStore creates a Store from an existing database
 func LoadBadgerStore(cacheSize int, path string) (*BadgerStore, error) {
+	return LoadEncryptedBadgerStore(cacheSize, path, nil)
+}
+
+//LoadEncryptedBadgerStore creates a Store from an existing database whose
+//values were encrypted with key
+func LoadEncryptedBadgerStore(cacheSize int, path string, key []byte) (*BadgerStore, error) {
 
 	if _, err := os.Stat(path); err != nil {
 		return nil, err
 	}
 
+	cipher, err := newStoreCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
 	opts := badger.DefaultOptions
 	opts.Dir = path
 	opts.ValueDir = path
@@ -66,8 +93,9 @@ func LoadBadgerStore(cacheSize int, path string) (*BadgerStore, error) {
 		return nil, err
 	}
 	store := &BadgerStore{
-		db:   handle,
-		path: path,
+		db:     handle,
+		path:   path,
+		cipher: cipher,
 	}
 
 	participants, err := store.dbGetParticipants()
@@ -91,6 +119,15 @@ func LoadBadgerStore(cacheSize int, path string) (*BadgerStore, error) {
 		return nil, err
 	}
 
+	//read the delivery cursor, which is absent if no Block was delivered
+	delivered, err := store.dbGetLastDeliveredBlock()
+	if err != nil && !isDBKeyNotFound(err) {
+		return nil, err
+	}
+	if err == nil {
+		inmemStore.SetLastDeliveredBlock(delivered)
+	}
+
 	store.participants = participants
 	store.inmemStore = inmemStore
 
@@ -124,6 +161,10 @@ func blockKey(index int) []byte {
 	return []byte(fmt.Sprintf("%s_%09d", blockPrefix, index))
 }
 
+func consensusEventKey(index int) []byte {
+	return []byte(fmt.Sprintf("%s_%09d", consensusPrefix, index))
+}
+
 //==============================================================================
 //Implement the Store interface
 
@@ -207,8 +248,16 @@ func (s *BadgerStore) ConsensusEventsCount() int {
 	return s.inmemStore.ConsensusEventsCount()
 }
 
+func (s *BadgerStore) ConsensusEventsFrom(offset, limit int) ([]string, error) {
+	return s.dbConsensusEventsFrom(offset, limit)
+}
+
 func (s *BadgerStore) AddConsensusEvent(key string) error {
-	return s.inmemStore.AddConsensusEvent(key)
+	index := s.inmemStore.ConsensusEventsCount()
+	if err := s.inmemStore.AddConsensusEvent(key); err != nil {
+		return err
+	}
+	return s.dbSetConsensusEvent(index, key)
 }
 
 func (s *BadgerStore) GetRound(r int) (RoundInfo, error) {
@@ -219,6 +268,10 @@ func (s *BadgerStore) GetRound(r int) (RoundInfo, error) {
 	return res, mapError(err, string(roundKey(r)))
 }
 
+func (s *BadgerStore) GetRounds(from, to int) ([]RoundInfo, error) {
+	return s.dbGetRounds(from, to)
+}
+
 func (s *BadgerStore) SetRound(r int, round RoundInfo) error {
 	if err := s.inmemStore.SetRound(r, round); err != nil {
 		return err
@@ -262,6 +315,10 @@ func (s *BadgerStore) GetBlock(rr int) (Block, error) {
 	return res, mapError(err, string(blockKey(rr)))
 }
 
+func (s *BadgerStore) GetBlocks(from, to int) ([]Block, error) {
+	return s.dbGetBlocks(from, to)
+}
+
 func (s *BadgerStore) SetBlock(block Block) error {
 	if err := s.inmemStore.SetBlock(block); err != nil {
 		return err
@@ -269,6 +326,17 @@ func (s *BadgerStore) SetBlock(block Block) error {
 	return s.dbSetBlock(block)
 }
 
+func (s *BadgerStore) LastDeliveredBlock() int {
+	return s.inmemStore.LastDeliveredBlock()
+}
+
+func (s *BadgerStore) SetLastDeliveredBlock(index int) error {
+	if err := s.inmemStore.SetLastDeliveredBlock(index); err != nil {
+		return err
+	}
+	return s.dbSetLastDeliveredBlock(index)
+}
+
 func (s *BadgerStore) Reset(roots map[string]Root) error {
 	return s.inmemStore.Reset(roots)
 }
@@ -290,7 +358,7 @@ func (s *BadgerStore) dbGetEvent(key string) (Event, error) {
 		if err != nil {
 			return err
 		}
-		eventBytes, err = item.Value()
+		eventBytes, err = s.itemValue(item)
 		return err
 	})
 
@@ -322,19 +390,19 @@ func (s *BadgerStore) dbSetEvents(events []Event) error {
 			new = true
 		}
 		//insert [event hash] => [event bytes]
-		if err := tx.Set([]byte(eventHex), val); err != nil {
+		if err := s.setValue(tx, []byte(eventHex), val); err != nil {
 			return err
 		}
 
 		if new {
 			//insert [topo_index] => [event hash]
 			topoKey := topologicalEventKey(event.topologicalIndex)
-			if err := tx.Set(topoKey, []byte(eventHex)); err != nil {
+			if err := s.setValue(tx, topoKey, []byte(eventHex)); err != nil {
 				return err
 			}
 			//insert [participant_index] => [event hash]
 			peKey := participantEventKey(event.Creator(), event.Index())
-			if err := tx.Set(peKey, []byte(eventHex)); err != nil {
+			if err := s.setValue(tx, peKey, []byte(eventHex)); err != nil {
 				return err
 			}
 		}
@@ -349,9 +417,9 @@ func (s *BadgerStore) dbTopologicalEvents() ([]Event, error) {
 		key := topologicalEventKey(t)
 		item, errr := txn.Get(key)
 		for errr == nil {
-			v, errrr := item.Value()
+			v, errrr := s.itemValue(item)
 			if errrr != nil {
-				break
+				return errrr
 			}
 
 			evKey := string(v)
@@ -359,7 +427,7 @@ func (s *BadgerStore) dbTopologicalEvents() ([]Event, error) {
 			if err != nil {
 				return err
 			}
-			eventBytes, err := eventItem.Value()
+			eventBytes, err := s.itemValue(eventItem)
 			if err != nil {
 				return err
 			}
@@ -392,9 +460,9 @@ func (s *BadgerStore) dbParticipantEvents(participant string, skip int) ([]strin
 		key := participantEventKey(participant, i)
 		item, errr := txn.Get(key)
 		for errr == nil {
-			v, errrr := item.Value()
+			v, errrr := s.itemValue(item)
 			if errrr != nil {
-				break
+				return errrr
 			}
 			res = append(res, string(v))
 
@@ -420,7 +488,7 @@ func (s *BadgerStore) dbParticipantEvent(participant string, index int) (string,
 		if err != nil {
 			return err
 		}
-		data, err = item.Value()
+		data, err = s.itemValue(item)
 		return err
 	})
 	if err != nil {
@@ -439,7 +507,7 @@ func (s *BadgerStore) dbSetRoots(roots map[string]Root) error {
 		}
 		key := participantRootKey(participant)
 		//insert [participant_root] => [root bytes]
-		if err := tx.Set(key, val); err != nil {
+		if err := s.setValue(tx, key, val); err != nil {
 			return err
 		}
 	}
@@ -454,7 +522,7 @@ func (s *BadgerStore) dbGetRoot(participant string) (Root, error) {
 		if err != nil {
 			return err
 		}
-		rootBytes, err = item.Value()
+		rootBytes, err = s.itemValue(item)
 		return err
 	})
 
@@ -478,7 +546,7 @@ func (s *BadgerStore) dbGetRound(index int) (RoundInfo, error) {
 		if err != nil {
 			return err
 		}
-		roundBytes, err = item.Value()
+		roundBytes, err = s.itemValue(item)
 		return err
 	})
 
@@ -505,13 +573,26 @@ func (s *BadgerStore) dbSetRound(index int, round RoundInfo) error {
 	}
 
 	//insert [round_index] => [round bytes]
-	if err := tx.Set(key, val); err != nil {
+	if err := s.setValue(tx, key, val); err != nil {
 		return err
 	}
 
 	return tx.Commit(nil)
 }
 
+func (s *BadgerStore) dbGetRounds(from, to int) ([]RoundInfo, error) {
+	res := []RoundInfo{}
+	err := s.dbIterateRange(roundPrefix, roundKey(from), roundKey(to), func(v []byte) error {
+		roundInfo := new(RoundInfo)
+		if err := roundInfo.Unmarshal(v); err != nil {
+			return err
+		}
+		res = append(res, *roundInfo)
+		return nil
+	})
+	return res, err
+}
+
 func (s *BadgerStore) dbGetParticipants() (map[string]int, error) {
 	res := make(map[string]int)
 	err := s.db.View(func(txn *badger.Txn) error {
@@ -520,7 +601,7 @@ func (s *BadgerStore) dbGetParticipants() (map[string]int, error) {
 		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
 			item := it.Item()
 			k := string(item.Key())
-			v, err := item.Value()
+			v, err := s.itemValue(item)
 			if err != nil {
 				return err
 			}
@@ -544,7 +625,7 @@ func (s *BadgerStore) dbSetParticipants(participants map[string]int) error {
 		key := participantKey(participant)
 		val := []byte(strconv.Itoa(id))
 		//insert [participant_participant] => [id]
-		if err := tx.Set(key, val); err != nil {
+		if err := s.setValue(tx, key, val); err != nil {
 			return err
 		}
 	}
@@ -559,7 +640,7 @@ func (s *BadgerStore) dbGetBlock(index int) (Block, error) {
 		if err != nil {
 			return err
 		}
-		blockBytes, err = item.Value()
+		blockBytes, err = s.itemValue(item)
 		return err
 	})
 
@@ -586,15 +667,143 @@ func (s *BadgerStore) dbSetBlock(block Block) error {
 	}
 
 	//insert [index] => [block bytes]
-	if err := tx.Set(key, val); err != nil {
+	if err := s.setValue(tx, key, val); err != nil {
+		return err
+	}
+
+	return tx.Commit(nil)
+}
+
+func (s *BadgerStore) dbGetLastDeliveredBlock() (int, error) {
+	var val []byte
+	err := s.db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get([]byte(deliveredKey))
+		if err != nil {
+			return err
+		}
+		val, err = s.itemValue(item)
+		return err
+	})
+	if err != nil {
+		return -1, err
+	}
+	return strconv.Atoi(string(val))
+}
+
+func (s *BadgerStore) dbSetLastDeliveredBlock(index int) error {
+	tx := s.db.NewTransaction(true)
+	defer tx.Discard()
+
+	//insert [delivered_block] => [index]
+	if err := s.setValue(tx, []byte(deliveredKey), []byte(strconv.Itoa(index))); err != nil {
 		return err
 	}
 
 	return tx.Commit(nil)
 }
 
+func (s *BadgerStore) dbGetBlocks(from, to int) ([]Block, error) {
+	res := []Block{}
+	err := s.dbIterateRange(blockPrefix, blockKey(from), blockKey(to), func(v []byte) error {
+		block := new(Block)
+		if err := block.Unmarshal(v); err != nil {
+			return err
+		}
+		res = append(res, *block)
+		return nil
+	})
+	return res, err
+}
+
+func (s *BadgerStore) dbSetConsensusEvent(index int, key string) error {
+	tx := s.db.NewTransaction(true)
+	defer tx.Discard()
+
+	//insert [consensus_index] => [event hash]
+	if err := s.setValue(tx, consensusEventKey(index), []byte(key)); err != nil {
+		return err
+	}
+
+	return tx.Commit(nil)
+}
+
+func (s *BadgerStore) dbConsensusEventsFrom(offset, limit int) ([]string, error) {
+	res := []string{}
+	err := s.db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+		prefix := []byte(consensusPrefix)
+		for it.Seek(consensusEventKey(offset)); it.ValidForPrefix(prefix); it.Next() {
+			if limit > 0 && len(res) >= limit {
+				break
+			}
+			v, err := s.itemValue(it.Item())
+			if err != nil {
+				return err
+			}
+			res = append(res, string(v))
+		}
+		return nil
+	})
+	return res, err
+}
+
+//dbIterateRange calls fn on the values of the keys with the given prefix that
+//are between start and end (both included), in lexicographic order.
+func (s *BadgerStore) dbIterateRange(prefix string, start, end []byte, fn func([]byte) error) error {
+	return s.db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+		p := []byte(prefix)
+		for it.Seek(start); it.ValidForPrefix(p); it.Next() {
+			item := it.Item()
+			if bytes.Compare(item.Key(), end) > 0 {
+				break
+			}
+			v, err := s.itemValue(item)
+			if err != nil {
+				return err
+			}
+			if err := fn(v); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+//setValue inserts [key] => [val] in tx, sealing val if the store is encrypted.
+//The key is authenticated with the value, so that sealed values can not be
+//moved to other keys.
+func (s *BadgerStore) setValue(tx *badger.Txn, key, val []byte) error {
+	if s.cipher != nil {
+		sealed, err := s.cipher.Seal(val, key)
+		if err != nil {
+			return err
+		}
+		val = sealed
+	}
+	return tx.Set(key, val)
+}
+
+//itemValue reads the value of item, opening it if the store is encrypted
+func (s *BadgerStore) itemValue(item *badger.Item) ([]byte, error) {
+	val, err := item.Value()
+	if err != nil || s.cipher == nil {
+		return val, err
+	}
+	return s.cipher.Open(val, item.Key())
+}
+
 //++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++
 
+func newStoreCipher(key []byte) (*crypto.Cipher, error) {
+	if key == nil {
+		return nil, nil
+	}
+	return crypto.NewCipher(key)
+}
+
 func isDBKeyNotFound(err error) bool {
 	return err.Error() == badger.ErrKeyNotFound.Error()
 }
@@ -1,6 +1,7 @@
 package hashgraph
 
 import (
+	"bytes"
 	"fmt"
 	"io/ioutil"
 	"log"
@@ -9,6 +10,7 @@ import (
 	"testing"
 
 	"github.com/champii/babble/crypto"
+	"github.com/dgraph-io/badger"
 )
 
 func initBadgerStore(cacheSize int, t *testing.T) (*BadgerStore, []pub) {
@@ -590,3 +592,214 @@ func TestBadgerBlocks(t *testing.T) {
 		}
 	})
 }
+
+func TestBadgerDeliveredBlock(t *testing.T) {
+	cacheSize := 100
+	store, _ := initBadgerStore(cacheSize, t)
+	defer os.RemoveAll(store.path)
+
+	if d := store.LastDeliveredBlock(); d != -1 {
+		t.Fatalf("LastDeliveredBlock should be -1, not %d", d)
+	}
+	if err := store.SetLastDeliveredBlock(7); err != nil {
+		t.Fatal(err)
+	}
+	if d := store.LastDeliveredBlock(); d != 7 {
+		t.Fatalf("LastDeliveredBlock should be 7, not %d", d)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The cursor survives a restart
+	loaded, err := LoadBadgerStore(cacheSize, store.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer loaded.Close()
+	if d := loaded.LastDeliveredBlock(); d != 7 {
+		t.Fatalf("LastDeliveredBlock should be 7 after loading, not %d", d)
+	}
+}
+
+func TestBadgerRanges(t *testing.T) {
+	cacheSize := 0
+	store, _ := initBadgerStore(cacheSize, t)
+	defer removeBadgerStore(store, t)
+
+	testSize := 20
+	for i := 0; i < testSize; i++ {
+		block := NewBlock(i, i+1, [][]byte{[]byte(fmt.Sprintf("tx%d", i))})
+		if err := store.SetBlock(block); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.SetRound(i, *NewRoundInfo()); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.AddConsensusEvent(fmt.Sprintf("event%d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	t.Run("Blocks", func(t *testing.T) {
+		blocks, err := store.GetBlocks(5, 14)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(blocks) != 10 {
+			t.Fatalf("There should be 10 blocks, not %d", len(blocks))
+		}
+		for i, b := range blocks {
+			if b.Index() != 5+i {
+				t.Fatalf("blocks[%d] should have index %d, not %d", i, 5+i, b.Index())
+			}
+		}
+
+		blocks, err = store.GetBlocks(15, 100)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(blocks) != 5 {
+			t.Fatalf("There should be 5 blocks, not %d", len(blocks))
+		}
+	})
+
+	t.Run("Rounds", func(t *testing.T) {
+		rounds, err := store.GetRounds(0, testSize-1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(rounds) != testSize {
+			t.Fatalf("There should be %d rounds, not %d", testSize, len(rounds))
+		}
+	})
+
+	t.Run("ConsensusEvents", func(t *testing.T) {
+		events, err := store.ConsensusEventsFrom(8, 5)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(events) != 5 {
+			t.Fatalf("There should be 5 consensus events, not %d", len(events))
+		}
+		for i, e := range events {
+			if e != fmt.Sprintf("event%d", 8+i) {
+				t.Fatalf("events[%d] should be event%d, not %s", i, 8+i, e)
+			}
+		}
+
+		events, err = store.ConsensusEventsFrom(18, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(events) != 2 {
+			t.Fatalf("There should be 2 consensus events, not %d", len(events))
+		}
+	})
+}
+
+func TestEncryptedBadgerStore(t *testing.T) {
+	os.RemoveAll("test_data")
+	os.Mkdir("test_data", os.ModeDir|0777)
+	dbPath := "test_data/badger"
+	defer os.RemoveAll(dbPath)
+
+	key, err := crypto.GenerateStoreKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	participants := map[string]int{
+		"alice":   0,
+		"bob":     1,
+		"charlie": 2,
+	}
+	store, err := NewEncryptedBadgerStore(participants, cacheSize, dbPath, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	secret := []byte("customer data")
+	block := NewBlock(0, 1, [][]byte{secret})
+	if err := store.SetBlock(block); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("Values are not stored in the clear", func(t *testing.T) {
+		err := store.db.View(func(txn *badger.Txn) error {
+			item, err := txn.Get(blockKey(0))
+			if err != nil {
+				return err
+			}
+			raw, err := item.Value()
+			if err != nil {
+				return err
+			}
+			if bytes.Contains(raw, secret) || bytes.Contains(raw, []byte("Transactions")) {
+				t.Fatalf("Stored Block should be encrypted")
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	store.Close()
+
+	t.Run("Load with key", func(t *testing.T) {
+		loadedStore, err := LoadEncryptedBadgerStore(cacheSize, dbPath, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer loadedStore.Close()
+
+		if !reflect.DeepEqual(loadedStore.participants, participants) {
+			t.Fatalf("Participants should be %v, not %v", participants, loadedStore.participants)
+		}
+
+		storedBlock, err := loadedStore.GetBlock(0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(storedBlock, block) {
+			t.Fatalf("Block and StoredBlock do not match")
+		}
+	})
+
+	t.Run("Values can not be moved to another key", func(t *testing.T) {
+		loadedStore, err := LoadEncryptedBadgerStore(cacheSize, dbPath, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer loadedStore.Close()
+
+		err = loadedStore.db.Update(func(txn *badger.Txn) error {
+			item, err := txn.Get(blockKey(0))
+			if err != nil {
+				return err
+			}
+			raw, err := item.Value()
+			if err != nil {
+				return err
+			}
+			return txn.Set(blockKey(1), append([]byte{}, raw...))
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := loadedStore.GetBlock(1); err == nil {
+			t.Fatal("Reading a value moved from another key should fail")
+		}
+	})
+
+	t.Run("Load with wrong key", func(t *testing.T) {
+		wrongKey, _ := crypto.GenerateStoreKey()
+		loadedStore, err := LoadEncryptedBadgerStore(cacheSize, dbPath, wrongKey)
+		if err == nil {
+			loadedStore.Close()
+			t.Fatal("Loading the Store with the wrong key should fail")
+		}
+	})
+}
@@ -0,0 +1,122 @@
+package hashgraph
+
+import (
+	"bufio"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+/*
+A Block archive is a sequence of Blocks written to a stream, in one of two
+formats:
+
+	jsonl:  one JSON encoded Block per line
+	binary: a stream of gob encoded Blocks
+
+Archives are produced by 'babble export-blocks' and consumed by
+'babble replay-blocks'.
+*/
+
+const (
+	JSONLArchive  = "jsonl"
+	BinaryArchive = "binary"
+)
+
+type BlockWriter interface {
+	Write(Block) error
+	Flush() error
+}
+
+type BlockReader interface {
+	//Read returns io.EOF when there are no more Blocks
+	Read() (Block, error)
+}
+
+func NewBlockWriter(w io.Writer, format string) (BlockWriter, error) {
+	bw := bufio.NewWriter(w)
+	switch format {
+	case JSONLArchive:
+		return &jsonlBlockWriter{w: bw, enc: json.NewEncoder(bw)}, nil
+	case BinaryArchive:
+		return &binaryBlockWriter{w: bw, enc: gob.NewEncoder(bw)}, nil
+	default:
+		return nil, fmt.Errorf("unknown archive format %s", format)
+	}
+}
+
+func NewBlockReader(r io.Reader, format string) (BlockReader, error) {
+	br := bufio.NewReader(r)
+	switch format {
+	case JSONLArchive:
+		return &jsonlBlockReader{dec: json.NewDecoder(br)}, nil
+	case BinaryArchive:
+		return &binaryBlockReader{dec: gob.NewDecoder(br)}, nil
+	default:
+		return nil, fmt.Errorf("unknown archive format %s", format)
+	}
+}
+
+//------------------------------------------------------------------------------
+
+type jsonlBlockWriter struct {
+	w   *bufio.Writer
+	enc *json.Encoder
+}
+
+//json.Encoder terminates every value with a newline
+func (bw *jsonlBlockWriter) Write(block Block) error {
+	return bw.enc.Encode(block)
+}
+
+func (bw *jsonlBlockWriter) Flush() error {
+	return bw.w.Flush()
+}
+
+type jsonlBlockReader struct {
+	dec *json.Decoder
+}
+
+func (br *jsonlBlockReader) Read() (Block, error) {
+	var block Block
+	if err := br.dec.Decode(&block); err != nil {
+		return Block{}, err
+	}
+	return normalizeArchivedBlock(block), nil
+}
+
+//------------------------------------------------------------------------------
+
+type binaryBlockWriter struct {
+	w   *bufio.Writer
+	enc *gob.Encoder
+}
+
+func (bw *binaryBlockWriter) Write(block Block) error {
+	return bw.enc.Encode(block)
+}
+
+func (bw *binaryBlockWriter) Flush() error {
+	return bw.w.Flush()
+}
+
+type binaryBlockReader struct {
+	dec *gob.Decoder
+}
+
+func (br *binaryBlockReader) Read() (Block, error) {
+	var block Block
+	if err := br.dec.Decode(&block); err != nil {
+		return Block{}, err
+	}
+	return normalizeArchivedBlock(block), nil
+}
+
+//gob does not transmit empty maps
+func normalizeArchivedBlock(block Block) Block {
+	if block.Signatures == nil {
+		block.Signatures = make(map[string]string)
+	}
+	return block
+}
@@ -0,0 +1,73 @@
+package hashgraph
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"reflect"
+	"testing"
+
+	"github.com/champii/babble/crypto"
+)
+
+func TestBlockArchive(t *testing.T) {
+	privKey, _ := crypto.GenerateECDSAKey()
+
+	blocks := []Block{}
+	for i := 0; i < 10; i++ {
+		block := NewBlock(i, i+3, [][]byte{
+			[]byte(fmt.Sprintf("tx%d_1", i)),
+			[]byte(fmt.Sprintf("tx%d_2", i)),
+		})
+		block.Body.StateHash = crypto.SHA256([]byte(fmt.Sprintf("state%d", i)))
+		//leave the last block unsigned
+		if i < 9 {
+			sig, err := block.Sign(privKey)
+			if err != nil {
+				t.Fatal(err)
+			}
+			block.SetSignature(sig)
+		}
+		blocks = append(blocks, block)
+	}
+
+	for _, format := range []string{JSONLArchive, BinaryArchive} {
+		t.Run(format, func(t *testing.T) {
+			var buf bytes.Buffer
+
+			writer, err := NewBlockWriter(&buf, format)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, b := range blocks {
+				if err := writer.Write(b); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := writer.Flush(); err != nil {
+				t.Fatal(err)
+			}
+
+			reader, err := NewBlockReader(&buf, format)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i, b := range blocks {
+				rb, err := reader.Read()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !reflect.DeepEqual(rb, b) {
+					t.Fatalf("Block %d should be %#v, not %#v", i, b, rb)
+				}
+			}
+			if _, err := reader.Read(); err != io.EOF {
+				t.Fatalf("Reading past the last Block should return io.EOF, not %v", err)
+			}
+		})
+	}
+
+	if _, err := NewBlockWriter(&bytes.Buffer{}, "xml"); err == nil {
+		t.Fatal("Unknown formats should be rejected")
+	}
+}
@@ -3,8 +3,10 @@ package hashgraph
 import (
 	"bytes"
 	"crypto/ecdsa"
+	"encoding/binary"
 	"encoding/json"
 	"fmt"
+	"io"
 	"time"
 
 	"github.com/champii/babble/crypto"
@@ -296,6 +298,143 @@ type WireEvent struct {
 	Signature string
 }
 
+// GobEncode implements the gob.GobEncoder interface. The body is written in
+// a compact binary form that, unlike gob's default, keeps nil and empty slices
+// apart; they do not have the same JSON encoding, and the JSON encoding of the
+// EventBody is what the creator signed.
+func (wb WireBody) GobEncode() ([]byte, error) {
+	var b bytes.Buffer
+	var tmp [binary.MaxVarintLen64]byte
+	putInt := func(v int) {
+		n := binary.PutVarint(tmp[:], int64(v))
+		b.Write(tmp[:n])
+	}
+	//nil slices are written with a length of -1
+	putBytes := func(v []byte) {
+		if v == nil {
+			putInt(-1)
+			return
+		}
+		putInt(len(v))
+		b.Write(v)
+	}
+
+	if wb.Transactions == nil {
+		putInt(-1)
+	} else {
+		putInt(len(wb.Transactions))
+		for _, tx := range wb.Transactions {
+			putBytes(tx)
+		}
+	}
+
+	if wb.BlockSignatures == nil {
+		putInt(-1)
+	} else {
+		putInt(len(wb.BlockSignatures))
+		for _, bs := range wb.BlockSignatures {
+			putInt(bs.Index)
+			putBytes([]byte(bs.Signature))
+		}
+	}
+
+	putInt(wb.SelfParentIndex)
+	putInt(wb.OtherParentCreatorID)
+	putInt(wb.OtherParentIndex)
+	putInt(wb.CreatorID)
+	putInt(wb.Index)
+
+	timestamp, err := wb.Timestamp.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	putBytes(timestamp)
+
+	return b.Bytes(), nil
+}
+
+// GobDecode implements the gob.GobDecoder interface.
+func (wb *WireBody) GobDecode(data []byte) error {
+	r := bytes.NewReader(data)
+	getInt := func() (int, error) {
+		v, err := binary.ReadVarint(r)
+		return int(v), err
+	}
+	getBytes := func() ([]byte, error) {
+		n, err := getInt()
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		if n > r.Len() {
+			return nil, io.ErrUnexpectedEOF
+		}
+		v := make([]byte, n)
+		_, err = io.ReadFull(r, v)
+		return v, err
+	}
+
+	var body WireBody
+
+	n, err := getInt()
+	if err != nil {
+		return err
+	}
+	if n >= 0 {
+		if n > r.Len() {
+			return io.ErrUnexpectedEOF
+		}
+		body.Transactions = make([][]byte, n)
+		for i := range body.Transactions {
+			if body.Transactions[i], err = getBytes(); err != nil {
+				return err
+			}
+		}
+	}
+
+	if n, err = getInt(); err != nil {
+		return err
+	}
+	if n >= 0 {
+		if n > r.Len() {
+			return io.ErrUnexpectedEOF
+		}
+		body.BlockSignatures = make([]WireBlockSignature, n)
+		for i := range body.BlockSignatures {
+			if body.BlockSignatures[i].Index, err = getInt(); err != nil {
+				return err
+			}
+			sig, err := getBytes()
+			if err != nil {
+				return err
+			}
+			body.BlockSignatures[i].Signature = string(sig)
+		}
+	}
+
+	for _, v := range []*int{
+		&body.SelfParentIndex,
+		&body.OtherParentCreatorID,
+		&body.OtherParentIndex,
+		&body.CreatorID,
+		&body.Index,
+	} {
+		if *v, err = getInt(); err != nil {
+			return err
+		}
+	}
+
+	timestamp, err := getBytes()
+	if err != nil {
+		return err
+	}
+	if err := body.Timestamp.UnmarshalBinary(timestamp); err != nil {
+		return err
+	}
+
+	*wb = body
+	return nil
+}
+
 func (we *WireEvent) BlockSignatures(validator []byte) []BlockSignature {
 	if we.Body.BlockSignatures != nil {
 		blockSignatures := make([]BlockSignature, len(we.Body.BlockSignatures))
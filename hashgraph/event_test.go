@@ -1,6 +1,9 @@
 package hashgraph
 
 import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
 	"reflect"
 	"testing"
 	"time"
@@ -138,6 +141,46 @@ func TestWireEvent(t *testing.T) {
 	}
 }
 
+func TestGobWireBody(t *testing.T) {
+	bodies := []WireBody{
+		WireBody{
+			Transactions:         [][]byte{[]byte("abc"), []byte{}, nil},
+			BlockSignatures:      []WireBlockSignature{WireBlockSignature{Index: 3, Signature: "sig"}},
+			SelfParentIndex:      -1,
+			OtherParentCreatorID: 2,
+			OtherParentIndex:     -1,
+			CreatorID:            1,
+			Timestamp:            time.Now().UTC(),
+			Index:                0,
+		},
+		WireBody{
+			Transactions:    [][]byte{},
+			BlockSignatures: []WireBlockSignature{},
+			SelfParentIndex: 5,
+		},
+		WireBody{},
+	}
+
+	for i, body := range bodies {
+		var b bytes.Buffer
+		if err := gob.NewEncoder(&b).Encode(&WireEvent{Body: body, Signature: "s"}); err != nil {
+			t.Fatalf("%d: Error encoding WireEvent: %s", i, err)
+		}
+		var wireEvent WireEvent
+		if err := gob.NewDecoder(&b).Decode(&wireEvent); err != nil {
+			t.Fatalf("%d: Error decoding WireEvent: %s", i, err)
+		}
+
+		//The JSON encoding, which Event signatures are computed from, must not
+		//change
+		expected, _ := json.Marshal(body)
+		got, _ := json.Marshal(wireEvent.Body)
+		if !bytes.Equal(expected, got) {
+			t.Fatalf("%d: WireBody should be %s, not %s", i, expected, got)
+		}
+	}
+}
+
 func TestIsLoaded(t *testing.T) {
 	//nil payload
 	event := NewEvent(nil, nil, []string{"p1", "p2"}, []byte("creator"), 1)
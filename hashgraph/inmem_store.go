@@ -17,6 +17,7 @@ type InmemStore struct {
 	participantEventsCache *ParticipantEventsCache
 	roots                  map[string]Root
 	lastRound              int
+	lastDeliveredBlock     int
 }
 
 func NewInmemStore(participants map[string]int, cacheSize int) *InmemStore {
@@ -32,8 +33,9 @@ func NewInmemStore(participants map[string]int, cacheSize int) *InmemStore {
 		blockCache:             cm.NewLRU(cacheSize, nil),
 		consensusCache:         cm.NewRollingIndex(cacheSize),
 		participantEventsCache: NewParticipantEventsCache(cacheSize, participants),
-		roots:     roots,
-		lastRound: -1,
+		roots:                  roots,
+		lastRound:              -1,
+		lastDeliveredBlock:     -1,
 	}
 }
 
@@ -118,6 +120,23 @@ func (s *InmemStore) ConsensusEventsCount() int {
 	return s.totConsensusEvents
 }
 
+//ConsensusEventsFrom returns at most limit consensus events, starting at
+//position offset in the consensus order. A limit <= 0 means no limit.
+func (s *InmemStore) ConsensusEventsFrom(offset, limit int) ([]string, error) {
+	items, err := s.consensusCache.Get(offset - 1)
+	if err != nil {
+		return []string{}, err
+	}
+	if limit > 0 && len(items) > limit {
+		items = items[:limit]
+	}
+	res := make([]string, len(items))
+	for i, item := range items {
+		res[i] = item.(string)
+	}
+	return res, nil
+}
+
 func (s *InmemStore) AddConsensusEvent(key string) error {
 	s.consensusCache.Set(key, s.totConsensusEvents)
 	s.totConsensusEvents++
@@ -132,6 +151,23 @@ func (s *InmemStore) GetRound(r int) (RoundInfo, error) {
 	return res.(RoundInfo), nil
 }
 
+//GetRounds returns the rounds with from <= index <= to. It stops at the first
+//round that is not in the cache.
+func (s *InmemStore) GetRounds(from, to int) ([]RoundInfo, error) {
+	res := []RoundInfo{}
+	for r := from; r <= to; r++ {
+		round, err := s.GetRound(r)
+		if err != nil {
+			if len(res) == 0 {
+				return res, err
+			}
+			break
+		}
+		res = append(res, round)
+	}
+	return res, nil
+}
+
 func (s *InmemStore) SetRound(r int, round RoundInfo) error {
 	s.roundCache.Add(r, round)
 	if r > s.lastRound {
@@ -176,6 +212,23 @@ func (s *InmemStore) GetBlock(index int) (Block, error) {
 	return res.(Block), nil
 }
 
+//GetBlocks returns the blocks with from <= index <= to. It stops at the first
+//block that is not in the cache.
+func (s *InmemStore) GetBlocks(from, to int) ([]Block, error) {
+	res := []Block{}
+	for i := from; i <= to; i++ {
+		block, err := s.GetBlock(i)
+		if err != nil {
+			if len(res) == 0 {
+				return res, err
+			}
+			break
+		}
+		res = append(res, block)
+	}
+	return res, nil
+}
+
 func (s *InmemStore) SetBlock(block Block) error {
 	_, err := s.GetBlock(block.Index())
 	if err != nil && !cm.Is(err, cm.KeyNotFound) {
@@ -185,6 +238,17 @@ func (s *InmemStore) SetBlock(block Block) error {
 	return nil
 }
 
+//LastDeliveredBlock returns the index of the last Block acknowledged by the
+//App, or -1
+func (s *InmemStore) LastDeliveredBlock() int {
+	return s.lastDeliveredBlock
+}
+
+func (s *InmemStore) SetLastDeliveredBlock(index int) error {
+	s.lastDeliveredBlock = index
+	return nil
+}
+
 func (s *InmemStore) Reset(roots map[string]Root) error {
 	s.roots = roots
 	s.eventCache = cm.NewLRU(s.cacheSize, nil)
@@ -6,6 +6,7 @@ import (
 	"reflect"
 	"testing"
 
+	cm "github.com/champii/babble/common"
 	"github.com/champii/babble/crypto"
 )
 
@@ -230,3 +231,55 @@ func TestInmemBlocks(t *testing.T) {
 		}
 	})
 }
+
+func TestInmemRanges(t *testing.T) {
+	cacheSize := 10
+	testSize := 25
+	store, _ := initInmemStore(cacheSize)
+
+	for i := 0; i < testSize; i++ {
+		block := NewBlock(i, i+1, [][]byte{[]byte(fmt.Sprintf("tx%d", i))})
+		if err := store.SetBlock(block); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.AddConsensusEvent(fmt.Sprintf("event%d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	t.Run("Blocks", func(t *testing.T) {
+		blocks, err := store.GetBlocks(17, 21)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(blocks) != 5 {
+			t.Fatalf("There should be 5 blocks, not %d", len(blocks))
+		}
+		for i, b := range blocks {
+			if b.Index() != 17+i {
+				t.Fatalf("blocks[%d] should have index %d, not %d", i, 17+i, b.Index())
+			}
+		}
+
+		//evicted from the cache
+		if _, err := store.GetBlocks(0, 5); !cm.Is(err, cm.KeyNotFound) {
+			t.Fatalf("GetBlocks(0, 5) should return KeyNotFound, not %v", err)
+		}
+	})
+
+	t.Run("ConsensusEvents", func(t *testing.T) {
+		events, err := store.ConsensusEventsFrom(20, 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := []string{"event20", "event21", "event22"}
+		if !reflect.DeepEqual(events, expected) {
+			t.Fatalf("Consensus events should be %v, not %v", expected, events)
+		}
+
+		//rolled out of the window
+		if _, err := store.ConsensusEventsFrom(0, 3); !cm.Is(err, cm.TooLate) {
+			t.Fatalf("ConsensusEventsFrom(0, 3) should return TooLate, not %v", err)
+		}
+	})
+}
@@ -11,15 +11,20 @@ type Store interface {
 	KnownEvents() map[int]int
 	ConsensusEvents() []string
 	ConsensusEventsCount() int
+	ConsensusEventsFrom(int, int) ([]string, error)
 	AddConsensusEvent(string) error
 	GetRound(int) (RoundInfo, error)
+	GetRounds(int, int) ([]RoundInfo, error)
 	SetRound(int, RoundInfo) error
 	LastRound() int
 	RoundWitnesses(int) []string
 	RoundEvents(int) int
 	GetRoot(string) (Root, error)
 	GetBlock(int) (Block, error)
+	GetBlocks(int, int) ([]Block, error)
 	SetBlock(Block) error
+	LastDeliveredBlock() int
+	SetLastDeliveredBlock(int) error
 	Reset(map[string]Root) error
 	Close() error
 }
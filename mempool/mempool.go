@@ -0,0 +1,260 @@
+package mempool
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/champii/babble/crypto"
+)
+
+var (
+	// ErrPoolFull is returned when a transaction does not fit in the pool. The
+	// App should retry later.
+	ErrPoolFull = errors.New("transaction pool full")
+	// ErrTooLarge is returned for transactions larger than the pool itself.
+	ErrTooLarge = errors.New("transaction larger than the transaction pool")
+	// ErrDuplicate is returned for transactions that are already pending or
+	// were recently added to an Event.
+	ErrDuplicate = errors.New("duplicate transaction")
+	// ErrSubmitterQuota is returned when a submitter already has its share of
+	// the pool.
+	ErrSubmitterQuota = errors.New("too many pending transactions from submitter")
+)
+
+// Limits bound the transactions waiting to be added to an Event. Zero values
+// disable the corresponding limit.
+type Limits struct {
+	MaxTxs          int //pending transactions
+	MaxBytes        int //total size of the pending transactions
+	MaxPerSubmitter int //pending transactions of a single submitter
+}
+
+// DefaultLimits returns the Limits used by nodes unless configured otherwise.
+func DefaultLimits() Limits {
+	return Limits{
+		MaxTxs:   10000,
+		MaxBytes: 32 * 1024 * 1024,
+	}
+}
+
+// Stats reports on the content of a Mempool and the transactions it refused.
+type Stats struct {
+	Txs   int
+	Bytes int
+
+	Added             uint64
+	Duplicates        uint64
+	RejectedFull      uint64 //ErrPoolFull and ErrTooLarge
+	RejectedSubmitter uint64 //ErrSubmitterQuota
+}
+
+//pendingTx is a transaction waiting to be added to an Event
+type pendingTx struct {
+	tx        []byte
+	hash      string
+	submitter string
+}
+
+// Mempool holds the transactions submitted to a node until they are added to
+// one of its Events. It is safe for concurrent use: Apps add transactions while
+// the Core takes them.
+//
+// Transactions are identified by their SHA256 hash. A transaction is refused
+// while the same one is pending, and while it is among the last MaxTxs
+// transactions taken from the pool, so that an App resubmitting a transaction
+// it has no answer for does not get it into consensus twice.
+type Mempool struct {
+	limits Limits
+
+	mu         sync.Mutex
+	pending    []pendingTx
+	hashes     map[string]bool //pending and recent transactions
+	recent     []string        //ring of the hashes of the last flushed transactions
+	next       int             //next slot of recent
+	bytes      int
+	submitters map[string]int //pending transactions by submitter
+	stats      Stats
+
+	addedCh chan struct{}
+}
+
+// NewMempool creates an empty Mempool.
+func NewMempool(limits Limits) *Mempool {
+	return &Mempool{
+		limits:     limits,
+		hashes:     make(map[string]bool),
+		submitters: make(map[string]int),
+		addedCh:    make(chan struct{}, 1),
+	}
+}
+
+// SetLimits changes the Limits of the pool. Transactions already pending are
+// kept.
+func (m *Mempool) SetLimits(limits Limits) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.limits = limits
+}
+
+// Add adds a transaction to the pool. submitter identifies the origin of the
+// transaction for the MaxPerSubmitter limit; the empty submitter is not
+// limited.
+func (m *Mempool) Add(tx []byte, submitter string) error {
+	hash := string(crypto.SHA256(tx))
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	err := m.add(tx, hash, submitter)
+	if err == nil {
+		m.notify()
+	}
+	return err
+}
+
+// AddBatch adds transactions to the pool as Add does, and returns the error of
+// each transaction, nil if it was added. The batch is added atomically: the
+// transactions of the batch that are added are next to each other in the
+// pool, in the order of the batch.
+func (m *Mempool) AddBatch(txs [][]byte, submitter string) []error {
+	hashes := make([]string, len(txs))
+	for i, tx := range txs {
+		hashes[i] = string(crypto.SHA256(tx))
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	errs := make([]error, len(txs))
+	added := false
+	for i, tx := range txs {
+		errs[i] = m.add(tx, hashes[i], submitter)
+		added = added || errs[i] == nil
+	}
+	if added {
+		m.notify()
+	}
+	return errs
+}
+
+//add adds a transaction to the pool unless it is refused. m.mu must be held.
+func (m *Mempool) add(tx []byte, hash string, submitter string) error {
+	if m.hashes[hash] {
+		m.stats.Duplicates++
+		return ErrDuplicate
+	}
+	if m.limits.MaxBytes > 0 && len(tx) > m.limits.MaxBytes {
+		m.stats.RejectedFull++
+		return ErrTooLarge
+	}
+	if (m.limits.MaxTxs > 0 && len(m.pending) >= m.limits.MaxTxs) ||
+		(m.limits.MaxBytes > 0 && m.bytes+len(tx) > m.limits.MaxBytes) {
+		m.stats.RejectedFull++
+		return ErrPoolFull
+	}
+	if submitter != "" && m.limits.MaxPerSubmitter > 0 &&
+		m.submitters[submitter] >= m.limits.MaxPerSubmitter {
+		m.stats.RejectedSubmitter++
+		return ErrSubmitterQuota
+	}
+
+	m.pending = append(m.pending, pendingTx{tx: tx, hash: hash, submitter: submitter})
+	m.hashes[hash] = true
+	m.bytes += len(tx)
+	if submitter != "" {
+		m.submitters[submitter]++
+	}
+	m.stats.Added++
+	return nil
+}
+
+//notify signals AddedCh without blocking
+func (m *Mempool) notify() {
+	select {
+	case m.addedCh <- struct{}{}:
+	default:
+	}
+}
+
+// Pending returns the pending transactions, oldest first, without removing
+// them.
+func (m *Mempool) Pending() [][]byte {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	txs := make([][]byte, len(m.pending))
+	for i, p := range m.pending {
+		txs[i] = p.tx
+	}
+	return txs
+}
+
+// Flush removes the n oldest pending transactions, once they are part of an
+// Event. Their hashes are remembered to refuse resubmissions.
+func (m *Mempool) Flush(n int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if n > len(m.pending) {
+		n = len(m.pending)
+	}
+	for _, p := range m.pending[:n] {
+		m.bytes -= len(p.tx)
+		if p.submitter != "" {
+			if m.submitters[p.submitter]--; m.submitters[p.submitter] == 0 {
+				delete(m.submitters, p.submitter)
+			}
+		}
+		m.remember(p.hash)
+	}
+	m.pending = append([]pendingTx{}, m.pending[n:]...)
+}
+
+//remember keeps hash among the recent hashes, forgetting the oldest one if
+//there are already MaxTxs of them
+func (m *Mempool) remember(hash string) {
+	size := m.limits.MaxTxs
+	if size <= 0 {
+		size = DefaultLimits().MaxTxs
+	}
+	if len(m.recent) < size {
+		m.recent = append(m.recent, hash)
+		return
+	}
+	m.next %= len(m.recent)
+	delete(m.hashes, m.recent[m.next])
+	m.recent[m.next] = hash
+	m.next++
+}
+
+// Len returns the number of pending transactions.
+func (m *Mempool) Len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.pending)
+}
+
+// Full returns true when the pool can not take any more transactions.
+func (m *Mempool) Full() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return (m.limits.MaxTxs > 0 && len(m.pending) >= m.limits.MaxTxs) ||
+		(m.limits.MaxBytes > 0 && m.bytes >= m.limits.MaxBytes)
+}
+
+// AddedCh receives a value after transactions are added to the pool. Values
+// are not queued: one value may stand for several transactions.
+func (m *Mempool) AddedCh() <-chan struct{} {
+	return m.addedCh
+}
+
+// Stats returns a snapshot of the pool's counters.
+func (m *Mempool) Stats() Stats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stats := m.stats
+	stats.Txs = len(m.pending)
+	stats.Bytes = m.bytes
+	return stats
+}
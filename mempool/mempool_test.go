@@ -0,0 +1,155 @@
+package mempool
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestMempoolLimits(t *testing.T) {
+	m := NewMempool(Limits{MaxTxs: 3, MaxBytes: 10})
+
+	if err := m.Add([]byte("0123456789A"), ""); err != ErrTooLarge {
+		t.Fatalf("expected ErrTooLarge, got %v", err)
+	}
+	for _, tx := range []string{"aaa", "bbb", "ccc"} {
+		if err := m.Add([]byte(tx), ""); err != nil {
+			t.Fatalf("%s: %v", tx, err)
+		}
+	}
+	if !m.Full() {
+		t.Fatalf("pool should be full")
+	}
+	if err := m.Add([]byte("d"), ""); err != ErrPoolFull {
+		t.Fatalf("expected ErrPoolFull with 3 txs, got %v", err)
+	}
+
+	m.Flush(1)
+	if err := m.Add([]byte("ddddd"), ""); err != ErrPoolFull {
+		t.Fatalf("expected ErrPoolFull with 10 bytes, got %v", err)
+	}
+	if err := m.Add([]byte("ddd"), ""); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := [][]byte{[]byte("bbb"), []byte("ccc"), []byte("ddd")}
+	if pending := m.Pending(); !reflect.DeepEqual(expected, pending) {
+		t.Fatalf("expected %s, got %s", expected, pending)
+	}
+
+	stats := m.Stats()
+	if stats.Txs != 3 || stats.Bytes != 9 || stats.Added != 4 || stats.RejectedFull != 3 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+}
+
+func TestMempoolDuplicates(t *testing.T) {
+	m := NewMempool(Limits{MaxTxs: 2})
+
+	if err := m.Add([]byte("a"), ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Add([]byte("a"), ""); err != ErrDuplicate {
+		t.Fatalf("expected ErrDuplicate for a pending tx, got %v", err)
+	}
+
+	// Flushed transactions are remembered
+	m.Flush(1)
+	if err := m.Add([]byte("a"), ""); err != ErrDuplicate {
+		t.Fatalf("expected ErrDuplicate for a flushed tx, got %v", err)
+	}
+
+	// Until MaxTxs other transactions are flushed
+	for _, tx := range []string{"b", "c"} {
+		if err := m.Add([]byte(tx), ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	m.Flush(2)
+	if err := m.Add([]byte("a"), ""); err != nil {
+		t.Fatalf("a should have been forgotten: %v", err)
+	}
+	if err := m.Add([]byte("c"), ""); err != ErrDuplicate {
+		t.Fatalf("expected ErrDuplicate, got %v", err)
+	}
+
+	if d := m.Stats().Duplicates; d != 3 {
+		t.Fatalf("expected 3 duplicates, got %d", d)
+	}
+}
+
+func TestMempoolSubmitters(t *testing.T) {
+	m := NewMempool(Limits{MaxPerSubmitter: 2})
+
+	for i := 0; i < 2; i++ {
+		if err := m.Add([]byte(fmt.Sprintf("a%d", i)), "a"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := m.Add([]byte("a2"), "a"); err != ErrSubmitterQuota {
+		t.Fatalf("expected ErrSubmitterQuota, got %v", err)
+	}
+
+	// Other submitters have their own share, the anonymous one has no limit
+	if err := m.Add([]byte("b0"), "b"); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		if err := m.Add([]byte(fmt.Sprintf("x%d", i)), ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	m.Flush(1)
+	if err := m.Add([]byte("a2"), "a"); err != nil {
+		t.Fatalf("a should have room after a flush: %v", err)
+	}
+
+	if r := m.Stats().RejectedSubmitter; r != 1 {
+		t.Fatalf("expected 1 rejection, got %d", r)
+	}
+}
+
+func TestMempoolAddedCh(t *testing.T) {
+	m := NewMempool(DefaultLimits())
+
+	m.Add([]byte("a"), "")
+	m.Add([]byte("b"), "")
+
+	select {
+	case <-m.AddedCh():
+	default:
+		t.Fatalf("expected a notification")
+	}
+	select {
+	case <-m.AddedCh():
+		t.Fatalf("notifications should not be queued")
+	default:
+	}
+}
+
+func TestMempoolAddBatch(t *testing.T) {
+	m := NewMempool(Limits{MaxTxs: 4})
+
+	if err := m.Add([]byte("a"), ""); err != nil {
+		t.Fatal(err)
+	}
+
+	txs := [][]byte{[]byte("b"), []byte("a"), []byte("c"), []byte("b"), []byte("d"), []byte("e")}
+	errs := m.AddBatch(txs, "")
+	expected := []error{nil, ErrDuplicate, nil, ErrDuplicate, nil, ErrPoolFull}
+	if !reflect.DeepEqual(expected, errs) {
+		t.Fatalf("expected %v, got %v", expected, errs)
+	}
+
+	pending := [][]byte{[]byte("a"), []byte("b"), []byte("c"), []byte("d")}
+	if p := m.Pending(); !reflect.DeepEqual(pending, p) {
+		t.Fatalf("expected %s, got %s", pending, p)
+	}
+
+	select {
+	case <-m.AddedCh():
+	default:
+		t.Fatalf("AddedCh should receive a value after a batch")
+	}
+}
@@ -1,6 +1,8 @@
 package mobile
 
 import (
+	"errors"
+
 	"github.com/champii/babble/hashgraph"
 	"github.com/sirupsen/logrus"
 )
@@ -49,3 +51,15 @@ func (p *mobileAppProxy) CommitBlock(block hashgraph.Block) ([]byte, error) {
 	stateHash := p.commitHandler.OnCommit(blockBytes)
 	return stateHash, nil
 }
+
+// GetSnapshot is not supported by mobile Apps, so other nodes can not
+// fast-sync from a mobile node.
+func (p *mobileAppProxy) GetSnapshot(blockIndex int) ([]byte, error) {
+	return nil, errors.New("snapshots are not supported by mobile Apps")
+}
+
+// Restore is not supported by mobile Apps, so a mobile node can not fast-sync
+// from other nodes.
+func (p *mobileAppProxy) Restore(snapshot []byte) ([]byte, error) {
+	return nil, errors.New("snapshots are not supported by mobile Apps")
+}
@@ -8,6 +8,9 @@ type MobileConfig struct {
 	SyncLimit  int    //Max Events per sync
 	StoreType  string //inmem or badger
 	StorePath  string //File containing the Store DB
+	StoreKey   string //Hex-encoded key to encrypt the badger Store (optional)
+	TLS        bool   //Encrypt and authenticate gossip with TLS
+	Advertise  string //IP:Port where other nodes reach this node, if not the bind address (optional)
 }
 
 func NewMobileConfig(heartbeat int,
@@ -16,7 +19,10 @@ func NewMobileConfig(heartbeat int,
 	cacheSize int,
 	syncLimit int,
 	storeType string,
-	storePath string) *MobileConfig {
+	storePath string,
+	storeKey string,
+	tls bool,
+	advertise string) *MobileConfig {
 
 	return &MobileConfig{
 		Heartbeat:  heartbeat,
@@ -26,6 +32,9 @@ func NewMobileConfig(heartbeat int,
 		SyncLimit:  syncLimit,
 		StoreType:  storeType,
 		StorePath:  storePath,
+		StoreKey:   storeKey,
+		TLS:        tls,
+		Advertise:  advertise,
 	}
 }
 
@@ -38,5 +47,8 @@ func DefaultMobileConfig() *MobileConfig {
 		SyncLimit:  1000,
 		StoreType:  "inmem",
 		StorePath:  "",
+		StoreKey:   "",
+		TLS:        false,
+		Advertise:  "",
 	}
 }
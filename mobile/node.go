@@ -32,10 +32,16 @@ func New(privKey string,
 
 	logger := initLogger()
 
+	//Do not log the store key
+	logConfig := *config
+	if logConfig.StoreKey != "" {
+		logConfig.StoreKey = "********"
+	}
+
 	logger.WithFields(logrus.Fields{
 		"nodeAddr": nodeAddr,
 		"peers":    peers,
-		"config":   fmt.Sprintf("%v", config),
+		"config":   fmt.Sprintf("%v", logConfig),
 	}).Debug("New Mobile Node")
 
 	//Check private key
@@ -90,10 +96,16 @@ func New(privKey string,
 	case "inmem":
 		store = hg.NewInmemStore(pmap, conf.CacheSize)
 	case "badger":
+		storeKey, err := crypto.ReadStoreKeyFromBuf([]byte(config.StoreKey))
+		if err != nil {
+			exceptionHandler.OnException(fmt.Sprintf("Invalid StoreKey: %s", err))
+			return nil
+		}
+
 		//If the file already exists, load and bootstrap the store using the file
 		if _, err := os.Stat(conf.StorePath); err == nil {
 			logger.Debug("loading badger store from existing database")
-			store, err = hg.LoadBadgerStore(conf.CacheSize, conf.StorePath)
+			store, err = hg.LoadEncryptedBadgerStore(conf.CacheSize, conf.StorePath, storeKey)
 			if err != nil {
 				exceptionHandler.OnException(fmt.Sprintf("failed to load BadgerStore from existing file: %s", err))
 				return nil
@@ -102,7 +114,7 @@ func New(privKey string,
 		} else {
 			//Otherwise create a new one
 			logger.Debug("creating new badger store from fresh database")
-			store, err = hg.NewBadgerStore(pmap, conf.CacheSize, conf.StorePath)
+			store, err = hg.NewEncryptedBadgerStore(pmap, conf.CacheSize, conf.StorePath, storeKey)
 			if err != nil {
 				exceptionHandler.OnException(fmt.Sprintf("failed to create new BadgerStore: %s", err))
 				return nil
@@ -113,10 +125,25 @@ func New(privKey string,
 		return nil
 	}
 
-	trans, err := net.NewTCPTransport(
-		nodeAddr, nil, config.MaxPool, conf.TCPTimeout, logger)
+	//Addresses learned from other nodes are kept in memory
+	peerStore := &net.StaticPeers{StaticPeers: netPeers}
+
+	advertise, err := net.ResolveAdvertise(config.Advertise)
 	if err != nil {
-		exceptionHandler.OnException(fmt.Sprintf("Creating TCP Transport: %s", err.Error()))
+		exceptionHandler.OnException(fmt.Sprintf("Resolving advertise address: %s", err.Error()))
+		return nil
+	}
+
+	var trans net.Transport
+	if config.TLS {
+		trans, err = net.NewTLSTransport(nodeAddr, advertise, key,
+			peerStore, config.MaxPool, conf.TCPTimeout, logger)
+	} else {
+		trans, err = net.NewTransport(
+			nodeAddr, config.Advertise, config.MaxPool, conf.TCPTimeout, logger)
+	}
+	if err != nil {
+		exceptionHandler.OnException(fmt.Sprintf("Creating Transport: %s", err.Error()))
 		return nil
 	}
 
@@ -124,6 +151,7 @@ func New(privKey string,
 	prox = newMobileAppProxy(commitHandler, exceptionHandler, logger)
 
 	node := node.NewNode(conf, nodeID, key, netPeers, store, trans, prox)
+	node.SetPeerStore(peerStore)
 	if err := node.Init(needBootstrap); err != nil {
 		exceptionHandler.OnException(fmt.Sprintf("Initializing node: %s", err))
 		return nil
@@ -162,3 +190,23 @@ func (n *Node) SubmitTx(tx []byte) {
 	copy(t, tx)
 	n.proxy.SubmitCh() <- t
 }
+
+// SubmitTxs submits a batch of transactions, which the node adds to its pool
+// at once. gomobile can not export arrays of arrays of bytes, so the batch is
+// the JSON encoding of an array of transactions, each a base64 string. The
+// result is the JSON encoding of an array with the error of each transaction,
+// or an empty string if it was accepted.
+func (n *Node) SubmitTxs(batch []byte) ([]byte, error) {
+	var txs [][]byte
+	if err := json.Unmarshal(batch, &txs); err != nil {
+		return nil, err
+	}
+
+	results := make([]string, len(txs))
+	for i, err := range n.node.SubmitTxs(txs, "") {
+		if err != nil {
+			results[i] = err.Error()
+		}
+	}
+	return json.Marshal(results)
+}
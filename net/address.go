@@ -0,0 +1,83 @@
+package net
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Schemes that select the StreamLayer of a transport. Addresses without a
+// scheme are TCP addresses.
+const (
+	TCPScheme  = "tcp"
+	UnixScheme = "unix"
+	PipeScheme = "pipe"
+)
+
+//schemeSep separates the scheme from the rest of an address
+const schemeSep = "://"
+
+// SplitAddr separates an address of the form scheme://address into its scheme
+// and address. The scheme defaults to TCPScheme.
+func SplitAddr(addr string) (scheme string, address string) {
+	i := strings.Index(addr, schemeSep)
+	if i < 0 {
+		return TCPScheme, addr
+	}
+	return addr[:i], addr[i+len(schemeSep):]
+}
+
+//trimScheme removes the scheme prefix of addr if it is scheme
+func trimScheme(addr string, scheme string) string {
+	return strings.TrimPrefix(addr, scheme+schemeSep)
+}
+
+// ResolveAdvertise resolves the address that a TCP node advertises to other
+// nodes, when it differs from the address it listens on, as behind NAT or in
+// a container. It returns nil if advertise is empty.
+func ResolveAdvertise(advertise string) (net.Addr, error) {
+	if advertise == "" {
+		return nil, nil
+	}
+	scheme, address := SplitAddr(advertise)
+	if scheme != TCPScheme {
+		return nil, fmt.Errorf("advertise address must be a TCP address: %s", advertise)
+	}
+	return net.ResolveTCPAddr("tcp", address)
+}
+
+// NewTransport returns a NetworkTransport listening on bindAddr. The
+// StreamLayer is selected by the scheme of bindAddr: tcp:// (the default),
+// unix:// for a Unix domain socket, or pipe:// for in-process pipes between
+// transports of the same program. TCP transports may advertise another
+// address than bindAddr, see ResolveAdvertise; advertise is ignored if empty.
+func NewTransport(
+	bindAddr string,
+	advertise string,
+	maxPool int,
+	timeout time.Duration,
+	logger *logrus.Logger,
+) (*NetworkTransport, error) {
+	scheme, address := SplitAddr(bindAddr)
+	if scheme != TCPScheme && advertise != "" {
+		return nil, fmt.Errorf("%s addresses can not be advertised differently", scheme)
+	}
+
+	switch scheme {
+	case TCPScheme:
+		advertiseAddr, err := ResolveAdvertise(advertise)
+		if err != nil {
+			return nil, err
+		}
+		return NewTCPTransport(address, advertiseAddr, maxPool, timeout, logger)
+	case UnixScheme:
+		return NewUnixTransport(address, maxPool, timeout, logger)
+	case PipeScheme:
+		return NewPipeTransport(address, maxPool, timeout, logger)
+	default:
+		return nil, fmt.Errorf("unsupported address scheme: %s", scheme)
+	}
+}
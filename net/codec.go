@@ -0,0 +1,163 @@
+package net
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/champii/babble/version"
+)
+
+const (
+	// JSONCodec encodes RPCs with encoding/json. It is the only codec spoken
+	// by nodes that predate the handshake, and the fallback for them.
+	JSONCodec = "json"
+
+	// GobCodec encodes RPCs with encoding/gob. Transactions are sent as raw
+	// bytes instead of base64 strings, and type information is only sent once
+	// per connection.
+	GobCodec = "gob"
+
+	// ProtocolVersion is the version of the RPC protocol spoken by this
+	// transport. Two nodes use the lowest of their versions.
+	ProtocolVersion = 5
+
+	//typedErrorsVersion is the first protocol version where errors are sent
+	//as an rpcErrResponse instead of a string
+	typedErrorsVersion = 2
+
+	//streamSyncVersion is the first protocol version that supports the
+	//StreamSync RPC
+	streamSyncVersion = 3
+
+	//compactVersion is the first protocol version where Known maps and Event
+	//indexes are sent in compact form
+	compactVersion = 4
+
+	//fastForwardVersion is the first protocol version that supports the
+	//FastForward and Snapshot RPCs
+	fastForwardVersion = 5
+
+	//minProtocolVersion is the lowest protocol version of the nodes we talk
+	//to with a handshake
+	minProtocolVersion = 1
+
+	//maxHandshakeSize limits the size of a handshake frame
+	maxHandshakeSize = 4096
+)
+
+// DefaultCodecs lists the codecs offered by a NetworkTransport, in order of
+// preference.
+var DefaultCodecs = []string{GobCodec, JSONCodec}
+
+type encoder interface {
+	Encode(v interface{}) error
+}
+
+type decoder interface {
+	Decode(v interface{}) error
+}
+
+func newEncoder(codec string, w io.Writer) (encoder, error) {
+	switch codec {
+	case JSONCodec:
+		return json.NewEncoder(w), nil
+	case GobCodec:
+		return gob.NewEncoder(w), nil
+	default:
+		return nil, fmt.Errorf("unknown codec %s", codec)
+	}
+}
+
+func newDecoder(codec string, r *bufio.Reader) (decoder, error) {
+	switch codec {
+	case JSONCodec:
+		return json.NewDecoder(r), nil
+	case GobCodec:
+		//r is an io.ByteReader so gob does not read ahead of the current
+		//message and the rpc type bytes are left for us
+		return gob.NewDecoder(r), nil
+	default:
+		return nil, fmt.Errorf("unknown codec %s", codec)
+	}
+}
+
+func isKnownCodec(codec string) bool {
+	return codec == JSONCodec || codec == GobCodec
+}
+
+//++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++
+
+//nodeVersion is the software version sent in handshakes
+var nodeVersion = version.Version
+
+//handshake is sent by the dialing side, right after the rpcHandshake byte, on
+//every new connection. Version and NetworkID are empty for nodes that predate
+//them.
+type handshake struct {
+	ProtocolVersion int
+	Codecs          []string
+	Version         string
+	NetworkID       string
+}
+
+//handshakeResponse tells the dialing side which codec and protocol version
+//will be used for the rest of the connection. Incompatible is set when Error
+//comes from checkPeer.
+type handshakeResponse struct {
+	ProtocolVersion int
+	Codec           string
+	Version         string
+	NetworkID       string
+	Error           string
+	Incompatible    bool
+}
+
+//writeFrame writes a length-prefixed JSON object. Unlike json.Encoder, the
+//reading side never consumes more bytes than the frame, which lets us switch
+//codecs right after the handshake.
+func writeFrame(w *bufio.Writer, v interface{}) error {
+	buf, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if err := binary.Write(w, binary.BigEndian, uint32(len(buf))); err != nil {
+		return err
+	}
+	if _, err := w.Write(buf); err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+//readFrame reads a frame written by writeFrame
+func readFrame(r *bufio.Reader, v interface{}) error {
+	var size uint32
+	if err := binary.Read(r, binary.BigEndian, &size); err != nil {
+		return err
+	}
+	if size > maxHandshakeSize {
+		return fmt.Errorf("handshake frame too large: %d bytes", size)
+	}
+	buf := make([]byte, size)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return err
+	}
+	return json.Unmarshal(buf, v)
+}
+
+//selectCodec returns the first codec offered by the remote node that we
+//support
+func selectCodec(offered []string, supported []string) (string, bool) {
+	for _, o := range offered {
+		for _, s := range supported {
+			if o == s {
+				return o, true
+			}
+		}
+	}
+	return "", false
+}
@@ -1,10 +1,45 @@
 package net
 
-import "github.com/champii/babble/hashgraph"
+import (
+	"crypto/ecdsa"
+	"encoding/json"
+	"time"
+
+	"github.com/champii/babble/crypto"
+	"github.com/champii/babble/hashgraph"
+)
 
 type SyncRequest struct {
-	FromID int
-	Known  map[int]int
+	FromID    int
+	ToID      int
+	Timestamp int64 //set by Sign
+	Known     map[int]int
+	Peers     []PeerRecord //individually signed, not covered by Signature
+	Signature string       //sender's signature of FromID, ToID, Timestamp and Known
+}
+
+func (r *SyncRequest) Hash() ([]byte, error) {
+	known := r.Known
+	if known == nil {
+		known = map[int]int{}
+	}
+	return hashRequest(struct {
+		FromID    int
+		ToID      int
+		Timestamp int64
+		Known     map[int]int
+	}{r.FromID, r.ToID, r.Timestamp, known})
+}
+
+func (r *SyncRequest) Sign(privKey *ecdsa.PrivateKey) error {
+	r.Timestamp = time.Now().UnixNano()
+	signature, err := signRequest(r, privKey)
+	r.Signature = signature
+	return err
+}
+
+func (r *SyncRequest) Verify(pubBytes []byte) (bool, error) {
+	return verifyRequest(r, r.Signature, pubBytes)
 }
 
 type SyncResponse struct {
@@ -12,16 +47,306 @@ type SyncResponse struct {
 	SyncLimit bool
 	Events    []hashgraph.WireEvent
 	Known     map[int]int
+	Peers     []PeerRecord
 }
 
 //++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++
 
 type EagerSyncRequest struct {
-	FromID int
-	Events []hashgraph.WireEvent
+	FromID    int
+	ToID      int
+	Timestamp int64 //set by Sign
+	Events    []hashgraph.WireEvent
+	Signature string //sender's signature of FromID, ToID, Timestamp and Event signatures
+}
+
+//Hash only covers the signatures of the Events because the Events are
+//themselves signed by their creators and verified individually
+func (r *EagerSyncRequest) Hash() ([]byte, error) {
+	eventSignatures := make([]string, len(r.Events))
+	for i, e := range r.Events {
+		eventSignatures[i] = e.Signature
+	}
+	return hashRequest(struct {
+		FromID    int
+		ToID      int
+		Timestamp int64
+		Events    []string
+	}{r.FromID, r.ToID, r.Timestamp, eventSignatures})
+}
+
+func (r *EagerSyncRequest) Sign(privKey *ecdsa.PrivateKey) error {
+	r.Timestamp = time.Now().UnixNano()
+	signature, err := signRequest(r, privKey)
+	r.Signature = signature
+	return err
+}
+
+func (r *EagerSyncRequest) Verify(pubBytes []byte) (bool, error) {
+	return verifyRequest(r, r.Signature, pubBytes)
 }
 
 type EagerSyncResponse struct {
 	FromID  int
 	Success bool
 }
+
+//++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++
+
+// StreamSyncRequest asks for the Events that the sender does not know, in
+// chunks of at most ChunkSize Events. Window is the number of chunks that can
+// be sent before the sender acknowledges them.
+type StreamSyncRequest struct {
+	FromID    int
+	ToID      int
+	Timestamp int64 //set by Sign
+	Known     map[int]int
+	ChunkSize int
+	Window    int
+	Signature string //sender's signature of FromID, ToID, Timestamp and Known
+
+	cancelCh chan struct{}
+}
+
+func (r *StreamSyncRequest) Hash() ([]byte, error) {
+	known := r.Known
+	if known == nil {
+		known = map[int]int{}
+	}
+	return hashRequest(struct {
+		FromID    int
+		ToID      int
+		Timestamp int64
+		Known     map[int]int
+	}{r.FromID, r.ToID, r.Timestamp, known})
+}
+
+func (r *StreamSyncRequest) Sign(privKey *ecdsa.PrivateKey) error {
+	r.Timestamp = time.Now().UnixNano()
+	signature, err := signRequest(r, privKey)
+	r.Signature = signature
+	return err
+}
+
+func (r *StreamSyncRequest) Verify(pubBytes []byte) (bool, error) {
+	return verifyRequest(r, r.Signature, pubBytes)
+}
+
+// Canceled returns a channel that is closed when the stream is over, whether
+// all the chunks were sent or not. It is nil for requests that were not
+// received from a transport.
+func (r *StreamSyncRequest) Canceled() <-chan struct{} {
+	return r.cancelCh
+}
+
+// StreamSyncResponse is sent before the chunks. The consumer of a transport
+// feeds the chunks through Chunks, which is never sent over the wire.
+type StreamSyncResponse struct {
+	FromID int
+	Known  map[int]int
+	Chunks <-chan StreamSyncChunk `json:"-"`
+}
+
+// StreamSyncChunk is a batch of Events sent in response to a
+// StreamSyncRequest.
+type StreamSyncChunk struct {
+	Events []hashgraph.WireEvent
+	Done   bool //last chunk of the stream
+
+	err error
+}
+
+// NewStreamSyncErr returns a chunk that ends a stream with an error.
+func NewStreamSyncErr(err error) StreamSyncChunk {
+	return StreamSyncChunk{
+		Done: true,
+		err:  err,
+	}
+}
+
+//++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++
+
+// FastForwardRequest asks a node for the state that a node too far behind can
+// restart from: its last Block and the Frame of its hashgraph.
+type FastForwardRequest struct {
+	FromID    int
+	ToID      int
+	Timestamp int64  //set by Sign
+	Signature string //sender's signature of FromID, ToID and Timestamp
+}
+
+func (r *FastForwardRequest) Hash() ([]byte, error) {
+	return hashRequest(struct {
+		FromID    int
+		ToID      int
+		Timestamp int64
+	}{r.FromID, r.ToID, r.Timestamp})
+}
+
+func (r *FastForwardRequest) Sign(privKey *ecdsa.PrivateKey) error {
+	r.Timestamp = time.Now().UnixNano()
+	signature, err := signRequest(r, privKey)
+	r.Signature = signature
+	return err
+}
+
+func (r *FastForwardRequest) Verify(pubBytes []byte) (bool, error) {
+	return verifyRequest(r, r.Signature, pubBytes)
+}
+
+// FastForwardResponse carries the last Block of a node, the Frame from which
+// the Events that follow it are decided, and the size of the snapshot of the
+// App taken at that Block.
+type FastForwardResponse struct {
+	FromID       int
+	Block        hashgraph.Block
+	Frame        hashgraph.Frame
+	WireInfo     []EventWireInfo //of the Frame Events, in the same order
+	SnapshotSize int
+}
+
+//fastForwardResponse has the fields of FastForwardResponse, without its methods
+type fastForwardResponse FastForwardResponse
+
+// GobEncode implements the gob.GobEncoder interface with the JSON encoding of
+// the response. The Events and the Block are signed in their JSON encoding,
+// which gob does not preserve as it does not keep nil and empty slices apart.
+func (r FastForwardResponse) GobEncode() ([]byte, error) {
+	return json.Marshal(fastForwardResponse(r))
+}
+
+// GobDecode implements the gob.GobDecoder interface.
+func (r *FastForwardResponse) GobDecode(data []byte) error {
+	return json.Unmarshal(data, (*fastForwardResponse)(r))
+}
+
+// EventWireInfo holds the indexes that an Event is sent with as a WireEvent.
+// The receiver of a Frame can not find them for the parents that are outside
+// of the Frame.
+type EventWireInfo struct {
+	SelfParentIndex      int
+	OtherParentCreatorID int
+	OtherParentIndex     int
+	CreatorID            int
+}
+
+// NewEventWireInfo returns the EventWireInfo of an Event.
+func NewEventWireInfo(event hashgraph.Event) EventWireInfo {
+	body := event.ToWire().Body
+	return EventWireInfo{
+		SelfParentIndex:      body.SelfParentIndex,
+		OtherParentCreatorID: body.OtherParentCreatorID,
+		OtherParentIndex:     body.OtherParentIndex,
+		CreatorID:            body.CreatorID,
+	}
+}
+
+// SnapshotRequest asks for the part of the App snapshot taken at BlockIndex
+// that starts at Offset.
+type SnapshotRequest struct {
+	FromID     int
+	ToID       int
+	Timestamp  int64 //set by Sign
+	BlockIndex int
+	Offset     int
+	Signature  string //sender's signature of all the other fields
+}
+
+func (r *SnapshotRequest) Hash() ([]byte, error) {
+	return hashRequest(struct {
+		FromID     int
+		ToID       int
+		Timestamp  int64
+		BlockIndex int
+		Offset     int
+	}{r.FromID, r.ToID, r.Timestamp, r.BlockIndex, r.Offset})
+}
+
+func (r *SnapshotRequest) Sign(privKey *ecdsa.PrivateKey) error {
+	r.Timestamp = time.Now().UnixNano()
+	signature, err := signRequest(r, privKey)
+	r.Signature = signature
+	return err
+}
+
+func (r *SnapshotRequest) Verify(pubBytes []byte) (bool, error) {
+	return verifyRequest(r, r.Signature, pubBytes)
+}
+
+// SnapshotResponse is a chunk of an App snapshot.
+type SnapshotResponse struct {
+	FromID int
+	Chunk  []byte
+	Done   bool //last chunk of the snapshot
+}
+
+//++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++
+
+// SignedRequest is implemented by the requests that nodes sign with their
+// private key so that the receiver can authenticate the sender. The signature
+// also covers the ID of the receiver and the time of signing, so that the
+// receiver can refuse requests meant for another node or replayed later.
+type SignedRequest interface {
+	Sender() int
+	Receiver() int
+	SignedAt() time.Time
+	Hash() ([]byte, error)
+	Verify(pubBytes []byte) (bool, error)
+}
+
+func (r *SyncRequest) Sender() int        { return r.FromID }
+func (r *EagerSyncRequest) Sender() int   { return r.FromID }
+func (r *StreamSyncRequest) Sender() int  { return r.FromID }
+func (r *FastForwardRequest) Sender() int { return r.FromID }
+func (r *SnapshotRequest) Sender() int    { return r.FromID }
+
+func (r *SyncRequest) Receiver() int        { return r.ToID }
+func (r *EagerSyncRequest) Receiver() int   { return r.ToID }
+func (r *StreamSyncRequest) Receiver() int  { return r.ToID }
+func (r *FastForwardRequest) Receiver() int { return r.ToID }
+func (r *SnapshotRequest) Receiver() int    { return r.ToID }
+
+func (r *SyncRequest) SignedAt() time.Time        { return time.Unix(0, r.Timestamp) }
+func (r *EagerSyncRequest) SignedAt() time.Time   { return time.Unix(0, r.Timestamp) }
+func (r *StreamSyncRequest) SignedAt() time.Time  { return time.Unix(0, r.Timestamp) }
+func (r *FastForwardRequest) SignedAt() time.Time { return time.Unix(0, r.Timestamp) }
+func (r *SnapshotRequest) SignedAt() time.Time    { return time.Unix(0, r.Timestamp) }
+
+func hashRequest(v interface{}) ([]byte, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return crypto.SHA256(b), nil
+}
+
+func signRequest(r SignedRequest, privKey *ecdsa.PrivateKey) (string, error) {
+	hash, err := r.Hash()
+	if err != nil {
+		return "", err
+	}
+	R, S, err := crypto.Sign(privKey, hash)
+	if err != nil {
+		return "", err
+	}
+	return crypto.EncodeSignature(R, S), nil
+}
+
+func verifyRequest(r SignedRequest, signature string, pubBytes []byte) (bool, error) {
+	pubKey := crypto.ToECDSAPub(pubBytes)
+	if pubKey == nil {
+		return false, nil
+	}
+
+	hash, err := r.Hash()
+	if err != nil {
+		return false, err
+	}
+
+	R, S, err := crypto.DecodeSignature(signature)
+	if err != nil {
+		return false, err
+	}
+
+	return crypto.Verify(pubKey, hash, R, S), nil
+}
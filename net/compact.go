@@ -0,0 +1,309 @@
+package net
+
+import (
+	"errors"
+
+	"github.com/champii/babble/hashgraph"
+)
+
+var errNoBaseVector = errors.New("delta known vector without a base vector")
+
+/*
+Since protocol version 4, RPCs are rewritten before they reach the codec to
+shrink the parts that are repeated in every gossip:
+
+- Known maps are sent as a knownVector, a dense array ordered by participant
+ID. Once a vector has been sent on a connection, the next vector sent in the
+same direction holds the differences with it, which are mostly small numbers
+or zeros. Both ends keep the last vector of each direction, so a new connection
+starts over with absolute values.
+
+- The index fields of WireEvents are sent relative to other indexes: Index to
+the Index of the previous Event of the same creator in the batch,
+SelfParentIndex to the Event's own Index, and OtherParentIndex to the previous
+OtherParentIndex referring to the same creator in the batch.
+
+The receiving end restores the original values, so signatures are computed
+and verified on the same data as with older versions.
+*/
+
+// knownVector is the compact form of a map of known Event indexes by
+// participant ID.
+type knownVector struct {
+	Delta  bool        `json:"d,omitempty"` //Values are relative to the previous vector
+	Values []int       `json:"v,omitempty"` //one value per participant, by ID
+	Sparse map[int]int `json:"s,omitempty"` //used instead of Values if the IDs are not 0..n-1
+}
+
+//vectorState holds the last dense vector sent or received in one direction of
+//a connection
+type vectorState struct {
+	last []int
+}
+
+//encode returns the compact form of known and remembers it
+func (s *vectorState) encode(known map[int]int) knownVector {
+	if len(known) == 0 {
+		return knownVector{}
+	}
+
+	values := make([]int, len(known))
+	for id, index := range known {
+		if id < 0 || id >= len(values) {
+			return knownVector{Sparse: known}
+		}
+		values[id] = index
+	}
+
+	last := s.last
+	s.last = values
+	if len(last) != len(values) {
+		return knownVector{Values: values}
+	}
+
+	delta := make([]int, len(values))
+	for i := range values {
+		delta[i] = values[i] - last[i]
+	}
+	return knownVector{Delta: true, Values: delta}
+}
+
+//decode restores the map encoded by the other end of the connection
+func (s *vectorState) decode(v knownVector) (map[int]int, error) {
+	if v.Sparse != nil {
+		return v.Sparse, nil
+	}
+	if len(v.Values) == 0 {
+		return nil, nil
+	}
+
+	values := v.Values
+	if v.Delta {
+		if len(s.last) != len(values) {
+			return nil, errNoBaseVector
+		}
+		values = make([]int, len(v.Values))
+		for i := range values {
+			values[i] = s.last[i] + v.Values[i]
+		}
+	}
+	s.last = values
+
+	known := make(map[int]int, len(values))
+	for id, index := range values {
+		known[id] = index
+	}
+	return known, nil
+}
+
+//compactEvents returns a copy of events with relative index fields
+func compactEvents(events []hashgraph.WireEvent) []hashgraph.WireEvent {
+	if events == nil {
+		return nil
+	}
+
+	lastIndex := make(map[int]int)
+	lastOther := make(map[int]int)
+
+	res := make([]hashgraph.WireEvent, len(events))
+	for i, e := range events {
+		body := e.Body
+		body.Index = e.Body.Index - lastIndex[e.Body.CreatorID]
+		body.SelfParentIndex = e.Body.Index - e.Body.SelfParentIndex
+		body.OtherParentIndex = e.Body.OtherParentIndex - lastOther[e.Body.OtherParentCreatorID]
+
+		lastIndex[e.Body.CreatorID] = e.Body.Index
+		lastOther[e.Body.OtherParentCreatorID] = e.Body.OtherParentIndex
+
+		res[i] = hashgraph.WireEvent{
+			Body:      body,
+			Signature: e.Signature,
+		}
+	}
+	return res
+}
+
+//expandEvents restores the index fields of events produced by compactEvents,
+//in place
+func expandEvents(events []hashgraph.WireEvent) {
+	lastIndex := make(map[int]int)
+	lastOther := make(map[int]int)
+
+	for i := range events {
+		body := &events[i].Body
+		body.Index += lastIndex[body.CreatorID]
+		body.SelfParentIndex = body.Index - body.SelfParentIndex
+		body.OtherParentIndex += lastOther[body.OtherParentCreatorID]
+
+		lastIndex[body.CreatorID] = body.Index
+		lastOther[body.OtherParentCreatorID] = body.OtherParentIndex
+	}
+}
+
+//++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++
+
+//Wire forms of the RPCs that carry Known maps
+
+type compactSyncRequest struct {
+	FromID    int
+	ToID      int
+	Timestamp int64
+	Known     knownVector
+	Peers     []PeerRecord
+	Signature string
+}
+
+type compactSyncResponse struct {
+	FromID    int
+	SyncLimit bool
+	Events    []hashgraph.WireEvent
+	Known     knownVector
+	Peers     []PeerRecord
+}
+
+type compactStreamSyncRequest struct {
+	FromID    int
+	ToID      int
+	Timestamp int64
+	Known     knownVector
+	ChunkSize int
+	Window    int
+	Signature string
+}
+
+type compactStreamSyncResponse struct {
+	FromID int
+	Known  knownVector
+}
+
+//compactEncoder rewrites RPCs into their compact form before encoding them.
+//Other values are encoded as they are.
+type compactEncoder struct {
+	enc   encoder
+	known vectorState
+}
+
+func newCompactEncoder(enc encoder) *compactEncoder {
+	return &compactEncoder{enc: enc}
+}
+
+func (c *compactEncoder) Encode(v interface{}) error {
+	switch m := v.(type) {
+	case *SyncRequest:
+		return c.enc.Encode(&compactSyncRequest{
+			FromID:    m.FromID,
+			ToID:      m.ToID,
+			Timestamp: m.Timestamp,
+			Known:     c.known.encode(m.Known),
+			Peers:     m.Peers,
+			Signature: m.Signature,
+		})
+	case *SyncResponse:
+		return c.enc.Encode(&compactSyncResponse{
+			FromID:    m.FromID,
+			SyncLimit: m.SyncLimit,
+			Events:    compactEvents(m.Events),
+			Known:     c.known.encode(m.Known),
+			Peers:     m.Peers,
+		})
+	case *EagerSyncRequest:
+		req := *m
+		req.Events = compactEvents(m.Events)
+		return c.enc.Encode(&req)
+	case *StreamSyncRequest:
+		return c.enc.Encode(&compactStreamSyncRequest{
+			FromID:    m.FromID,
+			ToID:      m.ToID,
+			Timestamp: m.Timestamp,
+			Known:     c.known.encode(m.Known),
+			ChunkSize: m.ChunkSize,
+			Window:    m.Window,
+			Signature: m.Signature,
+		})
+	case *StreamSyncResponse:
+		return c.enc.Encode(&compactStreamSyncResponse{
+			FromID: m.FromID,
+			Known:  c.known.encode(m.Known),
+		})
+	case *StreamSyncChunk:
+		chunk := *m
+		chunk.Events = compactEvents(m.Events)
+		return c.enc.Encode(&chunk)
+	default:
+		return c.enc.Encode(v)
+	}
+}
+
+//compactDecoder decodes RPCs written by a compactEncoder
+type compactDecoder struct {
+	dec   decoder
+	known vectorState
+}
+
+func newCompactDecoder(dec decoder) *compactDecoder {
+	return &compactDecoder{dec: dec}
+}
+
+func (c *compactDecoder) Decode(v interface{}) (err error) {
+	switch m := v.(type) {
+	case *SyncRequest:
+		var req compactSyncRequest
+		if err := c.dec.Decode(&req); err != nil {
+			return err
+		}
+		m.FromID = req.FromID
+		m.ToID = req.ToID
+		m.Timestamp = req.Timestamp
+		m.Peers = req.Peers
+		m.Signature = req.Signature
+		m.Known, err = c.known.decode(req.Known)
+		return err
+	case *SyncResponse:
+		var resp compactSyncResponse
+		if err := c.dec.Decode(&resp); err != nil {
+			return err
+		}
+		expandEvents(resp.Events)
+		m.FromID = resp.FromID
+		m.SyncLimit = resp.SyncLimit
+		m.Events = resp.Events
+		m.Peers = resp.Peers
+		m.Known, err = c.known.decode(resp.Known)
+		return err
+	case *EagerSyncRequest:
+		if err := c.dec.Decode(m); err != nil {
+			return err
+		}
+		expandEvents(m.Events)
+		return nil
+	case *StreamSyncRequest:
+		var req compactStreamSyncRequest
+		if err := c.dec.Decode(&req); err != nil {
+			return err
+		}
+		m.FromID = req.FromID
+		m.ToID = req.ToID
+		m.Timestamp = req.Timestamp
+		m.ChunkSize = req.ChunkSize
+		m.Window = req.Window
+		m.Signature = req.Signature
+		m.Known, err = c.known.decode(req.Known)
+		return err
+	case *StreamSyncResponse:
+		var resp compactStreamSyncResponse
+		if err := c.dec.Decode(&resp); err != nil {
+			return err
+		}
+		m.FromID = resp.FromID
+		m.Known, err = c.known.decode(resp.Known)
+		return err
+	case *StreamSyncChunk:
+		if err := c.dec.Decode(m); err != nil {
+			return err
+		}
+		expandEvents(m.Events)
+		return nil
+	default:
+		return c.dec.Decode(v)
+	}
+}
@@ -0,0 +1,217 @@
+package net
+
+import (
+	"bufio"
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/champii/babble/hashgraph"
+)
+
+func TestKnownVector(t *testing.T) {
+	var sent, received vectorState
+
+	for i, known := range []map[int]int{
+		{0: 10, 1: 20, 2: -1},
+		{0: 12, 1: 20, 2: 0},
+		{0: 12, 1: 25, 2: 3, 3: -1},
+		nil,
+		{0: 13, 1: 25, 2: 3, 3: 1},
+		{1: 5, 7: 3},
+	} {
+		v := sent.encode(known)
+		switch i {
+		case 1, 4:
+			if !v.Delta {
+				t.Fatalf("vector %d should be a delta", i)
+			}
+		case 5:
+			if v.Sparse == nil {
+				t.Fatalf("vector %d should be sparse", i)
+			}
+		default:
+			if v.Delta {
+				t.Fatalf("vector %d should not be a delta", i)
+			}
+		}
+
+		res, err := received.decode(v)
+		if err != nil {
+			t.Fatalf("vector %d: %v", i, err)
+		}
+		if !reflect.DeepEqual(known, res) {
+			t.Fatalf("vector %d: expected %v, got %v", i, known, res)
+		}
+	}
+
+	// A delta can not be applied without the vector it is based on
+	var fresh vectorState
+	if _, err := fresh.decode(knownVector{Delta: true, Values: []int{1}}); err != errNoBaseVector {
+		t.Fatalf("expected errNoBaseVector, got %v", err)
+	}
+}
+
+func TestCompactEvents(t *testing.T) {
+	events := testGossip(4, 1, 32)[0].response.Events
+
+	compact := compactEvents(events)
+	if reflect.DeepEqual(events, compact) {
+		t.Fatalf("events were not compacted")
+	}
+	if !reflect.DeepEqual(events, testGossip(4, 1, 32)[0].response.Events) {
+		t.Fatalf("compactEvents modified its input")
+	}
+
+	expandEvents(compact)
+	if !reflect.DeepEqual(events, compact) {
+		t.Fatalf("expected %#v, got %#v", events, compact)
+	}
+}
+
+//TestCompactSavings measures the bytes sent per gossip with and without the
+//compact form, over one connection, and checks that the RPCs are restored.
+//Run with -v to see the measurements.
+func TestCompactSavings(t *testing.T) {
+	for _, c := range []struct {
+		participants int
+		events       int
+	}{
+		{4, 0},
+		{4, 2},
+		{32, 0},
+		{32, 8},
+		{32, 64},
+	} {
+		gossips := testGossip(c.participants, 50, c.events)
+
+		for _, codec := range []string{JSONCodec, GobCodec} {
+			plain := encodedSize(t, codec, gossips, false)
+			compact := encodedSize(t, codec, gossips, true)
+			if compact >= plain {
+				t.Fatalf("%s, %d participants, %d events: compact form is not smaller (%d >= %d bytes)",
+					codec, c.participants, c.events, compact, plain)
+			}
+			t.Logf("%s, %d participants, %d events: %d bytes per gossip, %d in compact form (%d saved, %.0f%%)",
+				codec, c.participants, c.events, plain/len(gossips), compact/len(gossips),
+				(plain-compact)/len(gossips), 100*float64(plain-compact)/float64(plain))
+		}
+	}
+}
+
+type testSync struct {
+	request  SyncRequest
+	response SyncResponse
+}
+
+//testGossip returns a series of syncs between two nodes where
+//eventsPerSync Events are created between syncs, by the participants in turn
+func testGossip(participants int, syncs int, eventsPerSync int) []testSync {
+	known := make(map[int]int)
+	for id := 0; id < participants; id++ {
+		known[id] = 1000 + id
+	}
+
+	res := make([]testSync, syncs)
+	for s := range res {
+		res[s].request = SyncRequest{FromID: 0, Known: copyKnown(known), Signature: "request-signature"}
+
+		var events []hashgraph.WireEvent
+		for i := 0; i < eventsPerSync; i++ {
+			id := (s*eventsPerSync + i) % participants
+			other := (id + 1) % participants
+			known[id]++
+			events = append(events, hashgraph.WireEvent{
+				Body: hashgraph.WireBody{
+					Transactions:         [][]byte{[]byte("tx")},
+					SelfParentIndex:      known[id] - 1,
+					OtherParentCreatorID: other,
+					OtherParentIndex:     known[other],
+					CreatorID:            id,
+					Timestamp:            time.Unix(1500000000, int64(s*1000+i)).UTC(),
+					Index:                known[id],
+				},
+				Signature: "event-signature",
+			})
+		}
+		res[s].response = SyncResponse{FromID: 1, Events: events, Known: copyKnown(known)}
+	}
+	return res
+}
+
+func copyKnown(known map[int]int) map[int]int {
+	res := make(map[int]int, len(known))
+	for k, v := range known {
+		res[k] = v
+	}
+	return res
+}
+
+//countingWriter counts the bytes written through it
+type countingWriter struct {
+	w     *bytes.Buffer
+	count int
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	c.count += len(p)
+	return c.w.Write(p)
+}
+
+//testPipe is one direction of a connection
+type testPipe struct {
+	out *countingWriter
+	enc encoder
+	dec decoder
+}
+
+func newTestPipe(t *testing.T, codec string, compact bool) *testPipe {
+	buf := &bytes.Buffer{}
+	p := &testPipe{out: &countingWriter{w: buf}}
+
+	var err error
+	if p.enc, err = newEncoder(codec, p.out); err != nil {
+		t.Fatal(err)
+	}
+	if p.dec, err = newDecoder(codec, bufio.NewReader(buf)); err != nil {
+		t.Fatal(err)
+	}
+	if compact {
+		p.enc = newCompactEncoder(p.enc)
+		p.dec = newCompactDecoder(p.dec)
+	}
+	return p
+}
+
+//encodedSize sends the syncs over a connection using codec and returns the
+//number of bytes written. The syncs are decoded on the other end and compared.
+func encodedSize(t *testing.T, codec string, syncs []testSync, compact bool) int {
+	requests := newTestPipe(t, codec, compact)
+	responses := newTestPipe(t, codec, compact)
+
+	for i, s := range syncs {
+		if err := requests.enc.Encode(&s.request); err != nil {
+			t.Fatal(err)
+		}
+		var req SyncRequest
+		if err := requests.dec.Decode(&req); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(s.request, req) {
+			t.Fatalf("sync %d: request mismatch: %#v %#v", i, s.request, req)
+		}
+
+		if err := responses.enc.Encode(&s.response); err != nil {
+			t.Fatal(err)
+		}
+		var resp SyncResponse
+		if err := responses.dec.Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(s.response, resp) {
+			t.Fatalf("sync %d: response mismatch", i)
+		}
+	}
+	return requests.out.count + responses.out.count
+}
@@ -0,0 +1,145 @@
+package net
+
+import "fmt"
+
+type AuthErrType uint32
+
+const (
+	UnknownSender AuthErrType = iota
+	InvalidSignature
+	//WrongReceiver means the request was signed for another node
+	WrongReceiver
+	//StaleRequest means the request was signed too long ago, or was already
+	//received
+	StaleRequest
+)
+
+// AuthErr is returned when the sender of a request can not be authenticated.
+type AuthErr struct {
+	errType AuthErrType
+	fromID  int
+}
+
+func NewAuthErr(errType AuthErrType, fromID int) AuthErr {
+	return AuthErr{
+		errType: errType,
+		fromID:  fromID,
+	}
+}
+
+func (e AuthErr) Error() string {
+	m := ""
+	switch e.errType {
+	case UnknownSender:
+		m = "Unknown Sender"
+	case InvalidSignature:
+		m = "Invalid Signature"
+	case WrongReceiver:
+		m = "Wrong Receiver"
+	case StaleRequest:
+		m = "Stale Request"
+	}
+
+	return fmt.Sprintf("%d, %s", e.fromID, m)
+}
+
+func IsAuthErr(err error, t AuthErrType) bool {
+	authErr, ok := err.(AuthErr)
+	return ok && authErr.errType == t
+}
+
+//++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++
+
+// IncompatibleErr is returned when a handshake fails because the nodes do not
+// belong to the same network or do not speak a common protocol version.
+type IncompatibleErr struct {
+	Reason string
+}
+
+func (e IncompatibleErr) Error() string {
+	return fmt.Sprintf("incompatible peer: %s", e.Reason)
+}
+
+type RPCErrType uint32
+
+const (
+	//Internal is used for errors that do not have a more specific type,
+	//including errors from nodes that do not send typed errors
+	Internal RPCErrType = iota + 1
+	//NotBabbling means the remote node is not in the Babbling state
+	NotBabbling
+	//TooLate means the requested Events are no longer in the caches of the
+	//remote node
+	TooLate
+	//UnknownParticipant means the remote node does not know a participant
+	//referenced by the request
+	UnknownParticipant
+	//InvalidEvent means the remote node could not insert the Events of the
+	//request in its hashgraph
+	InvalidEvent
+	//Overloaded means the remote node could not process the request in time
+	Overloaded
+	//Unauthenticated means the signature of the request is invalid
+	Unauthenticated
+)
+
+// RPCErr is an error returned by a remote node in response to an RPC.
+type RPCErr struct {
+	errType RPCErrType
+	msg     string
+}
+
+func NewRPCErr(errType RPCErrType, msg string) RPCErr {
+	return RPCErr{
+		errType: errType,
+		msg:     msg,
+	}
+}
+
+func (e RPCErr) Error() string {
+	m := ""
+	switch e.errType {
+	case NotBabbling:
+		m = "Not Babbling"
+	case TooLate:
+		m = "Too Late"
+	case UnknownParticipant:
+		m = "Unknown Participant"
+	case InvalidEvent:
+		m = "Invalid Event"
+	case Overloaded:
+		m = "Overloaded"
+	case Unauthenticated:
+		m = "Unauthenticated"
+	default:
+		m = "Internal"
+	}
+
+	return fmt.Sprintf("%s, %s", m, e.msg)
+}
+
+// Type returns the type of the error.
+func (e RPCErr) Type() RPCErrType {
+	return e.errType
+}
+
+func IsRPCErr(err error, t RPCErrType) bool {
+	rpcErr, ok := err.(RPCErr)
+	return ok && rpcErr.errType == t
+}
+
+//toRPCErr converts any error returned by the consumer of a transport to an
+//RPCErr
+func toRPCErr(err error) RPCErr {
+	if rpcErr, ok := err.(RPCErr); ok {
+		return rpcErr
+	}
+	return NewRPCErr(Internal, err.Error())
+}
+
+//rpcErrResponse is how an RPCErr travels over the wire. A zero Code means no
+//error.
+type rpcErrResponse struct {
+	Code    RPCErrType
+	Message string
+}
@@ -0,0 +1,33 @@
+package net
+
+import "errors"
+
+// ErrFastForwardUnsupported is returned by FastForward and Snapshot when the
+// remote node does not support them.
+var ErrFastForwardUnsupported = errors.New("remote node does not support FastForward")
+
+// FastForward implements the WithFastForward interface.
+func (n *NetworkTransport) FastForward(target string, args *FastForwardRequest, resp *FastForwardResponse) error {
+	return n.fastForwardRPC(target, rpcFastForward, args, resp)
+}
+
+// Snapshot implements the WithFastForward interface.
+func (n *NetworkTransport) Snapshot(target string, args *SnapshotRequest, resp *SnapshotResponse) error {
+	return n.fastForwardRPC(target, rpcSnapshot, args, resp)
+}
+
+// fastForwardRPC is a genericRPC for the RPCs of protocol version
+// fastForwardVersion. The version of the remote node is only known once we
+// have a connection to it.
+func (n *NetworkTransport) fastForwardRPC(target string, rpcType uint8, args interface{}, resp interface{}) error {
+	conn, err := n.getConn(target, n.timeout)
+	if err != nil {
+		return err
+	}
+	supported := conn.fastForward()
+	n.returnConn(conn)
+	if !supported {
+		return ErrFastForwardUnsupported
+	}
+	return n.genericRPC(target, rpcType, args, resp)
+}
@@ -0,0 +1,122 @@
+package net
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/champii/babble/common"
+	"github.com/champii/babble/crypto"
+	"github.com/champii/babble/hashgraph"
+)
+
+func TestNetworkTransport_FastForward(t *testing.T) {
+	// Transport 1 is consumer
+	trans1, err := NewTCPTransport("127.0.0.1:0", nil, 2, time.Second, common.NewTestLogger(t))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer trans1.Close()
+	rpcCh := trans1.Consumer()
+
+	key, _ := crypto.GenerateECDSAKey()
+	pub := crypto.FromECDSAPub(&key.PublicKey)
+
+	//Empty slices and nil ones do not have the same JSON encoding, which is
+	//what the Event signature covers
+	event := hashgraph.NewEvent([][]byte{}, []hashgraph.BlockSignature{}, []string{"", ""}, pub, 0)
+	if err := event.Sign(key); err != nil {
+		t.Fatal(err)
+	}
+	event.SetWireInfo(-1, -1, -1, 0)
+
+	block := hashgraph.NewBlock(3, 2, [][]byte{[]byte("tx")})
+	block.Body.StateHash = []byte("state")
+	sig, err := block.Sign(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block.SetSignature(sig)
+
+	ffResp := FastForwardResponse{
+		FromID: 1,
+		Block:  block,
+		Frame: hashgraph.Frame{
+			Roots:  map[string]hashgraph.Root{event.Creator(): hashgraph.NewBaseRoot()},
+			Events: []hashgraph.Event{event},
+		},
+		WireInfo:     []EventWireInfo{NewEventWireInfo(event)},
+		SnapshotSize: 8,
+	}
+	snapResp := SnapshotResponse{
+		FromID: 1,
+		Chunk:  []byte("snapshot"),
+		Done:   true,
+	}
+
+	// Listen for the requests
+	go func() {
+		for i := 0; i < 2; i++ {
+			select {
+			case rpc := <-rpcCh:
+				switch req := rpc.Command.(type) {
+				case *FastForwardRequest:
+					rpc.Respond(&ffResp, nil)
+				case *SnapshotRequest:
+					if req.BlockIndex != 3 || req.Offset != 0 {
+						t.Errorf("unexpected SnapshotRequest: %#v", *req)
+					}
+					rpc.Respond(&snapResp, nil)
+				}
+			case <-time.After(200 * time.Millisecond):
+				t.Errorf("timeout")
+				return
+			}
+		}
+	}()
+
+	// Transport 2 makes outbound requests
+	trans2, err := NewTCPTransport("127.0.0.1:0", nil, 2, time.Second, common.NewTestLogger(t))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer trans2.Close()
+
+	var out FastForwardResponse
+	if err := trans2.FastForward(trans1.LocalAddr(), &FastForwardRequest{FromID: 0}, &out); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// The Block and the Events keep their hashes and signatures
+	outSig, err := out.Block.GetSignature(sig.ValidatorHex())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := out.Block.Verify(outSig); err != nil || !ok {
+		t.Fatalf("Block signature should be valid")
+	}
+	if !bytes.Equal(out.Block.StateHash(), block.StateHash()) {
+		t.Fatalf("state hash should be %s, not %s", block.StateHash(), out.Block.StateHash())
+	}
+	if len(out.Frame.Events) != 1 || out.Frame.Events[0].Hex() != event.Hex() {
+		t.Fatalf("Frame Event mismatch")
+	}
+	if ok, err := out.Frame.Events[0].Verify(); err != nil || !ok {
+		t.Fatalf("Frame Event signature should be valid")
+	}
+	if !reflect.DeepEqual(out.Frame.Roots, ffResp.Frame.Roots) {
+		t.Fatalf("Roots mismatch: %#v %#v", ffResp.Frame.Roots, out.Frame.Roots)
+	}
+	if !reflect.DeepEqual(out.WireInfo, ffResp.WireInfo) || out.SnapshotSize != 8 {
+		t.Fatalf("response mismatch: %#v", out)
+	}
+
+	var chunk SnapshotResponse
+	if err := trans2.Snapshot(trans1.LocalAddr(), &SnapshotRequest{FromID: 0, BlockIndex: 3}, &chunk); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if !reflect.DeepEqual(chunk, snapResp) {
+		t.Fatalf("command mismatch: %#v %#v", snapResp, chunk)
+	}
+}
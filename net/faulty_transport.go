@@ -0,0 +1,273 @@
+package net
+
+import (
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrPartitioned is returned when the target of a request is on the other
+	// side of a partition.
+	ErrPartitioned = errors.New("target is partitioned")
+
+	// ErrDropped is returned when a request or its response was dropped.
+	ErrDropped = errors.New("message dropped")
+)
+
+// FaultConfig describes the faults injected by a FaultyTransport. The zero
+// value injects no fault.
+type FaultConfig struct {
+	Latency       time.Duration //added to every request
+	Jitter        time.Duration //random extra latency, up to Jitter
+	DropRate      float64       //probability that a request or its response is lost
+	DuplicateRate float64       //probability that a request is delivered twice
+}
+
+// FaultStats counts the faults injected by a FaultyTransport.
+type FaultStats struct {
+	Requests    int
+	Partitioned int
+	Dropped     int
+	Duplicated  int
+}
+
+// FaultyTransport wraps a Transport and injects network faults in the
+// requests it sends: latency, jitter, drops, duplicates and partitions. Faults
+// only apply to outgoing requests, so partitions are asymmetric: a node that is
+// cut from a target can still be reached by that target. Random decisions are
+// drawn from a seeded source so that a failing scenario can be reproduced, as
+// far as goroutine scheduling allows.
+type FaultyTransport struct {
+	trans Transport
+
+	config      FaultConfig
+	partitioned map[string]bool
+	stats       FaultStats
+	rand        *rand.Rand
+	l           sync.Mutex
+}
+
+// NewFaultyTransport wraps trans in a FaultyTransport.
+func NewFaultyTransport(trans Transport, config FaultConfig, seed int64) *FaultyTransport {
+	return &FaultyTransport{
+		trans:       trans,
+		config:      config,
+		partitioned: make(map[string]bool),
+		rand:        rand.New(rand.NewSource(seed)),
+	}
+}
+
+// SetConfig changes the faults injected in the next requests.
+func (f *FaultyTransport) SetConfig(config FaultConfig) {
+	f.l.Lock()
+	defer f.l.Unlock()
+	f.config = config
+}
+
+// Partition cuts this transport from the targets. Requests to them fail with
+// ErrPartitioned.
+func (f *FaultyTransport) Partition(targets ...string) {
+	f.l.Lock()
+	defer f.l.Unlock()
+	for _, t := range targets {
+		f.partitioned[t] = true
+	}
+}
+
+// Heal reconnects this transport to the targets, or to every target if none
+// is given.
+func (f *FaultyTransport) Heal(targets ...string) {
+	f.l.Lock()
+	defer f.l.Unlock()
+	if len(targets) == 0 {
+		f.partitioned = make(map[string]bool)
+		return
+	}
+	for _, t := range targets {
+		delete(f.partitioned, t)
+	}
+}
+
+// FaultStats returns the number of faults injected so far.
+func (f *FaultyTransport) FaultStats() FaultStats {
+	f.l.Lock()
+	defer f.l.Unlock()
+	return f.stats
+}
+
+// Consumer implements the Transport interface.
+func (f *FaultyTransport) Consumer() <-chan RPC {
+	return f.trans.Consumer()
+}
+
+// LocalAddr implements the Transport interface.
+func (f *FaultyTransport) LocalAddr() string {
+	return f.trans.LocalAddr()
+}
+
+// Sync implements the Transport interface.
+func (f *FaultyTransport) Sync(target string, args *SyncRequest, resp *SyncResponse) error {
+	out, err := f.do(target, func() (interface{}, error) {
+		var out SyncResponse
+		err := f.trans.Sync(target, args, &out)
+		return &out, err
+	})
+	if out != nil {
+		*resp = *out.(*SyncResponse)
+	}
+	return err
+}
+
+// EagerSync implements the Transport interface.
+func (f *FaultyTransport) EagerSync(target string, args *EagerSyncRequest, resp *EagerSyncResponse) error {
+	out, err := f.do(target, func() (interface{}, error) {
+		var out EagerSyncResponse
+		err := f.trans.EagerSync(target, args, &out)
+		return &out, err
+	})
+	if out != nil {
+		*resp = *out.(*EagerSyncResponse)
+	}
+	return err
+}
+
+// StreamSync implements the WithStreamSync interface if the wrapped transport
+// does. Faults only apply to the request, not to the individual chunks.
+func (f *FaultyTransport) StreamSync(target string, args *StreamSyncRequest, resp *StreamSyncResponse, handler func(*StreamSyncChunk) error) error {
+	trans, ok := f.trans.(WithStreamSync)
+	if !ok {
+		return ErrStreamSyncUnsupported
+	}
+	if err := f.before(target); err != nil {
+		return err
+	}
+	return trans.StreamSync(target, args, resp, handler)
+}
+
+// FastForward implements the WithFastForward interface if the wrapped
+// transport does.
+func (f *FaultyTransport) FastForward(target string, args *FastForwardRequest, resp *FastForwardResponse) error {
+	trans, ok := f.trans.(WithFastForward)
+	if !ok {
+		return ErrFastForwardUnsupported
+	}
+	out, err := f.do(target, func() (interface{}, error) {
+		var out FastForwardResponse
+		err := trans.FastForward(target, args, &out)
+		return &out, err
+	})
+	if out != nil {
+		*resp = *out.(*FastForwardResponse)
+	}
+	return err
+}
+
+// Snapshot implements the WithFastForward interface if the wrapped transport
+// does.
+func (f *FaultyTransport) Snapshot(target string, args *SnapshotRequest, resp *SnapshotResponse) error {
+	trans, ok := f.trans.(WithFastForward)
+	if !ok {
+		return ErrFastForwardUnsupported
+	}
+	out, err := f.do(target, func() (interface{}, error) {
+		var out SnapshotResponse
+		err := trans.Snapshot(target, args, &out)
+		return &out, err
+	})
+	if out != nil {
+		*resp = *out.(*SnapshotResponse)
+	}
+	return err
+}
+
+// Close implements the Transport interface.
+func (f *FaultyTransport) Close() error {
+	return f.trans.Close()
+}
+
+//faults are the decisions taken for a single request
+type faults struct {
+	delay         time.Duration
+	dropRequest   bool
+	dropResponse  bool
+	duplicate     bool
+	duplicateWait time.Duration
+}
+
+//decide draws the faults of the next request to target
+func (f *FaultyTransport) decide(target string) (faults, error) {
+	f.l.Lock()
+	defer f.l.Unlock()
+
+	f.stats.Requests++
+	if f.partitioned[target] {
+		f.stats.Partitioned++
+		return faults{}, ErrPartitioned
+	}
+
+	c := f.config
+	res := faults{delay: c.Latency}
+	if c.Jitter > 0 {
+		res.delay += time.Duration(f.rand.Int63n(int64(c.Jitter)))
+	}
+	if f.rand.Float64() < c.DropRate {
+		f.stats.Dropped++
+		//Half of the drops lose the request, the other half lose the
+		//response after the target processed the request
+		if f.rand.Intn(2) == 0 {
+			res.dropRequest = true
+		} else {
+			res.dropResponse = true
+		}
+	}
+	if f.rand.Float64() < c.DuplicateRate {
+		f.stats.Duplicated++
+		res.duplicate = true
+		if c.Jitter > 0 {
+			res.duplicateWait = time.Duration(f.rand.Int63n(int64(c.Jitter)))
+		}
+	}
+	return res, nil
+}
+
+//before applies the faults that happen before a request is sent
+func (f *FaultyTransport) before(target string) error {
+	res, err := f.decide(target)
+	if err != nil {
+		return err
+	}
+	time.Sleep(res.delay)
+	if res.dropRequest || res.dropResponse {
+		return ErrDropped
+	}
+	return nil
+}
+
+//do sends a request through send with the faults drawn for target. The
+//response of a duplicate is discarded.
+func (f *FaultyTransport) do(target string, send func() (interface{}, error)) (interface{}, error) {
+	res, err := f.decide(target)
+	if err != nil {
+		return nil, err
+	}
+
+	time.Sleep(res.delay)
+	if res.dropRequest {
+		return nil, ErrDropped
+	}
+
+	if res.duplicate {
+		go func() {
+			time.Sleep(res.duplicateWait)
+			send()
+		}()
+	}
+
+	resp, err := send()
+	if res.dropResponse {
+		return nil, ErrDropped
+	}
+	return resp, err
+}
@@ -0,0 +1,115 @@
+package net
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFaultyTransport(t *testing.T) {
+	addr1, inmem1 := NewInmemTransport("")
+	addr2, inmem2 := NewInmemTransport("")
+	inmem1.Connect(addr2, inmem2)
+	inmem2.Connect(addr1, inmem1)
+
+	trans1 := NewFaultyTransport(inmem1, FaultConfig{}, 1)
+	defer trans1.Close()
+
+	received := make(chan int, 16)
+	go func() {
+		for rpc := range inmem2.Consumer() {
+			received <- rpc.Command.(*SyncRequest).FromID
+			rpc.Respond(&SyncResponse{FromID: 2}, nil)
+		}
+	}()
+
+	args := SyncRequest{FromID: 1}
+	var resp SyncResponse
+
+	// No faults
+	if err := trans1.Sync(addr2, &args, &resp); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if resp.FromID != 2 {
+		t.Fatalf("unexpected response: %#v", resp)
+	}
+	<-received
+
+	// Latency
+	trans1.SetConfig(FaultConfig{Latency: 20 * time.Millisecond, Jitter: 5 * time.Millisecond})
+	start := time.Now()
+	if err := trans1.Sync(addr2, &args, &resp); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 20*time.Millisecond {
+		t.Fatalf("request should take at least 20ms, not %v", elapsed)
+	}
+	<-received
+
+	// Drops
+	trans1.SetConfig(FaultConfig{DropRate: 1})
+	for i := 0; i < 10; i++ {
+		if err := trans1.Sync(addr2, &args, &resp); err != ErrDropped {
+			t.Fatalf("expected ErrDropped, got %v", err)
+		}
+	}
+
+	// Duplicates
+	for len(received) > 0 {
+		<-received
+	}
+	trans1.SetConfig(FaultConfig{DuplicateRate: 1})
+	if err := trans1.Sync(addr2, &args, &resp); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		select {
+		case <-received:
+		case <-time.After(time.Second):
+			t.Fatalf("request should be delivered twice")
+		}
+	}
+
+	// Partition is asymmetric and can be healed
+	trans1.SetConfig(FaultConfig{})
+	trans1.Partition(addr2)
+	if err := trans1.Sync(addr2, &args, &resp); err != ErrPartitioned {
+		t.Fatalf("expected ErrPartitioned, got %v", err)
+	}
+	trans1.Heal()
+	if err := trans1.Sync(addr2, &args, &resp); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	stats := trans1.FaultStats()
+	if stats.Dropped != 10 || stats.Duplicated != 1 || stats.Partitioned != 1 {
+		t.Fatalf("unexpected stats: %#v", stats)
+	}
+}
+
+func TestFaultyTransportSeed(t *testing.T) {
+	outcomes := func(seed int64) []error {
+		addr, inmem := NewInmemTransport("")
+		go func() {
+			for rpc := range inmem.Consumer() {
+				rpc.Respond(&EagerSyncResponse{Success: true}, nil)
+			}
+		}()
+		_, loop := NewInmemTransport("")
+		loop.Connect(addr, inmem)
+
+		trans := NewFaultyTransport(loop, FaultConfig{DropRate: 0.5}, seed)
+		res := []error{}
+		for i := 0; i < 20; i++ {
+			var resp EagerSyncResponse
+			res = append(res, trans.EagerSync(addr, &EagerSyncRequest{}, &resp))
+		}
+		return res
+	}
+
+	a, b := outcomes(42), outcomes(42)
+	for i := range a {
+		if a[i] != b[i] {
+			t.Fatalf("outcome %d differs with the same seed: %v, %v", i, a[i], b[i])
+		}
+	}
+}
@@ -90,6 +90,32 @@ func (i *InmemTransport) EagerSync(target string, args *EagerSyncRequest, resp *
 	return nil
 }
 
+// FastForward implements the WithFastForward interface.
+func (i *InmemTransport) FastForward(target string, args *FastForwardRequest, resp *FastForwardResponse) error {
+	rpcResp, err := i.makeRPC(target, args, nil, i.timeout)
+	if err != nil {
+		return err
+	}
+
+	// Copy the result back
+	out := rpcResp.Response.(*FastForwardResponse)
+	*resp = *out
+	return nil
+}
+
+// Snapshot implements the WithFastForward interface.
+func (i *InmemTransport) Snapshot(target string, args *SnapshotRequest, resp *SnapshotResponse) error {
+	rpcResp, err := i.makeRPC(target, args, nil, i.timeout)
+	if err != nil {
+		return err
+	}
+
+	// Copy the result back
+	out := rpcResp.Response.(*SnapshotResponse)
+	*resp = *out
+	return nil
+}
+
 func (i *InmemTransport) makeRPC(target string, args interface{}, r io.Reader, timeout time.Duration) (rpcResp RPCResponse, err error) {
 	i.RLock()
 	peer, ok := i.peers[target]
@@ -100,12 +126,19 @@ func (i *InmemTransport) makeRPC(target string, args interface{}, r io.Reader, t
 		return
 	}
 
-	// Send the RPC over
-	respCh := make(chan RPCResponse)
-	peer.consumerCh <- RPC{
+	// Send the RPC over. The response channel is buffered so that the
+	// consumer does not block if we gave up waiting.
+	respCh := make(chan RPCResponse, 1)
+	timeoutCh := time.After(timeout)
+	select {
+	case peer.consumerCh <- RPC{
 		Command:  args,
 		Reader:   r,
 		RespChan: respCh,
+	}:
+	case <-timeoutCh:
+		err = fmt.Errorf("command timed out")
+		return
 	}
 
 	// Wait for a response
@@ -114,7 +147,7 @@ func (i *InmemTransport) makeRPC(target string, args interface{}, r io.Reader, t
 		if rpcResp.Error != nil {
 			err = rpcResp.Error
 		}
-	case <-time.After(timeout):
+	case <-timeoutCh:
 		err = fmt.Errorf("command timed out")
 	}
 	return
@@ -0,0 +1,212 @@
+package net
+
+import (
+	"errors"
+	"io"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	errTooManyConns    = errors.New("too many connections from remote address")
+	errRequestTooLarge = errors.New("request too large")
+	errRateLimited     = errors.New("request rate limit exceeded")
+)
+
+// Limits bounds the resources that remote nodes can use on a NetworkTransport.
+// Remote nodes are identified by the IP of their connections. A zero value
+// disables the corresponding limit.
+type Limits struct {
+	MaxConnsPerAddr   int           //max inbound connections per remote address
+	MaxRequestBytes   int64         //max bytes read to decode a single request
+	RequestsPerSecond float64       //sustained requests per second per remote address
+	RequestBurst      int           //requests allowed at once above RequestsPerSecond
+	ReadTimeout       time.Duration //time allowed to read a request once it has started
+}
+
+// DefaultLimits returns the Limits used by new NetworkTransports. The request
+// rate is not limited by default.
+func DefaultLimits() Limits {
+	return Limits{
+		MaxConnsPerAddr: 64,
+		MaxRequestBytes: 32 * 1024 * 1024,
+		ReadTimeout:     10 * time.Second,
+	}
+}
+
+// TransportStats counts the inbound connections and requests rejected by a
+// NetworkTransport because of its Limits, the offending connections being
+// closed, and reports on the pool of outbound connections.
+type TransportStats struct {
+	RejectedConns     uint64
+	OversizedRequests uint64
+	RateLimited       uint64
+	ReadTimeouts      uint64
+
+	PooledConns   int    //connections currently in the pool
+	IdleEvictions uint64 //pooled connections closed after the idle timeout
+	DeadEvictions uint64 //pooled connections closed by the remote node
+	Retries       uint64 //RPCs retried on a fresh connection
+
+	IncompatiblePeers uint64 //handshakes refused because of the network ID or protocol version
+}
+
+//transportStats is allocated on its own so that its counters are aligned for
+//atomic operations
+type transportStats struct {
+	rejectedConns     uint64
+	oversizedRequests uint64
+	rateLimited       uint64
+	readTimeouts      uint64
+	idleEvictions     uint64
+	deadEvictions     uint64
+	retries           uint64
+	incompatiblePeers uint64
+}
+
+func (s *transportStats) inc(counter *uint64) {
+	atomic.AddUint64(counter, 1)
+}
+
+func (s *transportStats) get() TransportStats {
+	return TransportStats{
+		RejectedConns:     atomic.LoadUint64(&s.rejectedConns),
+		OversizedRequests: atomic.LoadUint64(&s.oversizedRequests),
+		RateLimited:       atomic.LoadUint64(&s.rateLimited),
+		ReadTimeouts:      atomic.LoadUint64(&s.readTimeouts),
+		IdleEvictions:     atomic.LoadUint64(&s.idleEvictions),
+		DeadEvictions:     atomic.LoadUint64(&s.deadEvictions),
+		Retries:           atomic.LoadUint64(&s.retries),
+		IncompatiblePeers: atomic.LoadUint64(&s.incompatiblePeers),
+	}
+}
+
+//burst is the capacity of the token bucket of a remote address
+func (l Limits) burst() float64 {
+	if l.RequestBurst < 1 {
+		return 1
+	}
+	return float64(l.RequestBurst)
+}
+
+//++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++
+
+//rateBucket holds the request tokens of a remote address
+type rateBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+//refillTime is how long an empty bucket takes to fill up. A bucket that has
+//not been used for that long is full, so it can be forgotten.
+func (l Limits) refillTime() time.Duration {
+	return time.Duration(l.burst() / l.RequestsPerSecond * float64(time.Second))
+}
+
+//remoteHost returns the address used to account for a connection
+func remoteHost(conn net.Conn) string {
+	addr := conn.RemoteAddr().String()
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return host
+}
+
+// acquireConn registers an inbound connection from host. It returns false if
+// host already has too many connections.
+func (n *NetworkTransport) acquireConn(host string) bool {
+	n.quotaLock.Lock()
+	defer n.quotaLock.Unlock()
+
+	if n.limits.MaxConnsPerAddr > 0 && n.conns[host] >= n.limits.MaxConnsPerAddr {
+		return false
+	}
+	n.conns[host]++
+	return true
+}
+
+// releaseConn unregisters an inbound connection from host. The request tokens
+// of host are kept, so that it can not get a full burst back by reconnecting.
+func (n *NetworkTransport) releaseConn(host string) {
+	n.quotaLock.Lock()
+	defer n.quotaLock.Unlock()
+
+	n.conns[host]--
+	if n.conns[host] <= 0 {
+		delete(n.conns, host)
+	}
+}
+
+// allowRequest takes a token from the bucket of host. Tokens are refilled at
+// RequestsPerSecond, up to RequestBurst (at least one).
+func (n *NetworkTransport) allowRequest(host string) bool {
+	n.quotaLock.Lock()
+	defer n.quotaLock.Unlock()
+
+	rate := n.limits.RequestsPerSecond
+	if rate <= 0 {
+		return true
+	}
+	burst := n.limits.burst()
+	now := time.Now()
+
+	//Forget the buckets that are full again
+	if ttl := n.limits.refillTime(); now.Sub(n.bucketsSwept) >= ttl {
+		for h, b := range n.buckets {
+			if now.Sub(b.last) >= ttl {
+				delete(n.buckets, h)
+			}
+		}
+		n.bucketsSwept = now
+	}
+
+	b, ok := n.buckets[host]
+	if !ok {
+		b = &rateBucket{tokens: burst, last: now}
+		n.buckets[host] = b
+	}
+
+	b.tokens += now.Sub(b.last).Seconds() * rate
+	if b.tokens > burst {
+		b.tokens = burst
+	}
+	b.last = now
+
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+//++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++
+
+//requestReader fails reads once more than max bytes have been read since the
+//last reset. It sits under the bufio.Reader of a connection so read-ahead is
+//counted towards the request being decoded.
+type requestReader struct {
+	r        io.Reader
+	max      int64
+	read     int64
+	exceeded bool
+}
+
+func (rr *requestReader) Read(p []byte) (int, error) {
+	if rr.max > 0 && rr.read >= rr.max {
+		rr.exceeded = true
+		return 0, errRequestTooLarge
+	}
+	if rr.max > 0 && int64(len(p)) > rr.max-rr.read {
+		p = p[:rr.max-rr.read]
+	}
+	n, err := rr.r.Read(p)
+	rr.read += int64(n)
+	return n, err
+}
+
+func (rr *requestReader) reset(max int64) {
+	rr.max = max
+	rr.read = 0
+}
@@ -2,7 +2,6 @@ package net
 
 import (
 	"bufio"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
@@ -16,9 +15,20 @@ import (
 const (
 	rpcSync uint8 = iota
 	rpcEagerSync
+	rpcHandshake
+	rpcStreamSync
+	rpcFastForward
+	rpcSnapshot
 
 	// DefaultTimeoutScale is the default TimeoutScale in a NetworkTransport.
 	DefaultTimeoutScale = 256 * 1024 // 256KB
+
+	// DefaultIdleTimeout is how long a pooled connection may stay unused
+	// before it is closed instead of being reused.
+	DefaultIdleTimeout = 90 * time.Second
+
+	//livenessTimeout bounds the read that checks a pooled connection
+	livenessTimeout = time.Millisecond
 )
 
 var (
@@ -28,6 +38,12 @@ var (
 
 	// ErrPipelineShutdown is returned when the pipeline is closed.
 	ErrPipelineShutdown = errors.New("append pipeline closed")
+
+	errLegacyPeer = errors.New("remote node does not support handshake")
+
+	// errHandshakeClosed is returned instead of errLegacyPeer when we have a
+	// network ID: the remote node may as well have refused the connection.
+	errHandshakeClosed = errors.New("remote node closed the connection during the handshake")
 )
 
 /*
@@ -37,12 +53,28 @@ used to communicate with babble on remote machines. It requires
 an underlying stream layer to provide a stream abstraction, which can
 be simple TCP, TLS, etc.
 
-This transport is very simple and lightweight. Each RPC request is
-framed by sending a byte that indicates the message type, followed
-by the json encoded request.
-
-The response is an error string followed by the response object,
-both are encoded using msgpack
+This transport is very simple and lightweight. A new connection starts with a
+handshake where the dialing side offers its protocol version and codecs, and the
+listening side picks the codec to use for the rest of the connection. Each RPC
+request is then framed by sending a byte that indicates the message type,
+followed by the encoded request.
+
+The response is an error followed by the response object, both encoded with the
+negotiated codec. Since protocol version 2, the error carries a type that is
+returned to the caller as an RPCErr. Older nodes send an error string, which is
+returned as an RPCErr of type Internal. Since protocol version 4, Known maps and
+Event indexes are sent in a compact form described in compact.go.
+
+Nodes that predate the handshake only speak JSON and close the connection when
+they receive one. Such peers are remembered and dialed without a handshake
+until an RPC to them fails. Connections that start directly with an RPC are
+served with JSON.
+
+Outbound connections are pooled per target. A pooled connection is discarded
+when it has been idle for longer than the idle timeout, or when a short read
+shows that the remote node closed it, which happens when the remote node
+restarts. An RPC that fails because the remote node closed a pooled connection
+in the meantime is retried once on a fresh connection.
 */
 type NetworkTransport struct {
 	logger *logrus.Logger
@@ -50,6 +82,21 @@ type NetworkTransport struct {
 	connPool     map[string][]*netConn
 	connPoolLock sync.Mutex
 	maxPool      int
+	idleTimeout  time.Duration
+
+	codecs     []string
+	legacy     map[string]bool
+	legacyLock sync.Mutex
+
+	networkID     string
+	networkIDLock sync.Mutex
+
+	limits       Limits
+	conns        map[string]int         //inbound connections by remote address
+	buckets      map[string]*rateBucket //request tokens by remote address
+	bucketsSwept time.Time
+	quotaLock    sync.Mutex
+	stats        *transportStats
 
 	consumeCh chan RPC
 
@@ -76,8 +123,51 @@ type netConn struct {
 	conn   net.Conn
 	r      *bufio.Reader
 	w      *bufio.Writer
-	dec    *json.Decoder
-	enc    *json.Encoder
+	dec    decoder
+	enc    encoder
+
+	codec           string
+	protocolVersion int
+	legacy          bool
+
+	reused   bool      //the conn was taken from the pool
+	lastUsed time.Time //when the conn was returned to the pool
+}
+
+// typedErrors reports whether the remote node sends typed errors.
+func (n *netConn) typedErrors() bool {
+	return !n.legacy && n.protocolVersion >= typedErrorsVersion
+}
+
+// streamSync reports whether the remote node supports StreamSync.
+func (n *netConn) streamSync() bool {
+	return !n.legacy && n.protocolVersion >= streamSyncVersion
+}
+
+// fastForward reports whether the remote node supports FastForward and
+// Snapshot.
+func (n *netConn) fastForward() bool {
+	return !n.legacy && n.protocolVersion >= fastForwardVersion
+}
+
+// compact reports whether RPCs are sent in compact form.
+func (n *netConn) compact() bool {
+	return !n.legacy && n.protocolVersion >= compactVersion
+}
+
+// alive reports whether a pooled connection can be reused. Remote nodes do
+// not send anything between RPCs, so anything but a timeout means that the
+// connection was closed or that the stream is out of step.
+func (n *netConn) alive() bool {
+	if n.r.Buffered() > 0 {
+		return false
+	}
+	n.conn.SetReadDeadline(time.Now().Add(livenessTimeout))
+	_, err := n.r.Peek(1)
+	n.conn.SetReadDeadline(time.Time{})
+
+	ne, ok := err.(net.Error)
+	return ok && ne.Timeout()
 }
 
 func (n *netConn) Release() error {
@@ -98,13 +188,20 @@ func NewNetworkTransport(
 		logger.Level = logrus.DebugLevel
 	}
 	trans := &NetworkTransport{
-		connPool:   make(map[string][]*netConn),
-		consumeCh:  make(chan RPC),
-		logger:     logger,
-		maxPool:    maxPool,
-		shutdownCh: make(chan struct{}),
-		stream:     stream,
-		timeout:    timeout,
+		connPool:    make(map[string][]*netConn),
+		idleTimeout: DefaultIdleTimeout,
+		codecs:      DefaultCodecs,
+		legacy:      make(map[string]bool),
+		limits:      DefaultLimits(),
+		conns:       make(map[string]int),
+		buckets:     make(map[string]*rateBucket),
+		stats:       &transportStats{},
+		consumeCh:   make(chan RPC),
+		logger:      logger,
+		maxPool:     maxPool,
+		shutdownCh:  make(chan struct{}),
+		stream:      stream,
+		timeout:     timeout,
 	}
 	go trans.listen()
 	return trans
@@ -123,6 +220,85 @@ func (n *NetworkTransport) Close() error {
 	return nil
 }
 
+// SetLimits replaces the Limits applied to inbound connections. Connections
+// that are already open are only subject to the new rate and size limits.
+func (n *NetworkTransport) SetLimits(limits Limits) {
+	n.quotaLock.Lock()
+	defer n.quotaLock.Unlock()
+	n.limits = limits
+}
+
+// getLimits returns the current Limits.
+func (n *NetworkTransport) getLimits() Limits {
+	n.quotaLock.Lock()
+	defer n.quotaLock.Unlock()
+	return n.limits
+}
+
+// SetNetworkID sets the ID of the network this node belongs to. Handshakes
+// with nodes that announce another ID, or no ID, fail, as do RPCs over those
+// connections. Nodes that do not support the handshake are refused as well.
+// An empty ID disables the check.
+func (n *NetworkTransport) SetNetworkID(id string) {
+	n.networkIDLock.Lock()
+	defer n.networkIDLock.Unlock()
+	n.networkID = id
+}
+
+func (n *NetworkTransport) getNetworkID() string {
+	n.networkIDLock.Lock()
+	defer n.networkIDLock.Unlock()
+	return n.networkID
+}
+
+// checkPeer returns an IncompatibleErr if a node that announced
+// protocolVersion and networkID in a handshake can not join our network.
+func (n *NetworkTransport) checkPeer(protocolVersion int, networkID string) error {
+	if protocolVersion < minProtocolVersion {
+		return IncompatibleErr{fmt.Sprintf("protocol version %d is lower than %d",
+			protocolVersion, minProtocolVersion)}
+	}
+	ours := n.getNetworkID()
+	if ours != "" && networkID == "" {
+		return IncompatibleErr{fmt.Sprintf("no network ID, ours is %s", ours)}
+	}
+	if ours != "" && networkID != ours {
+		return IncompatibleErr{fmt.Sprintf("network ID %s does not match ours, %s",
+			networkID, ours)}
+	}
+	return nil
+}
+
+// checkLegacyPeer returns an IncompatibleErr if we have a network ID, which a
+// node that does not support the handshake can not prove it shares.
+func (n *NetworkTransport) checkLegacyPeer() error {
+	if ours := n.getNetworkID(); ours != "" {
+		return IncompatibleErr{fmt.Sprintf("no handshake, so no network ID, ours is %s", ours)}
+	}
+	return nil
+}
+
+// SetIdleTimeout sets how long a pooled connection may stay unused before it
+// is closed. Zero disables the idle timeout.
+func (n *NetworkTransport) SetIdleTimeout(timeout time.Duration) {
+	n.connPoolLock.Lock()
+	defer n.connPoolLock.Unlock()
+	n.idleTimeout = timeout
+}
+
+// Stats returns the counters of rejected connections and requests, and of the
+// connection pool.
+func (n *NetworkTransport) Stats() TransportStats {
+	stats := n.stats.get()
+
+	n.connPoolLock.Lock()
+	defer n.connPoolLock.Unlock()
+	for _, conns := range n.connPool {
+		stats.PooledConns += len(conns)
+	}
+	return stats
+}
+
 // Consumer implements the Transport interface.
 func (n *NetworkTransport) Consumer() <-chan RPC {
 	return n.consumeCh
@@ -143,21 +319,51 @@ func (n *NetworkTransport) IsShutdown() bool {
 	}
 }
 
-// getPooledConn is used to grab a pooled connection.
+// getPooledConn is used to grab a pooled connection. Connections that were
+// idle for too long or that fail the liveness check are closed.
 func (n *NetworkTransport) getPooledConn(target string) *netConn {
+	for {
+		conn, expired := n.popConn(target)
+		if conn == nil {
+			return nil
+		}
+
+		if expired {
+			n.stats.inc(&n.stats.idleEvictions)
+			conn.Release()
+			continue
+		}
+
+		if !conn.alive() {
+			n.logger.WithField("target", target).Debug("discarding dead pooled connection")
+			n.stats.inc(&n.stats.deadEvictions)
+			conn.Release()
+			continue
+		}
+
+		conn.reused = true
+		return conn
+	}
+}
+
+// popConn removes the most recently used connection to target from the pool
+// and reports whether it exceeded the idle timeout.
+func (n *NetworkTransport) popConn(target string) (*netConn, bool) {
 	n.connPoolLock.Lock()
 	defer n.connPoolLock.Unlock()
 
 	conns, ok := n.connPool[target]
 	if !ok || len(conns) == 0 {
-		return nil
+		return nil, false
 	}
 
 	var conn *netConn
 	num := len(conns)
 	conn, conns[num-1] = conns[num-1], nil
 	n.connPool[target] = conns[:num-1]
-	return conn
+
+	expired := n.idleTimeout > 0 && time.Since(conn.lastUsed) > n.idleTimeout
+	return conn, expired
 }
 
 // getConn is used to get a connection from the pool.
@@ -166,7 +372,56 @@ func (n *NetworkTransport) getConn(target string, timeout time.Duration) (*netCo
 	if conn := n.getPooledConn(target); conn != nil {
 		return conn, nil
 	}
+	return n.newConn(target, timeout)
+}
+
+// newConn dials a new connection to target, bypassing the pool.
+func (n *NetworkTransport) newConn(target string, timeout time.Duration) (*netConn, error) {
+	//A peer found before we had a network ID must now do the handshake
+	legacy := n.isLegacy(target) && n.checkLegacyPeer() == nil
+
+	netConn, err := n.dial(target, timeout, legacy)
+	if err == errLegacyPeer {
+		if n.checkLegacyPeer() != nil {
+			err = errHandshakeClosed
+		} else {
+			n.logger.WithField("target", target).Debug("peer does not support handshake, falling back to JSON")
+			n.setLegacy(target, true)
+			netConn, err = n.dial(target, timeout, true)
+		}
+	}
+	if ie, ok := err.(IncompatibleErr); ok {
+		n.stats.inc(&n.stats.incompatiblePeers)
+		n.logger.WithFields(logrus.Fields{
+			"target": target,
+			"reason": ie.Reason,
+		}).Error("Refused incompatible peer")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Setup encoder/decoders
+	if netConn.enc, err = newEncoder(netConn.codec, netConn.w); err != nil {
+		netConn.Release()
+		return nil, err
+	}
+	if netConn.dec, err = newDecoder(netConn.codec, netConn.r); err != nil {
+		netConn.Release()
+		return nil, err
+	}
+	if netConn.compact() {
+		netConn.enc = newCompactEncoder(netConn.enc)
+		netConn.dec = newCompactDecoder(netConn.dec)
+	}
 
+	// Done
+	return netConn, nil
+}
+
+// dial opens a new connection and, unless legacy is set, negotiates the codec
+// and protocol version with the remote node.
+func (n *NetworkTransport) dial(target string, timeout time.Duration, legacy bool) (*netConn, error) {
 	// Dial a new connection
 	conn, err := n.stream.Dial(target, timeout)
 	if err != nil {
@@ -175,19 +430,88 @@ func (n *NetworkTransport) getConn(target string, timeout time.Duration) (*netCo
 
 	// Wrap the conn
 	netConn := &netConn{
-		target: target,
-		conn:   conn,
-		r:      bufio.NewReader(conn),
-		w:      bufio.NewWriter(conn),
+		target:          target,
+		conn:            conn,
+		r:               bufio.NewReader(conn),
+		w:               bufio.NewWriter(conn),
+		codec:           JSONCodec,
+		protocolVersion: ProtocolVersion,
+		legacy:          legacy,
+	}
+	if legacy {
+		return netConn, nil
 	}
-	// Setup encoder/decoders
-	netConn.dec = json.NewDecoder(netConn.r)
-	netConn.enc = json.NewEncoder(netConn.w)
 
-	// Done
+	if timeout > 0 {
+		conn.SetDeadline(time.Now().Add(timeout))
+	}
+	if err := n.clientHandshake(netConn); err != nil {
+		netConn.Release()
+		return nil, err
+	}
 	return netConn, nil
 }
 
+// clientHandshake offers our codecs and protocol version to the remote node.
+// It returns errLegacyPeer if the remote node closed the connection instead of
+// answering, which is what nodes without handshake support do.
+func (n *NetworkTransport) clientHandshake(conn *netConn) error {
+	if err := conn.w.WriteByte(rpcHandshake); err != nil {
+		return err
+	}
+	hs := handshake{
+		ProtocolVersion: ProtocolVersion,
+		Codecs:          n.codecs,
+		Version:         nodeVersion,
+		NetworkID:       n.getNetworkID(),
+	}
+	if err := writeFrame(conn.w, &hs); err != nil {
+		return err
+	}
+
+	var resp handshakeResponse
+	if err := readFrame(conn.r, &resp); err != nil {
+		if ne, ok := err.(net.Error); ok && ne.Timeout() {
+			return err
+		}
+		return errLegacyPeer
+	}
+	if resp.Incompatible {
+		return IncompatibleErr{fmt.Sprintf("%s (remote version %s)", resp.Error, resp.Version)}
+	}
+	if resp.Error != "" {
+		return fmt.Errorf("handshake rejected: %s", resp.Error)
+	}
+	if err := n.checkPeer(resp.ProtocolVersion, resp.NetworkID); err != nil {
+		return err
+	}
+	if !isKnownCodec(resp.Codec) {
+		return fmt.Errorf("handshake selected unknown codec %s", resp.Codec)
+	}
+
+	conn.codec = resp.Codec
+	conn.protocolVersion = resp.ProtocolVersion
+	return nil
+}
+
+// isLegacy reports whether target is known to not support the handshake.
+func (n *NetworkTransport) isLegacy(target string) bool {
+	n.legacyLock.Lock()
+	defer n.legacyLock.Unlock()
+	return n.legacy[target]
+}
+
+// setLegacy records whether target supports the handshake.
+func (n *NetworkTransport) setLegacy(target string, legacy bool) {
+	n.legacyLock.Lock()
+	defer n.legacyLock.Unlock()
+	if legacy {
+		n.legacy[target] = true
+	} else {
+		delete(n.legacy, target)
+	}
+}
+
 // returnConn returns a connection back to the pool.
 func (n *NetworkTransport) returnConn(conn *netConn) {
 	n.connPoolLock.Lock()
@@ -197,6 +521,7 @@ func (n *NetworkTransport) returnConn(conn *netConn) {
 	conns, _ := n.connPool[key]
 
 	if !n.IsShutdown() && len(conns) < n.maxPool {
+		conn.lastUsed = time.Now()
 		n.connPool[key] = append(conns, conn)
 	} else {
 		conn.Release()
@@ -221,13 +546,45 @@ func (n *NetworkTransport) genericRPC(target string, rpcType uint8, args interfa
 		return err
 	}
 
+	err = n.roundTrip(conn, rpcType, args, resp)
+	if n.shouldRetry(conn, err) {
+		n.logger.WithFields(logrus.Fields{
+			"target": target,
+			"error":  err,
+		}).Debug("retrying RPC on a fresh connection")
+		n.stats.inc(&n.stats.retries)
+
+		if conn, err = n.newConn(target, n.timeout); err != nil {
+			return err
+		}
+		err = n.roundTrip(conn, rpcType, args, resp)
+	}
+	return err
+}
+
+// shouldRetry reports whether an RPC that failed with err on conn is worth
+// retrying on a fresh connection. Only pooled connections are retried, when
+// the remote node closed them cleanly as it does when it stops. Resets are
+// not, as that is how nodes reject requests that exceed their Limits, nor are
+// timeouts and errors returned by the remote node.
+func (n *NetworkTransport) shouldRetry(conn *netConn, err error) bool {
+	if err == nil || !conn.reused || n.IsShutdown() {
+		return false
+	}
+	return err == io.EOF || err == io.ErrUnexpectedEOF || err == io.ErrClosedPipe
+}
+
+// roundTrip sends a request on conn and decodes the response. conn is returned
+// to the pool if it can be reused.
+func (n *NetworkTransport) roundTrip(conn *netConn, rpcType uint8, args interface{}, resp interface{}) error {
 	// Set a deadline
 	if n.timeout > 0 {
 		conn.conn.SetDeadline(time.Now().Add(n.timeout))
 	}
 
 	// Send the RPC
-	if err = sendRPC(conn, rpcType, args); err != nil {
+	if err := sendRPC(conn, rpcType, args); err != nil {
+		n.resetLegacy(conn)
 		return err
 	}
 
@@ -235,10 +592,20 @@ func (n *NetworkTransport) genericRPC(target string, rpcType uint8, args interfa
 	canReturn, err := decodeResponse(conn, resp)
 	if canReturn {
 		n.returnConn(conn)
+	} else {
+		n.resetLegacy(conn)
 	}
 	return err
 }
 
+// resetLegacy forgets that the target of a broken legacy connection does not
+// support the handshake, in case it was upgraded and restarted.
+func (n *NetworkTransport) resetLegacy(conn *netConn) {
+	if conn.legacy {
+		n.setLegacy(conn.target, false)
+	}
+}
+
 // sendRPC is used to encode and send the RPC.
 func sendRPC(conn *netConn, rpcType uint8, args interface{}) error {
 	// Write the request type
@@ -265,10 +632,20 @@ func sendRPC(conn *netConn, rpcType uint8, args interface{}) error {
 // the connection can be reused.
 func decodeResponse(conn *netConn, resp interface{}) (bool, error) {
 	// Decode the error if any
-	var rpcError string
-	if err := conn.dec.Decode(&rpcError); err != nil {
-		conn.Release()
-		return false, err
+	var rpcError rpcErrResponse
+	if conn.typedErrors() {
+		if err := conn.dec.Decode(&rpcError); err != nil {
+			conn.Release()
+			return false, err
+		}
+	} else {
+		if err := conn.dec.Decode(&rpcError.Message); err != nil {
+			conn.Release()
+			return false, err
+		}
+		if rpcError.Message != "" {
+			rpcError.Code = Internal
+		}
 	}
 
 	// Decode the response
@@ -278,8 +655,8 @@ func decodeResponse(conn *netConn, resp interface{}) (bool, error) {
 	}
 
 	// Format an error if any
-	if rpcError != "" {
-		return true, fmt.Errorf(rpcError)
+	if rpcError.Code != 0 {
+		return true, NewRPCErr(rpcError.Code, rpcError.Message)
 	}
 	return true, nil
 }
@@ -296,28 +673,95 @@ func (n *NetworkTransport) listen() {
 			n.logger.WithField("error", err).Error("Failed to accept connection")
 			continue
 		}
+		host := remoteHost(conn)
+		if !n.acquireConn(host) {
+			n.stats.inc(&n.stats.rejectedConns)
+			n.logger.WithFields(logrus.Fields{
+				"node":  conn.LocalAddr(),
+				"from":  conn.RemoteAddr(),
+				"error": errTooManyConns,
+			}).Error("Rejected connection")
+			conn.Close()
+			continue
+		}
+
 		n.logger.WithFields(logrus.Fields{
 			"node": conn.LocalAddr(),
 			"from": conn.RemoteAddr(),
 		}).Debug("accepted connection")
 
 		// Handle the connection in dedicated routine
-		go n.handleConn(conn)
+		go n.handleConn(conn, host)
 	}
 }
 
 // handleConn is used to handle an inbound connection for its lifespan.
-func (n *NetworkTransport) handleConn(conn net.Conn) {
+func (n *NetworkTransport) handleConn(conn net.Conn, host string) {
+	defer n.releaseConn(host)
 	defer conn.Close()
-	r := bufio.NewReader(conn)
+	rr := &requestReader{r: conn}
+	rr.reset(n.getLimits().MaxRequestBytes)
+	r := bufio.NewReader(rr)
 	w := bufio.NewWriter(conn)
-	dec := json.NewDecoder(r)
-	enc := json.NewEncoder(w)
+
+	// Negotiate the codec if the remote node starts with a handshake
+	codec := JSONCodec
+	version := 0
+	n.setReadDeadline(conn)
+	first, err := r.Peek(1)
+	if err != nil {
+		n.countViolation(rr, err)
+		if err != io.EOF {
+			n.logger.WithField("error", err).Error("Failed to read from connection")
+		}
+		return
+	}
+	if first[0] != rpcHandshake {
+		if err := n.checkLegacyPeer(); err != nil {
+			n.stats.inc(&n.stats.incompatiblePeers)
+			n.logger.WithFields(logrus.Fields{
+				"from":   conn.RemoteAddr(),
+				"reason": err.(IncompatibleErr).Reason,
+			}).Error("Refused incompatible peer")
+			return
+		}
+	} else {
+		r.ReadByte()
+		n.setReadDeadline(conn)
+		if codec, version, err = n.serverHandshake(r, w); err != nil {
+			if ie, ok := err.(IncompatibleErr); ok {
+				n.stats.inc(&n.stats.incompatiblePeers)
+				n.logger.WithFields(logrus.Fields{
+					"from":   conn.RemoteAddr(),
+					"reason": ie.Reason,
+				}).Error("Refused incompatible peer")
+				return
+			}
+			n.countViolation(rr, err)
+			n.logger.WithField("error", err).Error("Failed handshake")
+			return
+		}
+		conn.SetReadDeadline(time.Time{})
+	}
+
+	var dec decoder
+	var enc encoder
+	dec, _ = newDecoder(codec, r)
+	enc, _ = newEncoder(codec, w)
+	if version >= compactVersion {
+		dec = newCompactDecoder(dec)
+		enc = newCompactEncoder(enc)
+	}
 
 	for {
-		if err := n.handleCommand(r, dec, enc); err != nil {
+		rr.reset(n.getLimits().MaxRequestBytes)
+		if err := n.handleCommand(conn, host, r, w, dec, enc, version); err != nil {
+			n.countViolation(rr, err)
 			if err != io.EOF {
-				n.logger.WithField("error", err).Error("Failed to decode incoming command")
+				n.logger.WithFields(logrus.Fields{
+					"from":  conn.RemoteAddr(),
+					"error": err,
+				}).Error("Failed to decode incoming command")
 			}
 			return
 		}
@@ -328,14 +772,99 @@ func (n *NetworkTransport) handleConn(conn net.Conn) {
 	}
 }
 
-// handleCommand is used to decode and dispatch a single command.
-func (n *NetworkTransport) handleCommand(r *bufio.Reader, dec *json.Decoder, enc *json.Encoder) error {
+// setReadDeadline gives the remote node ReadTimeout to send the rest of a
+// request.
+func (n *NetworkTransport) setReadDeadline(conn net.Conn) {
+	if timeout := n.getLimits().ReadTimeout; timeout > 0 {
+		conn.SetReadDeadline(time.Now().Add(timeout))
+	}
+}
+
+// countViolation updates the stats if a connection failed because of the
+// Limits.
+func (n *NetworkTransport) countViolation(rr *requestReader, err error) {
+	if rr.exceeded {
+		n.stats.inc(&n.stats.oversizedRequests)
+		return
+	}
+	if err == errRateLimited {
+		n.stats.inc(&n.stats.rateLimited)
+		return
+	}
+	if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		n.stats.inc(&n.stats.readTimeouts)
+	}
+}
+
+// serverHandshake answers the handshake of a remote node and returns the codec
+// and protocol version to use for the rest of the connection.
+func (n *NetworkTransport) serverHandshake(r *bufio.Reader, w *bufio.Writer) (string, int, error) {
+	var hs handshake
+	if err := readFrame(r, &hs); err != nil {
+		return "", 0, err
+	}
+
+	if err := n.checkPeer(hs.ProtocolVersion, hs.NetworkID); err != nil {
+		writeFrame(w, &handshakeResponse{
+			Version:      nodeVersion,
+			NetworkID:    n.getNetworkID(),
+			Error:        err.(IncompatibleErr).Reason,
+			Incompatible: true,
+		})
+		if hs.Version != "" {
+			err = IncompatibleErr{fmt.Sprintf("%s (remote version %s)", err.(IncompatibleErr).Reason, hs.Version)}
+		}
+		return "", 0, err
+	}
+
+	codec, ok := selectCodec(hs.Codecs, n.codecs)
+	if !ok {
+		err := fmt.Errorf("no common codec in %v", hs.Codecs)
+		writeFrame(w, &handshakeResponse{Error: err.Error()})
+		return "", 0, err
+	}
+
+	version := ProtocolVersion
+	if hs.ProtocolVersion < version {
+		version = hs.ProtocolVersion
+	}
+
+	resp := handshakeResponse{
+		ProtocolVersion: version,
+		Codec:           codec,
+		Version:         nodeVersion,
+		NetworkID:       n.getNetworkID(),
+	}
+	if err := writeFrame(w, &resp); err != nil {
+		return "", 0, err
+	}
+	return codec, version, nil
+}
+
+// handleCommand is used to decode and dispatch a single command. version is
+// the protocol version of the connection, 0 for nodes without handshake.
+func (n *NetworkTransport) handleCommand(conn net.Conn, host string, r *bufio.Reader, w *bufio.Writer, dec decoder, enc encoder, version int) error {
 	// Get the rpc type
 	rpcType, err := r.ReadByte()
 	if err != nil {
 		return err
 	}
 
+	// Check the request rate of the remote node. Nodes that understand typed
+	// errors are told to back off before the connection is closed, so they do
+	// not mistake it for a stale connection and retry.
+	if !n.allowRequest(host) {
+		if version >= typedErrorsVersion {
+			enc.Encode(&rpcErrResponse{Code: Overloaded, Message: errRateLimited.Error()})
+			enc.Encode(emptyResponse(rpcType))
+			w.Flush()
+		}
+		return errRateLimited
+	}
+
+	// The rest of the request must arrive within ReadTimeout
+	n.setReadDeadline(conn)
+
 	// Create the RPC object
 	respCh := make(chan RPCResponse, 1)
 	rpc := RPC{
@@ -356,13 +885,49 @@ func (n *NetworkTransport) handleCommand(r *bufio.Reader, dec *json.Decoder, enc
 			return err
 		}
 		rpc.Command = &req
+	case rpcStreamSync:
+		if version < streamSyncVersion {
+			return fmt.Errorf("rpc type %d not supported by protocol version %d", rpcType, version)
+		}
+		req := StreamSyncRequest{cancelCh: make(chan struct{})}
+		if err := dec.Decode(&req); err != nil {
+			return err
+		}
+		defer close(req.cancelCh)
+		rpc.Command = &req
+	case rpcFastForward:
+		if version < fastForwardVersion {
+			return fmt.Errorf("rpc type %d not supported by protocol version %d", rpcType, version)
+		}
+		var req FastForwardRequest
+		if err := dec.Decode(&req); err != nil {
+			return err
+		}
+		rpc.Command = &req
+	case rpcSnapshot:
+		if version < fastForwardVersion {
+			return fmt.Errorf("rpc type %d not supported by protocol version %d", rpcType, version)
+		}
+		var req SnapshotRequest
+		if err := dec.Decode(&req); err != nil {
+			return err
+		}
+		rpc.Command = &req
 	default:
 		return fmt.Errorf("unknown rpc type %d", rpcType)
 	}
+	conn.SetReadDeadline(time.Time{})
 
-	// Dispatch the RPC
+	// Dispatch the RPC. If the consumer does not pick it up in time, tell the
+	// remote node that we are overloaded.
+	var overloadCh <-chan time.Time
+	if n.timeout > 0 {
+		overloadCh = time.After(n.timeout)
+	}
 	select {
 	case n.consumeCh <- rpc:
+	case <-overloadCh:
+		respCh <- RPCResponse{Error: NewRPCErr(Overloaded, "request not processed in time")}
 	case <-n.shutdownCh:
 		return ErrTransportShutdown
 	}
@@ -371,20 +936,61 @@ func (n *NetworkTransport) handleCommand(r *bufio.Reader, dec *json.Decoder, enc
 	select {
 	case resp := <-respCh:
 		// Send the error first
-		respErr := ""
+		var respErr rpcErrResponse
 		if resp.Error != nil {
-			respErr = resp.Error.Error()
+			rpcErr := toRPCErr(resp.Error)
+			respErr.Code = rpcErr.errType
+			respErr.Message = rpcErr.msg
 		}
-		if err := enc.Encode(respErr); err != nil {
-			return err
+		if version >= typedErrorsVersion {
+			if err := enc.Encode(&respErr); err != nil {
+				return err
+			}
+		} else {
+			msg := ""
+			if resp.Error != nil {
+				msg = resp.Error.Error()
+			}
+			if err := enc.Encode(msg); err != nil {
+				return err
+			}
 		}
 
-		// Send the response
-		if err := enc.Encode(resp.Response); err != nil {
+		// Send the response. Some codecs cannot encode nil values so an
+		// empty response is sent instead.
+		respObj := resp.Response
+		if respObj == nil {
+			respObj = emptyResponse(rpcType)
+		}
+		if err := enc.Encode(respObj); err != nil {
 			return err
 		}
+
+		// Send the chunks of a StreamSync
+		if stream, ok := respObj.(*StreamSyncResponse); ok && resp.Error == nil {
+			req := rpc.Command.(*StreamSyncRequest)
+			return n.streamChunks(conn, w, dec, enc, req.Window, stream.Chunks)
+		}
 	case <-n.shutdownCh:
 		return ErrTransportShutdown
 	}
 	return nil
 }
+
+// emptyResponse returns an empty response for an rpc type.
+func emptyResponse(rpcType uint8) interface{} {
+	switch rpcType {
+	case rpcSync:
+		return &SyncResponse{}
+	case rpcEagerSync:
+		return &EagerSyncResponse{}
+	case rpcStreamSync:
+		return &StreamSyncResponse{}
+	case rpcFastForward:
+		return &FastForwardResponse{}
+	case rpcSnapshot:
+		return &SnapshotResponse{}
+	default:
+		return struct{}{}
+	}
+}
@@ -1,6 +1,11 @@
 package net
 
 import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
 	"reflect"
 	"sync"
 	"testing"
@@ -249,3 +254,584 @@ func TestNetworkTransport_PooledConn(t *testing.T) {
 		t.Fatalf("Expected 2 pooled conns!")
 	}
 }
+
+func TestNetworkTransport_Codecs(t *testing.T) {
+	// Transport 1 is consumer
+	trans1, err := NewTCPTransport("127.0.0.1:0", nil, 2, time.Second, common.NewTestLogger(t))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer trans1.Close()
+	rpcCh := trans1.Consumer()
+
+	args := SyncRequest{
+		FromID: 0,
+		Known:  map[int]int{0: 1, 1: 2},
+	}
+	resp := SyncResponse{
+		FromID: 1,
+		Events: []hashgraph.WireEvent{
+			hashgraph.WireEvent{
+				Body: hashgraph.WireBody{
+					Transactions: [][]byte{[]byte("tx1"), []byte("tx2")},
+					CreatorID:    1,
+				},
+			},
+		},
+		Known: map[int]int{0: 5, 1: 5},
+	}
+
+	go func() {
+		for rpc := range rpcCh {
+			rpc.Respond(&resp, nil)
+		}
+	}()
+
+	cases := []struct {
+		offered  []string
+		expected string
+	}{
+		{DefaultCodecs, GobCodec},
+		{[]string{JSONCodec}, JSONCodec},
+		{[]string{"msgpack", GobCodec}, GobCodec},
+	}
+
+	for _, c := range cases {
+		trans2, err := NewTCPTransport("127.0.0.1:0", nil, 2, time.Second, common.NewTestLogger(t))
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		trans2.codecs = c.offered
+
+		var out SyncResponse
+		if err := trans2.Sync(trans1.LocalAddr(), &args, &out); err != nil {
+			t.Fatalf("%v: err: %v", c.offered, err)
+		}
+		if !reflect.DeepEqual(resp, out) {
+			t.Fatalf("%v: response mismatch: %#v %#v", c.offered, resp, out)
+		}
+
+		conn := trans2.getPooledConn(trans1.LocalAddr())
+		if conn == nil {
+			t.Fatalf("%v: expected a pooled conn", c.offered)
+		}
+		if conn.codec != c.expected {
+			t.Fatalf("%v: codec should be %s, not %s", c.offered, c.expected, conn.codec)
+		}
+		if conn.protocolVersion != ProtocolVersion {
+			t.Fatalf("%v: protocol version should be %d, not %d", c.offered, ProtocolVersion, conn.protocolVersion)
+		}
+		conn.Release()
+		trans2.Close()
+	}
+
+	// No common codec
+	trans3, err := NewTCPTransport("127.0.0.1:0", nil, 2, time.Second, common.NewTestLogger(t))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer trans3.Close()
+	trans3.codecs = []string{"msgpack"}
+
+	var out SyncResponse
+	if err := trans3.Sync(trans1.LocalAddr(), &args, &out); err == nil {
+		t.Fatalf("Sync without common codec should fail")
+	}
+}
+
+// legacyServer mimics a node that predates the handshake: it only speaks JSON
+// and closes connections that start with an unknown rpc type.
+func legacyServer(t *testing.T, resp interface{}) (string, func()) {
+	list, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	go func() {
+		for {
+			conn, err := list.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				w := bufio.NewWriter(conn)
+				dec := json.NewDecoder(r)
+				enc := json.NewEncoder(w)
+				for {
+					rpcType, err := r.ReadByte()
+					if err != nil || rpcType != rpcSync {
+						return
+					}
+					var req SyncRequest
+					if err := dec.Decode(&req); err != nil {
+						return
+					}
+					enc.Encode("")
+					enc.Encode(resp)
+					w.Flush()
+				}
+			}(conn)
+		}
+	}()
+
+	return list.Addr().String(), func() { list.Close() }
+}
+
+func TestNetworkTransport_LegacyServer(t *testing.T) {
+	resp := SyncResponse{
+		FromID: 1,
+		Known:  map[int]int{0: 5, 1: 5},
+	}
+	addr, closeServer := legacyServer(t, &resp)
+	defer closeServer()
+
+	trans, err := NewTCPTransport("127.0.0.1:0", nil, 2, time.Second, common.NewTestLogger(t))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer trans.Close()
+
+	args := SyncRequest{FromID: 0, Known: map[int]int{0: 1, 1: 2}}
+
+	for i := 0; i < 2; i++ {
+		var out SyncResponse
+		if err := trans.Sync(addr, &args, &out); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		if !reflect.DeepEqual(resp, out) {
+			t.Fatalf("response mismatch: %#v %#v", resp, out)
+		}
+		if !trans.isLegacy(addr) {
+			t.Fatalf("%s should be marked as legacy", addr)
+		}
+	}
+
+	conn := trans.getPooledConn(addr)
+	if conn == nil || conn.codec != JSONCodec {
+		t.Fatalf("expected a pooled JSON conn")
+	}
+}
+
+func TestNetworkTransport_LegacyClient(t *testing.T) {
+	trans, err := NewTCPTransport("127.0.0.1:0", nil, 2, time.Second, common.NewTestLogger(t))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer trans.Close()
+	rpcCh := trans.Consumer()
+
+	args := SyncRequest{FromID: 0, Known: map[int]int{0: 1, 1: 2}}
+	resp := SyncResponse{FromID: 1, Known: map[int]int{0: 5, 1: 5}}
+
+	go func() {
+		for rpc := range rpcCh {
+			rpc.Respond(&resp, nil)
+		}
+	}()
+
+	// A client that predates the handshake sends JSON right away
+	conn, err := net.Dial("tcp", trans.LocalAddr())
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(time.Second))
+
+	w := bufio.NewWriter(conn)
+	enc := json.NewEncoder(w)
+	dec := json.NewDecoder(bufio.NewReader(conn))
+
+	w.WriteByte(rpcSync)
+	enc.Encode(&args)
+	w.Flush()
+
+	var rpcErr string
+	var out SyncResponse
+	if err := dec.Decode(&rpcErr); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := dec.Decode(&out); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if rpcErr != "" {
+		t.Fatalf("unexpected rpc error: %s", rpcErr)
+	}
+	if !reflect.DeepEqual(resp, out) {
+		t.Fatalf("response mismatch: %#v %#v", resp, out)
+	}
+}
+
+func TestNetworkTransport_ErrorTypes(t *testing.T) {
+	trans1, err := NewTCPTransport("127.0.0.1:0", nil, 2, 200*time.Millisecond, common.NewTestLogger(t))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer trans1.Close()
+	rpcCh := trans1.Consumer()
+
+	trans2, err := NewTCPTransport("127.0.0.1:0", nil, 2, time.Second, common.NewTestLogger(t))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer trans2.Close()
+
+	args := SyncRequest{FromID: 0, Known: map[int]int{0: 1, 1: 2}}
+
+	respErrs := make(chan error, 1)
+	go func() {
+		for rpc := range rpcCh {
+			rpc.Respond(nil, <-respErrs)
+		}
+	}()
+
+	cases := []struct {
+		err      error
+		expected RPCErrType
+	}{
+		{NewRPCErr(NotBabbling, "CatchingUp"), NotBabbling},
+		{NewRPCErr(TooLate, "10"), TooLate},
+		{fmt.Errorf("untyped"), Internal},
+	}
+
+	for _, c := range cases {
+		respErrs <- c.err
+		var out SyncResponse
+		err := trans2.Sync(trans1.LocalAddr(), &args, &out)
+		if !IsRPCErr(err, c.expected) {
+			t.Fatalf("error should have type %d, got %v", c.expected, err)
+		}
+	}
+
+	// Nodes that do not send typed errors still produce an RPCErr
+	conn := trans2.getPooledConn(trans1.LocalAddr())
+	if conn == nil {
+		t.Fatalf("expected a pooled conn")
+	}
+	conn.Release()
+	trans2.setLegacy(trans1.LocalAddr(), true)
+
+	respErrs <- NewRPCErr(TooLate, "10")
+	var out SyncResponse
+	err = trans2.Sync(trans1.LocalAddr(), &args, &out)
+	if !IsRPCErr(err, Internal) {
+		t.Fatalf("error should have type Internal, got %v", err)
+	}
+
+	// A consumer that does not pick up requests makes the transport overloaded
+	trans3, err := NewTCPTransport("127.0.0.1:0", nil, 2, 200*time.Millisecond, common.NewTestLogger(t))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer trans3.Close()
+
+	err = trans2.Sync(trans3.LocalAddr(), &args, &out)
+	if !IsRPCErr(err, Overloaded) {
+		t.Fatalf("error should have type Overloaded, got %v", err)
+	}
+}
+
+func TestNetworkTransport_Limits(t *testing.T) {
+	trans1, err := NewTCPTransport("127.0.0.1:0", nil, 2, time.Second, common.NewTestLogger(t))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer trans1.Close()
+	rpcCh := trans1.Consumer()
+
+	go func() {
+		for rpc := range rpcCh {
+			rpc.Respond(&SyncResponse{FromID: 1}, nil)
+		}
+	}()
+
+	waitStat := func(name string, get func(TransportStats) uint64, expected uint64) {
+		for i := 0; i < 100; i++ {
+			if get(trans1.Stats()) == expected {
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Fatalf("%s should be %d, not %d", name, expected, get(trans1.Stats()))
+	}
+
+	// Max connections per address
+	trans1.SetLimits(Limits{MaxConnsPerAddr: 1})
+
+	conn1, err := net.Dial("tcp", trans1.LocalAddr())
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	conn2, err := net.Dial("tcp", trans1.LocalAddr())
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	conn2.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := conn2.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("second connection should be closed, got %v", err)
+	}
+	waitStat("RejectedConns", func(s TransportStats) uint64 { return s.RejectedConns }, 1)
+	conn1.Close()
+	conn2.Close()
+
+	// Read timeout
+	trans1.SetLimits(Limits{ReadTimeout: 50 * time.Millisecond})
+
+	conn3, err := net.Dial("tcp", trans1.LocalAddr())
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	conn3.Write([]byte{rpcSync})
+	conn3.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := conn3.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("stalled connection should be closed, got %v", err)
+	}
+	waitStat("ReadTimeouts", func(s TransportStats) uint64 { return s.ReadTimeouts }, 1)
+	conn3.Close()
+
+	// Max request bytes
+	trans1.SetLimits(Limits{MaxRequestBytes: 512})
+
+	trans2, err := NewTCPTransport("127.0.0.1:0", nil, 2, time.Second, common.NewTestLogger(t))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer trans2.Close()
+
+	small := SyncRequest{FromID: 0, Known: map[int]int{0: 1}}
+	large := SyncRequest{FromID: 0, Known: map[int]int{}}
+	for i := 0; i < 1000; i++ {
+		large.Known[i] = i
+	}
+
+	var out SyncResponse
+	if err := trans2.Sync(trans1.LocalAddr(), &small, &out); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := trans2.Sync(trans1.LocalAddr(), &large, &out); err == nil {
+		t.Fatalf("oversized request should fail")
+	}
+	waitStat("OversizedRequests", func(s TransportStats) uint64 { return s.OversizedRequests }, 1)
+
+	// Request rate
+	trans1.SetLimits(Limits{RequestsPerSecond: 1, RequestBurst: 2})
+
+	trans3, err := NewTCPTransport("127.0.0.1:0", nil, 2, time.Second, common.NewTestLogger(t))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer trans3.Close()
+
+	for i := 0; i < 2; i++ {
+		if err := trans3.Sync(trans1.LocalAddr(), &small, &out); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+	if err := trans3.Sync(trans1.LocalAddr(), &small, &out); !IsRPCErr(err, Overloaded) {
+		t.Fatalf("request above the rate limit should fail with Overloaded, got %v", err)
+	}
+	waitStat("RateLimited", func(s TransportStats) uint64 { return s.RateLimited }, 1)
+
+	// Reconnecting from the same address does not refill the tokens
+	trans3.Close()
+	trans4, err := NewTCPTransport("127.0.0.1:0", nil, 2, time.Second, common.NewTestLogger(t))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer trans4.Close()
+
+	if err := trans4.Sync(trans1.LocalAddr(), &small, &out); !IsRPCErr(err, Overloaded) {
+		t.Fatalf("new connection should not get a new burst, got %v", err)
+	}
+}
+
+func TestNetworkTransport_PoolHealth(t *testing.T) {
+	trans1, err := NewTCPTransport("127.0.0.1:0", nil, 2, time.Second, common.NewTestLogger(t))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer trans1.Close()
+	rpcCh := trans1.Consumer()
+
+	go func() {
+		for rpc := range rpcCh {
+			rpc.Respond(&SyncResponse{FromID: 1}, nil)
+		}
+	}()
+
+	trans2, err := NewTCPTransport("127.0.0.1:0", nil, 2, time.Second, common.NewTestLogger(t))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer trans2.Close()
+	target := trans1.LocalAddr()
+
+	//fakeConn pools one end of a pipe as a conn to target. The other end is
+	//handed to serve.
+	fakeConn := func(serve func(net.Conn)) {
+		client, server := net.Pipe()
+		conn := &netConn{
+			target:          target,
+			conn:            client,
+			r:               bufio.NewReader(client),
+			w:               bufio.NewWriter(client),
+			codec:           JSONCodec,
+			protocolVersion: ProtocolVersion,
+		}
+		conn.enc, _ = newEncoder(JSONCodec, conn.w)
+		conn.dec, _ = newDecoder(JSONCodec, conn.r)
+		go serve(server)
+		trans2.returnConn(conn)
+	}
+
+	sync := func() {
+		var out SyncResponse
+		if err := trans2.Sync(target, &SyncRequest{FromID: 0}, &out); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		if out.FromID != 1 {
+			t.Fatalf("unexpected response: %#v", out)
+		}
+	}
+
+	// A conn closed by the remote node fails the liveness check
+	fakeConn(func(c net.Conn) { c.Close() })
+	sync()
+	if stats := trans2.Stats(); stats.DeadEvictions != 1 || stats.Retries != 0 {
+		t.Fatalf("unexpected stats: %#v", stats)
+	}
+	if stats := trans2.Stats(); stats.PooledConns != 1 {
+		t.Fatalf("expected 1 pooled conn, got %d", stats.PooledConns)
+	}
+
+	// A conn that fails once in use is retried on a fresh connection
+	if conn := trans2.getPooledConn(target); conn != nil {
+		conn.Release()
+	}
+	fakeConn(func(c net.Conn) {
+		c.Read(make([]byte, 1))
+		c.Close()
+	})
+	sync()
+	if stats := trans2.Stats(); stats.DeadEvictions != 1 || stats.Retries != 1 {
+		t.Fatalf("unexpected stats: %#v", stats)
+	}
+
+	// Errors returned by the remote node are not retried
+	fakeConn(func(c net.Conn) {
+		r := bufio.NewReader(c)
+		r.ReadByte()
+		json.NewDecoder(r).Decode(&SyncRequest{})
+		enc := json.NewEncoder(c)
+		enc.Encode(rpcErrResponse{Code: TooLate, Message: "too late"})
+		enc.Encode(SyncResponse{})
+	})
+	var out SyncResponse
+	if err := trans2.Sync(target, &SyncRequest{FromID: 0}, &out); !IsRPCErr(err, TooLate) {
+		t.Fatalf("expected RPCErr TooLate, got %v", err)
+	}
+	if stats := trans2.Stats(); stats.Retries != 1 {
+		t.Fatalf("unexpected stats: %#v", stats)
+	}
+
+	// Idle conns are closed
+	for conn := trans2.getPooledConn(target); conn != nil; conn = trans2.getPooledConn(target) {
+		conn.Release()
+	}
+	trans2.SetIdleTimeout(20 * time.Millisecond)
+	sync()
+	time.Sleep(50 * time.Millisecond)
+	sync()
+	if stats := trans2.Stats(); stats.IdleEvictions != 1 {
+		t.Fatalf("unexpected stats: %#v", stats)
+	}
+}
+
+func TestNetworkTransport_NetworkID(t *testing.T) {
+	trans1, err := NewTCPTransport("127.0.0.1:0", nil, 2, time.Second, common.NewTestLogger(t))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer trans1.Close()
+	trans1.SetNetworkID("network-a")
+	rpcCh := trans1.Consumer()
+
+	go func() {
+		for rpc := range rpcCh {
+			rpc.Respond(&SyncResponse{FromID: 1}, nil)
+		}
+	}()
+
+	cases := []struct {
+		networkID  string
+		compatible bool
+	}{
+		{"network-a", true},
+		{"", false},
+		{"network-b", false},
+	}
+
+	for _, c := range cases {
+		trans2, err := NewTCPTransport("127.0.0.1:0", nil, 2, time.Second, common.NewTestLogger(t))
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		trans2.SetNetworkID(c.networkID)
+
+		var out SyncResponse
+		err = trans2.Sync(trans1.LocalAddr(), &SyncRequest{FromID: 0}, &out)
+		if c.compatible {
+			if err != nil {
+				t.Fatalf("%q: err: %v", c.networkID, err)
+			}
+			if n := trans2.Stats().IncompatiblePeers; n != 0 {
+				t.Fatalf("%q: expected no incompatible peers, got %d", c.networkID, n)
+			}
+		} else {
+			if _, ok := err.(IncompatibleErr); !ok {
+				t.Fatalf("%q: expected IncompatibleErr, got %v", c.networkID, err)
+			}
+			if n := trans2.Stats().IncompatiblePeers; n != 1 {
+				t.Fatalf("%q: expected 1 incompatible peer, got %d", c.networkID, n)
+			}
+		}
+		trans2.Close()
+	}
+
+	// A client that does not support the handshake is refused
+	conn, err := net.Dial("tcp", trans1.LocalAddr())
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(time.Second))
+	w := bufio.NewWriter(conn)
+	w.WriteByte(rpcSync)
+	json.NewEncoder(w).Encode(&SyncRequest{FromID: 0})
+	w.Flush()
+	if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("expected the connection to be closed, got %v", err)
+	}
+
+	// The server counts the peers it refused
+	deadline := time.Now().Add(time.Second)
+	for trans1.Stats().IncompatiblePeers != 3 {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected 3 incompatible peers, got %d", trans1.Stats().IncompatiblePeers)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// A server that does not answer the handshake is not spoken to in JSON
+	addr, closeServer := legacyServer(t, &SyncResponse{FromID: 1})
+	defer closeServer()
+
+	var out SyncResponse
+	if err := trans1.Sync(addr, &SyncRequest{FromID: 0}, &out); err != errHandshakeClosed {
+		t.Fatalf("expected errHandshakeClosed from a legacy server, got %v", err)
+	}
+	if trans1.isLegacy(addr) {
+		t.Fatalf("%s should not be marked as legacy", addr)
+	}
+}
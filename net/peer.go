@@ -2,11 +2,18 @@ package net
 
 import (
 	"bytes"
+	"crypto/ecdsa"
 	"encoding/hex"
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
 	"path/filepath"
+	"sort"
+	"strings"
 	"sync"
+	"time"
+
+	"github.com/champii/babble/crypto"
 )
 
 const (
@@ -22,6 +29,78 @@ func (p *Peer) PubKeyBytes() ([]byte, error) {
 	return hex.DecodeString(p.PubKeyHex[2:])
 }
 
+// PeerRecord is a signed statement by a node of the address where it can be
+// reached. Nodes gossip their records so that a node whose address changed can
+// be found again. The most recent record of a node wins.
+type PeerRecord struct {
+	PubKeyHex string
+	NetAddr   string
+	Timestamp int64 //unix nanoseconds
+	Signature string
+}
+
+// NewPeerRecord creates a PeerRecord for the node with key, reachable at
+// netAddr, and signs it.
+func NewPeerRecord(key *ecdsa.PrivateKey, netAddr string) (PeerRecord, error) {
+	r := PeerRecord{
+		PubKeyHex: fmt.Sprintf("0x%X", crypto.FromECDSAPub(&key.PublicKey)),
+		NetAddr:   netAddr,
+		Timestamp: time.Now().UnixNano(),
+	}
+	hash, err := r.Hash()
+	if err != nil {
+		return r, err
+	}
+	R, S, err := crypto.Sign(key, hash)
+	if err != nil {
+		return r, err
+	}
+	r.Signature = crypto.EncodeSignature(R, S)
+	return r, nil
+}
+
+func (r *PeerRecord) Hash() ([]byte, error) {
+	return hashRequest(struct {
+		PubKeyHex string
+		NetAddr   string
+		Timestamp int64
+	}{r.PubKeyHex, r.NetAddr, r.Timestamp})
+}
+
+// Verify checks that the record is signed by the key it describes.
+func (r *PeerRecord) Verify() (bool, error) {
+	p := Peer{PubKeyHex: r.PubKeyHex}
+	if len(r.PubKeyHex) < 2 {
+		return false, nil
+	}
+	pubBytes, err := p.PubKeyBytes()
+	if err != nil {
+		return false, err
+	}
+	pubKey := crypto.ToECDSAPub(pubBytes)
+	if pubKey == nil {
+		return false, nil
+	}
+
+	hash, err := r.Hash()
+	if err != nil {
+		return false, err
+	}
+	R, S, err := crypto.DecodeSignature(r.Signature)
+	if err != nil {
+		return false, err
+	}
+	return crypto.Verify(pubKey, hash, R, S), nil
+}
+
+// Peer returns the Peer described by the record.
+func (r *PeerRecord) Peer() Peer {
+	return Peer{
+		NetAddr:   r.NetAddr,
+		PubKeyHex: r.PubKeyHex,
+	}
+}
+
 // PeerStore provides an interface for persistent storage and
 // retrieval of peers.
 type PeerStore interface {
@@ -126,6 +205,34 @@ func ExcludePeer(peers []Peer, peer string) (int, []Peer) {
 	return index, otherPeers
 }
 
+// ExcludePubKey is used to exclude the peer with the given public key from a
+// list of peers. Unlike addresses, which depend on how a node is reached,
+// public keys identify nodes unambiguously.
+func ExcludePubKey(peers []Peer, pubKeyHex string) (int, []Peer) {
+	index := -1
+	otherPeers := make([]Peer, 0, len(peers))
+	for i, p := range peers {
+		if p.PubKeyHex != pubKeyHex {
+			otherPeers = append(otherPeers, p)
+		} else {
+			index = i
+		}
+	}
+	return index, otherPeers
+}
+
+// NetworkID identifies the network formed by a participant map, from public
+// key to ID, as found in a hashgraph Store. It is the hex encoded SHA256 hash
+// of the public keys, sorted and comma separated.
+func NetworkID(participants map[string]int) string {
+	keys := make([]string, 0, len(participants))
+	for pubKey := range participants {
+		keys = append(keys, strings.ToUpper(pubKey))
+	}
+	sort.Strings(keys)
+	return hex.EncodeToString(crypto.SHA256([]byte(strings.Join(keys, ","))))
+}
+
 //Sorting
 
 // ByPubKey implements sort.Interface for []Peer based on
@@ -77,3 +77,56 @@ func TestJSONPeers(t *testing.T) {
 		}
 	}
 }
+
+func TestPeerRecord(t *testing.T) {
+	key, _ := scrypto.GenerateECDSAKey()
+	otherKey, _ := scrypto.GenerateECDSAKey()
+
+	record, err := NewPeerRecord(key, "addr0")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if record.PubKeyHex != fmt.Sprintf("0x%X", scrypto.FromECDSAPub(&key.PublicKey)) {
+		t.Fatalf("PeerRecord PubKeyHex should match the key")
+	}
+	if valid, err := record.Verify(); err != nil || !valid {
+		t.Fatalf("PeerRecord should be valid: %v", err)
+	}
+
+	// Changing the address breaks the signature
+	moved := record
+	moved.NetAddr = "addr1"
+	if valid, _ := moved.Verify(); valid {
+		t.Fatalf("PeerRecord with a modified NetAddr should not be valid")
+	}
+
+	// A record can not be signed for another key
+	forged, _ := NewPeerRecord(otherKey, "addr1")
+	forged.PubKeyHex = record.PubKeyHex
+	if valid, _ := forged.Verify(); valid {
+		t.Fatalf("PeerRecord signed by another key should not be valid")
+	}
+}
+
+func TestNetworkID(t *testing.T) {
+	participants := map[string]int{
+		"0x04AB": 0,
+		"0x04CD": 1,
+		"0x04EF": 2,
+	}
+	id := NetworkID(participants)
+
+	// IDs and case do not matter
+	same := map[string]int{
+		"0x04ef": 0,
+		"0x04AB": 1,
+		"0x04CD": 2,
+	}
+	if other := NetworkID(same); other != id {
+		t.Fatalf("expected %s, got %s", id, other)
+	}
+
+	if other := NetworkID(map[string]int{"0x04AB": 0, "0x04CD": 1}); other == id {
+		t.Fatalf("different participants should have different network IDs")
+	}
+}
@@ -0,0 +1,264 @@
+package net
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	errPipeClosed   = errors.New("pipe stream layer is closed")
+	errPipeNotFound = errors.New("no pipe stream layer listening on address")
+	errPipeTimeout  = errors.New("timeout dialing pipe stream layer")
+)
+
+//pipeLinger is how long a closed pipeConn keeps trying to deliver buffered
+//data to a remote end that is not reading
+const pipeLinger = time.Second
+
+//pipeLayers registers the PipeStreamLayers of the program by name
+var pipeLayers = struct {
+	sync.Mutex
+	layers map[string]*PipeStreamLayer
+	next   int
+}{layers: make(map[string]*PipeStreamLayer)}
+
+// pipeAddr is the address of a PipeStreamLayer
+type pipeAddr string
+
+// Network implements the net.Addr interface.
+func (a pipeAddr) Network() string {
+	return "pipe"
+}
+
+// String implements the net.Addr interface.
+func (a pipeAddr) String() string {
+	return PipeScheme + schemeSep + string(a)
+}
+
+// PipeStreamLayer implements the StreamLayer interface with net.Pipe, for
+// nodes running in the same process, as in integration tests. Layers are
+// registered by name and can only be dialed from the same program.
+type PipeStreamLayer struct {
+	name      string
+	acceptCh  chan net.Conn
+	closeCh   chan struct{}
+	closeOnce sync.Once
+}
+
+// NewPipeStreamLayer registers a PipeStreamLayer under name. An empty name is
+// replaced by a unique one.
+func NewPipeStreamLayer(name string) (*PipeStreamLayer, error) {
+	name = trimScheme(name, PipeScheme)
+
+	pipeLayers.Lock()
+	defer pipeLayers.Unlock()
+
+	if name == "" {
+		for {
+			pipeLayers.next++
+			name = fmt.Sprintf("pipe%d", pipeLayers.next)
+			if _, ok := pipeLayers.layers[name]; !ok {
+				break
+			}
+		}
+	}
+	if _, ok := pipeLayers.layers[name]; ok {
+		return nil, fmt.Errorf("pipe address already in use: %s", name)
+	}
+
+	p := &PipeStreamLayer{
+		name:     name,
+		acceptCh: make(chan net.Conn),
+		closeCh:  make(chan struct{}),
+	}
+	pipeLayers.layers[name] = p
+	return p, nil
+}
+
+// Dial implements the StreamLayer interface. The address may be given with or
+// without the pipe:// scheme.
+func (p *PipeStreamLayer) Dial(address string, timeout time.Duration) (net.Conn, error) {
+	name := trimScheme(address, PipeScheme)
+
+	pipeLayers.Lock()
+	target, ok := pipeLayers.layers[name]
+	pipeLayers.Unlock()
+	if !ok {
+		return nil, errPipeNotFound
+	}
+
+	var timeoutCh <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		timeoutCh = timer.C
+	}
+
+	client, server := net.Pipe()
+	local, remote := pipeAddr(p.name), pipeAddr(name)
+
+	select {
+	case target.acceptCh <- newPipeConn(server, remote, local):
+		return newPipeConn(client, local, remote), nil
+	case <-target.closeCh:
+		client.Close()
+		server.Close()
+		return nil, errPipeNotFound
+	case <-timeoutCh:
+		client.Close()
+		server.Close()
+		return nil, errPipeTimeout
+	}
+}
+
+// Accept implements the net.Listener interface.
+func (p *PipeStreamLayer) Accept() (c net.Conn, err error) {
+	select {
+	case conn := <-p.acceptCh:
+		return conn, nil
+	case <-p.closeCh:
+		return nil, errPipeClosed
+	}
+}
+
+// Close implements the net.Listener interface. The name is released.
+func (p *PipeStreamLayer) Close() (err error) {
+	p.closeOnce.Do(func() {
+		close(p.closeCh)
+
+		pipeLayers.Lock()
+		delete(pipeLayers.layers, p.name)
+		pipeLayers.Unlock()
+	})
+	return nil
+}
+
+// Addr implements the net.Listener interface.
+func (p *PipeStreamLayer) Addr() net.Addr {
+	return pipeAddr(p.name)
+}
+
+// NewPipeTransport returns a NetworkTransport that is built on top of a
+// PipeStreamLayer registered under name.
+func NewPipeTransport(
+	name string,
+	maxPool int,
+	timeout time.Duration,
+	logger *logrus.Logger,
+) (*NetworkTransport, error) {
+	stream, err := NewPipeStreamLayer(name)
+	if err != nil {
+		return nil, err
+	}
+	return NewNetworkTransport(stream, maxPool, timeout, logger), nil
+}
+
+//+++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++
+
+// pipeConn wraps one end of a net.Pipe. net.Pipe is synchronous: a Write only
+// returns once the other end has read the data, which deadlocks protocols
+// where both ends write before reading, like the acknowledgements of
+// StreamSync. Writes are buffered and delivered by a separate goroutine, as
+// the kernel buffers of a socket would.
+type pipeConn struct {
+	net.Conn
+	local  net.Addr
+	remote net.Addr
+
+	mu     sync.Mutex
+	cond   *sync.Cond
+	buf    bytes.Buffer
+	closed bool
+	err    error
+}
+
+func newPipeConn(conn net.Conn, local, remote net.Addr) *pipeConn {
+	c := &pipeConn{
+		Conn:   conn,
+		local:  local,
+		remote: remote,
+	}
+	c.cond = sync.NewCond(&c.mu)
+	go c.deliver()
+	return c
+}
+
+// Write implements the net.Conn interface. It does not wait for the remote end.
+func (c *pipeConn) Write(b []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return 0, io.ErrClosedPipe
+	}
+	if c.err != nil {
+		return 0, c.err
+	}
+	c.buf.Write(b)
+	c.cond.Signal()
+	return len(b), nil
+}
+
+// Close implements the net.Conn interface. Buffered data is still delivered
+// for up to pipeLinger.
+func (c *pipeConn) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	c.cond.Signal()
+
+	//Unblock pending reads and bound the delivery of buffered data
+	c.Conn.SetReadDeadline(time.Now())
+	c.Conn.SetWriteDeadline(time.Now().Add(pipeLinger))
+	return nil
+}
+
+// LocalAddr implements the net.Conn interface.
+func (c *pipeConn) LocalAddr() net.Addr {
+	return c.local
+}
+
+// RemoteAddr implements the net.Conn interface.
+func (c *pipeConn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+//deliver writes the buffered data to the pipe until the pipeConn is closed
+//and its buffer is empty, or the pipe fails
+func (c *pipeConn) deliver() {
+	defer c.Conn.Close()
+
+	for {
+		c.mu.Lock()
+		for c.buf.Len() == 0 && !c.closed {
+			c.cond.Wait()
+		}
+		if c.buf.Len() == 0 {
+			c.mu.Unlock()
+			return
+		}
+		data := make([]byte, c.buf.Len())
+		copy(data, c.buf.Bytes())
+		c.buf.Reset()
+		c.mu.Unlock()
+
+		if _, err := c.Conn.Write(data); err != nil {
+			c.mu.Lock()
+			c.err = err
+			c.buf.Reset()
+			c.mu.Unlock()
+			return
+		}
+	}
+}
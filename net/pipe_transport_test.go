@@ -0,0 +1,93 @@
+package net
+
+import (
+	"testing"
+	"time"
+
+	"github.com/champii/babble/common"
+	"github.com/champii/babble/hashgraph"
+)
+
+func TestPipeTransport_StreamSync(t *testing.T) {
+	trans1, err := NewTransport("pipe://node1", "", 2, time.Second, common.NewTestLogger(t))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer trans1.Close()
+	if trans1.LocalAddr() != "pipe://node1" {
+		t.Fatalf("bad: %v", trans1.LocalAddr())
+	}
+	if _, err := NewPipeTransport("node1", 2, time.Second, common.NewTestLogger(t)); err == nil {
+		t.Fatalf("expected an error registering node1 twice")
+	}
+	rpcCh := trans1.Consumer()
+
+	numChunks := 10
+
+	go func() {
+		for rpc := range rpcCh {
+			switch req := rpc.Command.(type) {
+			case *StreamSyncRequest:
+				chunks := make(chan StreamSyncChunk)
+				rpc.Respond(&StreamSyncResponse{FromID: 1, Chunks: chunks}, nil)
+				go func() {
+					for i := 0; i < numChunks; i++ {
+						chunk := StreamSyncChunk{
+							Events: []hashgraph.WireEvent{
+								hashgraph.WireEvent{Body: hashgraph.WireBody{Index: i}},
+							},
+							Done: i == numChunks-1,
+						}
+						select {
+						case chunks <- chunk:
+						case <-req.Canceled():
+							return
+						}
+					}
+				}()
+			case *SyncRequest:
+				rpc.Respond(&SyncResponse{FromID: 1}, nil)
+			}
+		}
+	}()
+
+	trans2, err := NewPipeTransport("", 2, time.Second, common.NewTestLogger(t))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer trans2.Close()
+
+	// Chunks and acknowledgements cross on the pipe
+	args := StreamSyncRequest{FromID: 0, Known: map[int]int{0: 1}, ChunkSize: 1, Window: 4}
+	var resp StreamSyncResponse
+	received := 0
+	err = trans2.StreamSync(trans1.LocalAddr(), &args, &resp, func(chunk *StreamSyncChunk) error {
+		received += len(chunk.Events)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if received != numChunks {
+		t.Fatalf("expected %d Events, got %d", numChunks, received)
+	}
+
+	var out SyncResponse
+	if err := trans2.Sync(trans1.LocalAddr(), &SyncRequest{FromID: 0}, &out); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if out.FromID != 1 {
+		t.Fatalf("unexpected response: %#v", out)
+	}
+
+	// A closed layer can not be dialed and its name is released
+	trans1.Close()
+	if err := trans2.Sync("pipe://node1", &SyncRequest{FromID: 0}, &out); err == nil {
+		t.Fatalf("expected an error dialing a closed pipe")
+	}
+	trans3, err := NewPipeTransport("node1", 2, time.Second, common.NewTestLogger(t))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	trans3.Close()
+}
@@ -0,0 +1,226 @@
+package net
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// DefaultStreamWindow is the number of chunks that a node may receive
+	// before acknowledging them, when the StreamSyncRequest does not say.
+	DefaultStreamWindow = 4
+
+	//maxStreamWindow caps the window requested by remote nodes
+	maxStreamWindow = 64
+)
+
+var (
+	// ErrStreamSyncUnsupported is returned by StreamSync when the remote node
+	// does not support streaming.
+	ErrStreamSyncUnsupported = errors.New("remote node does not support StreamSync")
+
+	errStreamClosed = errors.New("stream closed before the last chunk")
+)
+
+/*
+A StreamSync starts like other RPCs: the request is followed by an error and a
+StreamSyncResponse. If there is no error, the listening side then sends a
+series of chunks, each preceded by an error. The stream ends with a chunk that
+has Done set or with an error.
+
+Flow control is based on credits. The listening side sends at most Window
+chunks before it waits for the dialing side to acknowledge one, which it does
+after handling every chunk but the last. Once the stream is over, the listening
+side collects the outstanding acknowledgements so the connection can be reused
+for other RPCs.
+*/
+
+// StreamSync implements the WithStreamSync interface.
+func (n *NetworkTransport) StreamSync(target string, args *StreamSyncRequest, resp *StreamSyncResponse, handler func(*StreamSyncChunk) error) error {
+	if args.Window <= 0 {
+		args.Window = DefaultStreamWindow
+	}
+
+	// Get a conn
+	conn, err := n.getConn(target, n.timeout)
+	if err != nil {
+		return err
+	}
+
+	// Only the opening of the stream is retried, no chunk has been handled yet
+	err = n.openStream(conn, args, resp)
+	if n.shouldRetry(conn, err) {
+		n.logger.WithFields(logrus.Fields{
+			"target": target,
+			"error":  err,
+		}).Debug("retrying StreamSync on a fresh connection")
+		n.stats.inc(&n.stats.retries)
+
+		if conn, err = n.newConn(target, n.timeout); err != nil {
+			return err
+		}
+		err = n.openStream(conn, args, resp)
+	}
+	if err != nil {
+		return err
+	}
+
+	for {
+		// Every chunk gets a fresh deadline
+		if n.timeout > 0 {
+			conn.conn.SetDeadline(time.Now().Add(n.timeout))
+		}
+
+		var chunk StreamSyncChunk
+		canReturn, err := decodeResponse(conn, &chunk)
+		if err != nil {
+			if canReturn {
+				n.returnConn(conn)
+			}
+			return err
+		}
+
+		// The remote node does not expect an acknowledgement for the last
+		// chunk so the conn can be reused whatever the handler returns
+		if chunk.Done {
+			n.returnConn(conn)
+			return handler(&chunk)
+		}
+
+		if err := handler(&chunk); err != nil {
+			conn.Release()
+			return err
+		}
+
+		// Give the credit back
+		if err := conn.enc.Encode(1); err != nil {
+			conn.Release()
+			return err
+		}
+		if err := conn.w.Flush(); err != nil {
+			conn.Release()
+			return err
+		}
+	}
+}
+
+// openStream sends a StreamSyncRequest on conn and decodes the
+// StreamSyncResponse that precedes the chunks.
+func (n *NetworkTransport) openStream(conn *netConn, args *StreamSyncRequest, resp *StreamSyncResponse) error {
+	if !conn.streamSync() {
+		n.returnConn(conn)
+		return ErrStreamSyncUnsupported
+	}
+
+	// Set a deadline
+	if n.timeout > 0 {
+		conn.conn.SetDeadline(time.Now().Add(n.timeout))
+	}
+
+	// Send the RPC
+	if err := sendRPC(conn, rpcStreamSync, args); err != nil {
+		return err
+	}
+
+	// Decode the response
+	canReturn, err := decodeResponse(conn, resp)
+	if err != nil && canReturn {
+		n.returnConn(conn)
+	}
+	return err
+}
+
+// streamChunks sends the chunks produced by the consumer of a StreamSync,
+// within the window of the remote node.
+func (n *NetworkTransport) streamChunks(conn net.Conn, w *bufio.Writer, dec decoder, enc encoder, window int, chunks <-chan StreamSyncChunk) error {
+	if window < 1 {
+		window = 1
+	}
+	if window > maxStreamWindow {
+		window = maxStreamWindow
+	}
+
+	if chunks == nil {
+		closed := make(chan StreamSyncChunk)
+		close(closed)
+		chunks = closed
+	}
+
+	// Send the StreamSyncResponse before waiting for the first chunk
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	outstanding := 0
+	for {
+		// Wait for credit before sending more chunks
+		for ; outstanding >= window; outstanding-- {
+			if err := n.readCredit(conn, dec); err != nil {
+				return err
+			}
+		}
+
+		var overloadCh <-chan time.Time
+		if n.timeout > 0 {
+			overloadCh = time.After(n.timeout)
+		}
+
+		var chunk StreamSyncChunk
+		var chunkErr error
+		select {
+		case c, ok := <-chunks:
+			if !ok {
+				chunkErr = NewRPCErr(Internal, errStreamClosed.Error())
+			} else {
+				chunk, chunkErr = c, c.err
+			}
+		case <-overloadCh:
+			chunkErr = NewRPCErr(Overloaded, "chunk not produced in time")
+		case <-n.shutdownCh:
+			return ErrTransportShutdown
+		}
+
+		var respErr rpcErrResponse
+		if chunkErr != nil {
+			rpcErr := toRPCErr(chunkErr)
+			respErr.Code = rpcErr.errType
+			respErr.Message = rpcErr.msg
+			chunk = StreamSyncChunk{Done: true}
+		}
+		if err := enc.Encode(&respErr); err != nil {
+			return err
+		}
+		if err := enc.Encode(&chunk); err != nil {
+			return err
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+
+		if chunk.Done {
+			break
+		}
+		outstanding++
+	}
+
+	// Collect the acknowledgements of the chunks still in flight
+	for ; outstanding > 0; outstanding-- {
+		if err := n.readCredit(conn, dec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readCredit waits for the remote node to acknowledge a chunk.
+func (n *NetworkTransport) readCredit(conn net.Conn, dec decoder) error {
+	n.setReadDeadline(conn)
+	defer conn.SetReadDeadline(time.Time{})
+
+	var credit int
+	return dec.Decode(&credit)
+}
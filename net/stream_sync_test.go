@@ -0,0 +1,129 @@
+package net
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/champii/babble/common"
+	"github.com/champii/babble/hashgraph"
+)
+
+func TestNetworkTransport_StreamSync(t *testing.T) {
+	// Transport 1 is consumer
+	trans1, err := NewTCPTransport("127.0.0.1:0", nil, 2, time.Second, common.NewTestLogger(t))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer trans1.Close()
+	rpcCh := trans1.Consumer()
+
+	numChunks := 10
+	var produced int32
+
+	go func() {
+		for rpc := range rpcCh {
+			switch req := rpc.Command.(type) {
+			case *StreamSyncRequest:
+				chunks := make(chan StreamSyncChunk)
+				rpc.Respond(&StreamSyncResponse{FromID: 1, Known: map[int]int{0: 5}, Chunks: chunks}, nil)
+				go func() {
+					for i := 0; i < numChunks; i++ {
+						chunk := StreamSyncChunk{
+							Events: []hashgraph.WireEvent{
+								hashgraph.WireEvent{Body: hashgraph.WireBody{Index: i}},
+							},
+							Done: i == numChunks-1,
+						}
+						if req.ChunkSize == 0 && i == 5 {
+							chunk = NewStreamSyncErr(NewRPCErr(TooLate, "5"))
+						}
+						select {
+						case chunks <- chunk:
+							atomic.AddInt32(&produced, 1)
+						case <-req.Canceled():
+							return
+						}
+						if chunk.Done {
+							return
+						}
+					}
+				}()
+			case *SyncRequest:
+				rpc.Respond(&SyncResponse{FromID: 1}, nil)
+			}
+		}
+	}()
+
+	// Transport 2 makes the request
+	trans2, err := NewTCPTransport("127.0.0.1:0", nil, 2, time.Second, common.NewTestLogger(t))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer trans2.Close()
+
+	args := StreamSyncRequest{FromID: 0, Known: map[int]int{0: 1}, ChunkSize: 1, Window: 2}
+	var resp StreamSyncResponse
+	received := []int{}
+	err = trans2.StreamSync(trans1.LocalAddr(), &args, &resp, func(chunk *StreamSyncChunk) error {
+		if len(received) == 0 {
+			// The transport must not run more than Window chunks ahead
+			time.Sleep(50 * time.Millisecond)
+			if p := atomic.LoadInt32(&produced); p > int32(args.Window) {
+				t.Errorf("%d chunks sent with a window of %d", p, args.Window)
+			}
+		}
+		for _, e := range chunk.Events {
+			received = append(received, e.Body.Index)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if resp.FromID != 1 || resp.Known[0] != 5 {
+		t.Fatalf("unexpected response: %#v", resp)
+	}
+	if len(received) != numChunks {
+		t.Fatalf("expected %d Events, got %d", numChunks, len(received))
+	}
+	for i, index := range received {
+		if index != i {
+			t.Fatalf("Event %d received out of order: %v", i, received)
+		}
+	}
+
+	// The connection is reused for other RPCs
+	var out SyncResponse
+	if err := trans2.Sync(trans1.LocalAddr(), &SyncRequest{FromID: 0}, &out); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if out.FromID != 1 {
+		t.Fatalf("unexpected response: %#v", out)
+	}
+
+	// An error ends the stream
+	atomic.StoreInt32(&produced, 0)
+	args = StreamSyncRequest{FromID: 0, Known: map[int]int{0: 1}}
+	err = trans2.StreamSync(trans1.LocalAddr(), &args, &resp, func(chunk *StreamSyncChunk) error {
+		return nil
+	})
+	if !IsRPCErr(err, TooLate) {
+		t.Fatalf("expected RPCErr TooLate, got %v", err)
+	}
+	if err := trans2.Sync(trans1.LocalAddr(), &SyncRequest{FromID: 0}, &out); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// Legacy nodes do not support streaming
+	trans2.setLegacy(trans1.LocalAddr(), true)
+	if conn := trans2.getPooledConn(trans1.LocalAddr()); conn != nil {
+		conn.Release()
+	}
+	err = trans2.StreamSync(trans1.LocalAddr(), &args, &resp, func(chunk *StreamSyncChunk) error {
+		return nil
+	})
+	if err != ErrStreamSyncUnsupported {
+		t.Fatalf("expected ErrStreamSyncUnsupported, got %v", err)
+	}
+}
@@ -19,9 +19,10 @@ type TCPStreamLayer struct {
 	listener  *net.TCPListener
 }
 
-// Dial implements the StreamLayer interface.
+// Dial implements the StreamLayer interface. The address may be given with or
+// without the tcp:// scheme.
 func (t *TCPStreamLayer) Dial(address string, timeout time.Duration) (net.Conn, error) {
-	return net.DialTimeout("tcp", address, timeout)
+	return net.DialTimeout("tcp", trimScheme(address, TCPScheme), timeout)
 }
 
 // Accept implements the net.Listener interface.
@@ -63,7 +64,7 @@ func newTCPTransport(bindAddr string,
 	timeout time.Duration,
 	transportCreator func(stream StreamLayer) *NetworkTransport) (*NetworkTransport, error) {
 	// Try to bind
-	list, err := net.Listen("tcp", bindAddr)
+	list, err := net.Listen("tcp", trimScheme(bindAddr, TCPScheme))
 	if err != nil {
 		return nil, err
 	}
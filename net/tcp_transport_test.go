@@ -24,3 +24,21 @@ func TestTCPTransport_WithAdvertise(t *testing.T) {
 		t.Fatalf("bad: %v", trans.LocalAddr())
 	}
 }
+
+func TestNewTransport_Advertise(t *testing.T) {
+	trans, err := NewTransport("tcp://0.0.0.0:0", "127.0.0.1:12345", 1, 0, common.NewTestLogger(t))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer trans.Close()
+	if trans.LocalAddr() != "127.0.0.1:12345" {
+		t.Fatalf("bad: %v", trans.LocalAddr())
+	}
+
+	if _, err := NewTransport("0.0.0.0:0", "0.0.0.0:12345", 1, 0, common.NewTestLogger(t)); err != errNotAdvertisable {
+		t.Fatalf("err: %v", err)
+	}
+	if _, err := NewTransport("pipe://advertise", "127.0.0.1:12345", 1, 0, common.NewTestLogger(t)); err == nil {
+		t.Fatalf("pipe addresses should not accept an advertise address")
+	}
+}
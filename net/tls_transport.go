@@ -0,0 +1,203 @@
+package net
+
+import (
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/champii/babble/crypto"
+)
+
+var (
+	errNoPeerCertificate = errors.New("remote peer did not present a certificate")
+	errNotECDSACert      = errors.New("remote certificate does not hold an ECDSA key")
+)
+
+// TLSStreamLayer implements the StreamLayer interface on top of TCP with TLS.
+// Each node presents a self-signed certificate derived from its ECDSA key, and
+// remote nodes are authenticated by comparing the key in their certificate
+// with the PubKeyHex of the known peers, instead of relying on a certificate
+// authority and host names.
+type TLSStreamLayer struct {
+	advertise net.Addr
+	listener  net.Listener
+	cert      tls.Certificate
+	peers     PeerStore
+}
+
+// Dial implements the StreamLayer interface. The remote node must present the
+// key registered for address in the PeerStore.
+func (t *TLSStreamLayer) Dial(address string, timeout time.Duration) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: timeout}
+	return tls.DialWithDialer(dialer, "tcp", trimScheme(address, TCPScheme), t.clientConfig(address))
+}
+
+// Accept implements the net.Listener interface. The TLS handshake, and hence
+// the authentication of the remote node, happens on the first read or write.
+func (t *TLSStreamLayer) Accept() (c net.Conn, err error) {
+	return t.listener.Accept()
+}
+
+// Close implements the net.Listener interface.
+func (t *TLSStreamLayer) Close() (err error) {
+	return t.listener.Close()
+}
+
+// Addr implements the net.Listener interface.
+func (t *TLSStreamLayer) Addr() net.Addr {
+	// Use an advertise addr if provided
+	if t.advertise != nil {
+		return t.advertise
+	}
+	return t.listener.Addr()
+}
+
+//serverConfig accepts connections from any node listed in the PeerStore
+func (t *TLSStreamLayer) serverConfig() *tls.Config {
+	return &tls.Config{
+		Certificates: []tls.Certificate{t.cert},
+		ClientAuth:   tls.RequireAnyClientCert,
+		MinVersion:   tls.VersionTLS12,
+		VerifyPeerCertificate: func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			return t.verifyPeer(rawCerts, "")
+		},
+	}
+}
+
+//clientConfig only accepts the node listed under address in the PeerStore.
+//Certificates are self-signed and not bound to host names so the standard
+//chain verification is replaced by verifyPeer.
+func (t *TLSStreamLayer) clientConfig(address string) *tls.Config {
+	return &tls.Config{
+		Certificates:       []tls.Certificate{t.cert},
+		InsecureSkipVerify: true,
+		MinVersion:         tls.VersionTLS12,
+		VerifyPeerCertificate: func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			return t.verifyPeer(rawCerts, address)
+		},
+	}
+}
+
+//verifyPeer checks that the remote certificate is self-signed by a key that
+//belongs to a known peer. If address is not empty, the key must be the one of
+//the peer with that NetAddr.
+func (t *TLSStreamLayer) verifyPeer(rawCerts [][]byte, address string) error {
+	if len(rawCerts) == 0 {
+		return errNoPeerCertificate
+	}
+	cert, err := x509.ParseCertificate(rawCerts[0])
+	if err != nil {
+		return err
+	}
+	if err := cert.CheckSignature(cert.SignatureAlgorithm, cert.RawTBSCertificate, cert.Signature); err != nil {
+		return err
+	}
+	pub, ok := cert.PublicKey.(*ecdsa.PublicKey)
+	if !ok {
+		return errNotECDSACert
+	}
+	pubHex := fmt.Sprintf("0x%X", crypto.FromECDSAPub(pub))
+
+	peers, err := t.peers.Peers()
+	if err != nil {
+		return err
+	}
+	for _, p := range peers {
+		if p.PubKeyHex != pubHex {
+			continue
+		}
+		if address == "" || p.NetAddr == address {
+			return nil
+		}
+	}
+
+	if address != "" {
+		return fmt.Errorf("remote key %s does not belong to peer %s", pubHex, address)
+	}
+	return fmt.Errorf("remote key %s does not belong to a known peer", pubHex)
+}
+
+// NewTLSCertificate creates a self-signed TLS certificate for an ECDSA key.
+func NewTLSCertificate(key *ecdsa.PrivateKey) (tls.Certificate, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject: pkix.Name{
+			CommonName: fmt.Sprintf("0x%X", crypto.FromECDSAPub(&key.PublicKey)),
+		},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(10 * 365 * 24 * time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	return tls.Certificate{
+		Certificate: [][]byte{der},
+		PrivateKey:  key,
+	}, nil
+}
+
+// NewTLSTransport returns a NetworkTransport that is built on top of a TLS
+// streaming transport layer. The node authenticates itself with key and only
+// talks to the peers listed in the PeerStore.
+func NewTLSTransport(
+	bindAddr string,
+	advertise net.Addr,
+	key *ecdsa.PrivateKey,
+	peers PeerStore,
+	maxPool int,
+	timeout time.Duration,
+	logger *logrus.Logger,
+) (*NetworkTransport, error) {
+	cert, err := NewTLSCertificate(key)
+	if err != nil {
+		return nil, err
+	}
+
+	// Try to bind
+	list, err := net.Listen("tcp", trimScheme(bindAddr, TCPScheme))
+	if err != nil {
+		return nil, err
+	}
+
+	// Create stream
+	stream := &TLSStreamLayer{
+		advertise: advertise,
+		cert:      cert,
+		peers:     peers,
+	}
+	stream.listener = tls.NewListener(list, stream.serverConfig())
+
+	// Verify that we have a usable advertise address
+	addr, ok := stream.Addr().(*net.TCPAddr)
+	if !ok {
+		list.Close()
+		return nil, errNotTCP
+	}
+	if addr.IP.IsUnspecified() {
+		list.Close()
+		return nil, errNotAdvertisable
+	}
+
+	// Create the network transport
+	return NewNetworkTransport(stream, maxPool, timeout, logger), nil
+}
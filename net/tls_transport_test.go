@@ -0,0 +1,90 @@
+package net
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/champii/babble/common"
+	"github.com/champii/babble/crypto"
+)
+
+func TestTLSTransport_Sync(t *testing.T) {
+	key1, _ := crypto.GenerateECDSAKey()
+	key2, _ := crypto.GenerateECDSAKey()
+	key3, _ := crypto.GenerateECDSAKey()
+
+	peers := &StaticPeers{}
+
+	// Transport 1 is consumer
+	trans1, err := NewTLSTransport("127.0.0.1:0", nil, key1, peers, 2, time.Second, common.NewTestLogger(t))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer trans1.Close()
+	rpcCh := trans1.Consumer()
+
+	// Transport 2 is a known peer
+	trans2, err := NewTLSTransport("127.0.0.1:0", nil, key2, peers, 2, time.Second, common.NewTestLogger(t))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer trans2.Close()
+
+	// Transport 3 is not in the PeerStore
+	trans3, err := NewTLSTransport("127.0.0.1:0", nil, key3, peers, 2, time.Second, common.NewTestLogger(t))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer trans3.Close()
+
+	peers.SetPeers([]Peer{
+		Peer{NetAddr: trans1.LocalAddr(), PubKeyHex: fmt.Sprintf("0x%X", crypto.FromECDSAPub(&key1.PublicKey))},
+		Peer{NetAddr: trans2.LocalAddr(), PubKeyHex: fmt.Sprintf("0x%X", crypto.FromECDSAPub(&key2.PublicKey))},
+	})
+
+	args := SyncRequest{
+		FromID: 1,
+		Known:  map[int]int{0: 1, 1: 2},
+	}
+	resp := SyncResponse{
+		FromID: 0,
+		Known:  map[int]int{0: 5, 1: 5},
+	}
+
+	// Listen for a request
+	go func() {
+		for rpc := range rpcCh {
+			req := rpc.Command.(*SyncRequest)
+			if !reflect.DeepEqual(req, &args) {
+				t.Errorf("command mismatch: %#v %#v", *req, args)
+			}
+			rpc.Respond(&resp, nil)
+		}
+	}()
+
+	var out SyncResponse
+	if err := trans2.Sync(trans1.LocalAddr(), &args, &out); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if !reflect.DeepEqual(resp, out) {
+		t.Fatalf("command mismatch: %#v %#v", resp, out)
+	}
+
+	// An unknown node should be rejected by the server
+	if err := trans3.Sync(trans1.LocalAddr(), &args, &out); err == nil {
+		t.Fatalf("Sync from unknown peer should fail")
+	}
+
+	// A known node listening on an address registered for another key should
+	// be rejected by the client
+	peers.SetPeers([]Peer{
+		Peer{NetAddr: trans1.LocalAddr(), PubKeyHex: fmt.Sprintf("0x%X", crypto.FromECDSAPub(&key2.PublicKey))},
+		Peer{NetAddr: trans2.LocalAddr(), PubKeyHex: fmt.Sprintf("0x%X", crypto.FromECDSAPub(&key3.PublicKey))},
+		Peer{NetAddr: trans3.LocalAddr(), PubKeyHex: fmt.Sprintf("0x%X", crypto.FromECDSAPub(&key1.PublicKey))},
+	})
+	if err := trans3.Sync(trans1.LocalAddr(), &args, &out); err == nil {
+		t.Fatalf("Sync to impersonated peer should fail")
+	}
+}
@@ -49,6 +49,37 @@ type WithPeers interface {
 	DisconnectAll()                   // Disconnect all peers, possibly to reconnect them later
 }
 
+// WithStats is an interface that a transport may provide to report the
+// connections and requests it rejected.
+type WithStats interface {
+	Stats() TransportStats
+}
+
+// WithNetworkID is an interface that a transport may provide to refuse the
+// nodes of other networks when connections are established.
+type WithNetworkID interface {
+	SetNetworkID(id string)
+}
+
+// WithStreamSync is an interface that a transport may provide to pull large
+// sets of Events in chunks over a single connection. handler is called with
+// every chunk, in order, and the next chunks are only requested once it
+// returns. Transports return ErrStreamSyncUnsupported if the target does not
+// support streaming.
+type WithStreamSync interface {
+	StreamSync(target string, args *StreamSyncRequest, resp *StreamSyncResponse, handler func(*StreamSyncChunk) error) error
+}
+
+// WithFastForward is an interface that a transport may provide to let a node
+// that is too far behind its peers restart from the state of one of them.
+// FastForward returns the last Block and the Frame of the target, and Snapshot
+// the chunks of the App snapshot taken at that Block. Transports return
+// ErrFastForwardUnsupported if the target does not support these RPCs.
+type WithFastForward interface {
+	FastForward(target string, args *FastForwardRequest, resp *FastForwardResponse) error
+	Snapshot(target string, args *SnapshotRequest, resp *SnapshotResponse) error
+}
+
 // LoopbackTransport is an interface that provides a loopback transport suitable for testing
 // e.g. InmemTransport. It's there so we don't have to rewrite tests.
 type LoopbackTransport interface {
@@ -0,0 +1,92 @@
+package net
+
+import (
+	"net"
+	"os"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// unixAddr is the address of a UnixStreamLayer. It is advertised with the
+// unix:// scheme so that other nodes dial it with a UnixStreamLayer.
+type unixAddr string
+
+// Network implements the net.Addr interface.
+func (a unixAddr) Network() string {
+	return "unix"
+}
+
+// String implements the net.Addr interface.
+func (a unixAddr) String() string {
+	return UnixScheme + schemeSep + string(a)
+}
+
+// UnixStreamLayer implements the StreamLayer interface on top of a Unix domain
+// socket, for nodes running on the same host.
+type UnixStreamLayer struct {
+	path     string
+	listener *net.UnixListener
+}
+
+// Dial implements the StreamLayer interface. The address may be given with or
+// without the unix:// scheme.
+func (u *UnixStreamLayer) Dial(address string, timeout time.Duration) (net.Conn, error) {
+	return net.DialTimeout("unix", trimScheme(address, UnixScheme), timeout)
+}
+
+// Accept implements the net.Listener interface.
+func (u *UnixStreamLayer) Accept() (c net.Conn, err error) {
+	return u.listener.Accept()
+}
+
+// Close implements the net.Listener interface. The socket file is removed.
+func (u *UnixStreamLayer) Close() (err error) {
+	return u.listener.Close()
+}
+
+// Addr implements the net.Listener interface.
+func (u *UnixStreamLayer) Addr() net.Addr {
+	return unixAddr(u.path)
+}
+
+// NewUnixTransport returns a NetworkTransport that is built on top of a Unix
+// domain socket created at path. A socket file left behind by a process that
+// is no longer listening is replaced.
+func NewUnixTransport(
+	path string,
+	maxPool int,
+	timeout time.Duration,
+	logger *logrus.Logger,
+) (*NetworkTransport, error) {
+	path = trimScheme(path, UnixScheme)
+
+	removeStaleSocket(path)
+
+	list, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+	if err != nil {
+		return nil, err
+	}
+
+	stream := &UnixStreamLayer{
+		path:     path,
+		listener: list,
+	}
+
+	return NewNetworkTransport(stream, maxPool, timeout, logger), nil
+}
+
+//removeStaleSocket deletes the socket file at path if nobody accepts
+//connections on it. Other kinds of files are left alone.
+func removeStaleSocket(path string) {
+	info, err := os.Lstat(path)
+	if err != nil || info.Mode()&os.ModeSocket == 0 {
+		return
+	}
+	conn, err := net.DialTimeout("unix", path, time.Second)
+	if err == nil {
+		conn.Close()
+		return
+	}
+	os.Remove(path)
+}
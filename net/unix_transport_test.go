@@ -0,0 +1,89 @@
+package net
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/champii/babble/common"
+)
+
+func TestUnixTransport_Sync(t *testing.T) {
+	dir, err := ioutil.TempDir("", "babble_unix")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path1 := filepath.Join(dir, "node1.sock")
+	trans1, err := NewTransport("unix://"+path1, "", 2, time.Second, common.NewTestLogger(t))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer trans1.Close()
+	if trans1.LocalAddr() != "unix://"+path1 {
+		t.Fatalf("bad: %v", trans1.LocalAddr())
+	}
+	rpcCh := trans1.Consumer()
+
+	args := SyncRequest{FromID: 0, Known: map[int]int{0: 1}}
+	resp := SyncResponse{FromID: 1, Known: map[int]int{0: 5}}
+
+	go func() {
+		for rpc := range rpcCh {
+			rpc.Respond(&resp, nil)
+		}
+	}()
+
+	trans2, err := NewTransport("unix://"+filepath.Join(dir, "node2.sock"), "", 2, time.Second, common.NewTestLogger(t))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer trans2.Close()
+
+	var out SyncResponse
+	if err := trans2.Sync(trans1.LocalAddr(), &args, &out); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if !reflect.DeepEqual(resp, out) {
+		t.Fatalf("response mismatch: %#v %#v", resp, out)
+	}
+
+	// The socket file is removed on Close
+	trans1.Close()
+	if _, err := os.Stat(path1); !os.IsNotExist(err) {
+		t.Fatalf("socket file still exists: %v", err)
+	}
+}
+
+func TestUnixTransport_StaleSocket(t *testing.T) {
+	dir, err := ioutil.TempDir("", "babble_unix")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "node.sock")
+
+	// Leave a socket file behind, as a crashed process would
+	list, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	list.SetUnlinkOnClose(false)
+	list.Close()
+
+	trans, err := NewUnixTransport(path, 1, time.Second, common.NewTestLogger(t))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer trans.Close()
+
+	// A socket in use is not replaced
+	if _, err := NewUnixTransport(path, 1, time.Second, common.NewTestLogger(t)); err == nil {
+		t.Fatalf("expected an error binding a socket in use")
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"time"
 
 	"github.com/champii/babble/common"
+	"github.com/champii/babble/mempool"
 	"github.com/sirupsen/logrus"
 )
 
@@ -16,6 +17,8 @@ type Config struct {
 	SyncLimit        int
 	StoreType        string
 	StorePath        string
+	PeerSelector     string
+	Mempool          mempool.Limits
 	Logger           *logrus.Logger
 }
 
@@ -33,6 +36,8 @@ func NewConfig(heartbeat time.Duration,
 		SyncLimit:        syncLimit,
 		StoreType:        storeType,
 		StorePath:        storePath,
+		PeerSelector:     RandomPeerSelectorType,
+		Mempool:          mempool.DefaultLimits(),
 		Logger:           logger,
 	}
 }
@@ -49,6 +54,8 @@ func DefaultConfig() *Config {
 		SyncLimit:        100,
 		StoreType:        storeType,
 		StorePath:        storePath,
+		PeerSelector:     RandomPeerSelectorType,
+		Mempool:          mempool.DefaultLimits(),
 		Logger:           logger,
 	}
 }
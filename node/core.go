@@ -10,6 +10,7 @@ import (
 
 	"github.com/champii/babble/crypto"
 	hg "github.com/champii/babble/hashgraph"
+	"github.com/champii/babble/mempool"
 )
 
 type Core struct {
@@ -24,7 +25,7 @@ type Core struct {
 	Head                string
 	Seq                 int
 
-	transactionPool    [][]byte
+	mempool            *mempool.Mempool
 	blockSignaturePool []hg.BlockSignature
 
 	logger *logrus.Logger
@@ -53,7 +54,7 @@ func NewCore(
 		hg:                  hg.NewHashgraph(participants, store, commitCh, logger),
 		participants:        participants,
 		reverseParticipants: reverseParticipants,
-		transactionPool:     [][]byte{},
+		mempool:             mempool.NewMempool(mempool.DefaultLimits()),
 		blockSignaturePool:  []hg.BlockSignature{},
 		logger:              logger,
 	}
@@ -189,6 +190,100 @@ func (c *Core) GetFrame() (hg.Frame, error) {
 	return c.hg.GetFrame()
 }
 
+//GetLastBlockWithFrame returns the last Block and the Frame of the last
+//consensus round. The Events of the Frame are received after that Block.
+func (c *Core) GetLastBlockWithFrame() (hg.Block, hg.Frame, error) {
+	index := c.hg.LastBlockIndex
+	if index < 0 {
+		return hg.Block{}, hg.Frame{}, fmt.Errorf("no Block yet")
+	}
+	block, err := c.hg.Store.GetBlock(index)
+	if err != nil {
+		return hg.Block{}, hg.Frame{}, err
+	}
+	frame, err := c.hg.GetFrame()
+	if err != nil {
+		return hg.Block{}, hg.Frame{}, err
+	}
+
+	//The Frame starts at the last consensus round. Events of earlier rounds
+	//that are not in a Block yet would be missing from it.
+	undetermined := make(map[string]bool, len(c.hg.UndeterminedEvents))
+	for _, x := range c.hg.UndeterminedEvents {
+		undetermined[x] = true
+	}
+	inFrame := make(map[string]bool, len(frame.Events))
+	events := []hg.Event{}
+	for _, ev := range frame.Events {
+		inFrame[ev.Hex()] = true
+		if undetermined[ev.Hex()] {
+			events = append(events, ev)
+			continue
+		}
+		//The last Event of a participant without witness in the last consensus
+		//round is already in a Block. It becomes the Root of its creator so
+		//that it is not ordered again.
+		root := frame.Roots[ev.Creator()]
+		if ev.SelfParent() != root.X {
+			return hg.Block{}, hg.Frame{}, fmt.Errorf("Event %s of the Frame is already in a Block", ev.Hex())
+		}
+		frame.Roots[ev.Creator()] = hg.Root{
+			X:      ev.Hex(),
+			Index:  ev.Index(),
+			Round:  c.hg.Round(ev.Hex()),
+			Others: map[string]string{},
+		}
+	}
+	for x := range undetermined {
+		if !inFrame[x] {
+			return hg.Block{}, hg.Frame{}, fmt.Errorf("undetermined Event %s is older than the Frame", x)
+		}
+	}
+	//Events on top of these Roots from other creators find them in Others
+	for _, ev := range events {
+		op := ev.OtherParent()
+		if op == "" || inFrame[op] && undetermined[op] {
+			continue
+		}
+		root := frame.Roots[ev.Creator()]
+		if ev.SelfParent() == root.X && op == root.Y {
+			continue
+		}
+		root.Others[ev.Hex()] = op
+	}
+	frame.Events = events
+	return block, frame, nil
+}
+
+//FastForward resets the hashgraph from a Frame and continues after the Block
+//that precedes it. The Events of the Frame must carry their wire info.
+func (c *Core) FastForward(block hg.Block, frame hg.Frame) error {
+	if err := c.hg.Reset(frame.Roots); err != nil {
+		return err
+	}
+
+	//Without Events of ours in the Frame, the head is our Root
+	root, ok := frame.Roots[c.HexID()]
+	if !ok {
+		return fmt.Errorf("no Root for %s in Frame", c.HexID())
+	}
+	c.Head = root.X
+	c.Seq = root.Index
+
+	for _, ev := range frame.Events {
+		if err := c.InsertEvent(ev, false); err != nil {
+			return err
+		}
+	}
+
+	if err := c.hg.Store.SetBlock(block); err != nil {
+		return err
+	}
+	c.hg.LastBlockIndex = block.Index()
+
+	return c.RunConsensus()
+}
+
 //returns events that c knowns about and are not in 'known'
 func (c *Core) EventDiff(known map[int]int) (events []hg.Event, err error) {
 	unknown := []hg.Event{}
@@ -219,33 +314,24 @@ func (c *Core) Sync(unknownEvents []hg.WireEvent) error {
 
 	c.logger.WithFields(logrus.Fields{
 		"unknown_events":       len(unknownEvents),
-		"transaction_pool":     len(c.transactionPool),
+		"transaction_pool":     c.mempool.Len(),
 		"block_signature_pool": len(c.blockSignaturePool),
 	}).Debug("Sync")
 
-	otherHead := ""
 	//add unknown events
-	for k, we := range unknownEvents {
-		ev, err := c.hg.ReadWireInfo(we)
-		if err != nil {
-			return err
-		}
-		if err := c.InsertEvent(*ev, false); err != nil {
-			return err
-		}
-		//assume last event corresponds to other-head
-		if k == len(unknownEvents)-1 {
-			otherHead = ev.Hex()
-		}
+	otherHead, err := c.InsertWireEvents(unknownEvents)
+	if err != nil {
+		return err
 	}
 
 	//create new event with self head and other head
 	//only if there are pending loaded events or the pools are not empty
+	txs := c.mempool.Pending()
 	if len(unknownEvents) > 0 ||
-		len(c.transactionPool) > 0 ||
+		len(txs) > 0 ||
 		len(c.blockSignaturePool) > 0 {
 
-		newHead := hg.NewEvent(c.transactionPool, c.blockSignaturePool,
+		newHead := hg.NewEvent(txs, c.blockSignaturePool,
 			[]string{c.Head, otherHead},
 			c.PubKey(),
 			c.Seq+1)
@@ -255,22 +341,58 @@ func (c *Core) Sync(unknownEvents []hg.WireEvent) error {
 		}
 
 		//empty the pools
-		c.transactionPool = [][]byte{}
+		c.mempool.Flush(len(txs))
 		c.blockSignaturePool = []hg.BlockSignature{}
 	}
 
 	return nil
 }
 
+//InsertWireEvents inserts Events received from another node and returns the
+//hash of the last one. Unlike Sync, it does not create a new head. Events that
+//are already known are skipped: they were computed from a Known map that other
+//syncs have made outdated since.
+func (c *Core) InsertWireEvents(wireEvents []hg.WireEvent) (string, error) {
+	known := c.KnownEvents()
+	last := ""
+	for _, we := range wireEvents {
+		ev, err := c.hg.ReadWireInfo(we)
+		if err != nil {
+			return "", err
+		}
+
+		if ev.Index() <= known[we.Body.CreatorID] {
+			hash, err := c.hg.Store.ParticipantEvent(ev.Creator(), ev.Index())
+			if err != nil {
+				return "", err
+			}
+			if hash != ev.Hex() {
+				return "", fmt.Errorf("Event %d of participant %d differs from the known one",
+					ev.Index(), we.Body.CreatorID)
+			}
+			last = hash
+			continue
+		}
+
+		if err := c.InsertEvent(*ev, false); err != nil {
+			return "", err
+		}
+		known[we.Body.CreatorID] = ev.Index()
+		last = ev.Hex()
+	}
+	return last, nil
+}
+
 func (c *Core) AddSelfEvent() error {
-	if len(c.transactionPool) == 0 && len(c.blockSignaturePool) == 0 {
+	txs := c.mempool.Pending()
+	if len(txs) == 0 && len(c.blockSignaturePool) == 0 {
 		c.logger.Debug("Empty transaction pool and block signature pool")
 		return nil
 	}
 
 	//create new event with self head and empty other parent
 	//empty transaction pool in its payload
-	newHead := hg.NewEvent(c.transactionPool,
+	newHead := hg.NewEvent(txs,
 		c.blockSignaturePool,
 		[]string{c.Head, ""},
 		c.PubKey(), c.Seq+1)
@@ -280,11 +402,11 @@ func (c *Core) AddSelfEvent() error {
 	}
 
 	c.logger.WithFields(logrus.Fields{
-		"transactions":     len(c.transactionPool),
+		"transactions":     len(txs),
 		"block_signatures": len(c.blockSignaturePool),
 	}).Debug("Created Self-Event")
 
-	c.transactionPool = [][]byte{}
+	c.mempool.Flush(len(txs))
 	c.blockSignaturePool = []hg.BlockSignature{}
 
 	return nil
@@ -338,8 +460,20 @@ func (c *Core) RunConsensus() error {
 	return nil
 }
 
-func (c *Core) AddTransactions(txs [][]byte) {
-	c.transactionPool = append(c.transactionPool, txs...)
+//AddTransactions adds transactions to the mempool and returns the first error,
+//if some of them are refused
+func (c *Core) AddTransactions(txs [][]byte) error {
+	var err error
+	for _, tx := range txs {
+		if e := c.mempool.Add(tx, ""); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+func (c *Core) Mempool() *mempool.Mempool {
+	return c.mempool
 }
 
 func (c *Core) AddBlockSignature(bs hg.BlockSignature) {
@@ -408,8 +542,18 @@ func (c *Core) GetLastBlockIndex() int {
 	return c.hg.LastBlockIndex
 }
 
+//GetLastDeliveredBlockIndex returns the index of the last Block committed by
+//the App, or -1
+func (c *Core) GetLastDeliveredBlockIndex() int {
+	return c.hg.Store.LastDeliveredBlock()
+}
+
+func (c *Core) SetLastDeliveredBlockIndex(index int) error {
+	return c.hg.Store.SetLastDeliveredBlock(index)
+}
+
 func (c *Core) NeedGossip() bool {
 	return c.hg.PendingLoadedEvents > 0 ||
-		len(c.transactionPool) > 0 ||
+		c.mempool.Len() > 0 ||
 		len(c.blockSignaturePool) > 0
 }
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"strconv"
 	"testing"
+	"time"
 
 	"github.com/champii/babble/common"
 	"github.com/champii/babble/crypto"
@@ -149,6 +150,46 @@ func TestEventDiff(t *testing.T) {
 
 }
 
+func TestSyncKnownEvents(t *testing.T) {
+	cores, keys, index := initCores(3, t)
+
+	initHashgraph(cores, keys, index, 0)
+
+	//P1 asks for the Events it does not know, but learns them from another
+	//sync before the response arrives
+	unknownBy1, err := cores[0].EventDiff(cores[1].KnownEvents())
+	if err != nil {
+		t.Fatal(err)
+	}
+	staleWire, err := cores[0].ToWire(unknownBy1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := synchronizeCores(cores, 0, 1, nil); err != nil {
+		t.Fatal(err)
+	}
+	known := cores[1].KnownEvents()
+
+	//Events it already knows are skipped
+	if err := cores[1].Sync(staleWire); err != nil {
+		t.Fatalf("syncing known Events should succeed: %s", err)
+	}
+	for id, index := range cores[1].KnownEvents() {
+		if id != 1 && index != known[id] {
+			t.Fatalf("known index of %d should still be %d, not %d", id, known[id], index)
+		}
+	}
+
+	//but not a different Event with the index of a known one
+	forked := make([]hg.WireEvent, len(staleWire))
+	copy(forked, staleWire)
+	last := len(forked) - 1
+	forked[last].Body.Timestamp = forked[last].Body.Timestamp.Add(time.Second)
+	if err := cores[1].Sync(forked); err == nil {
+		t.Fatalf("syncing an Event that differs from the known one should fail")
+	}
+}
+
 func TestSync(t *testing.T) {
 	cores, _, index := initCores(3, t)
 
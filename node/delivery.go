@@ -0,0 +1,269 @@
+package node
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	hg "github.com/champii/babble/hashgraph"
+)
+
+const (
+	//delay before committing a Block again after the App failed to commit it
+	commitBaseBackoff = 100 * time.Millisecond
+	//max delay between attempts, as a multiple of commitBaseBackoff
+	commitMaxBackoff = 64
+)
+
+//blockQueue holds the Blocks produced by the hashgraph until the App commits
+//them. It is not bounded, so that a slow or unavailable App never blocks
+//consensus.
+type blockQueue struct {
+	sync.Mutex
+	blocks  []hg.Block
+	retries int
+	readyCh chan struct{}
+}
+
+func newBlockQueue() *blockQueue {
+	return &blockQueue{
+		readyCh: make(chan struct{}, 1),
+	}
+}
+
+func (q *blockQueue) push(block hg.Block) {
+	q.Lock()
+	q.blocks = append(q.blocks, block)
+	q.Unlock()
+
+	select {
+	case q.readyCh <- struct{}{}:
+	default:
+	}
+}
+
+//peek returns the oldest Block of the queue without removing it
+func (q *blockQueue) peek() (hg.Block, bool) {
+	q.Lock()
+	defer q.Unlock()
+	if len(q.blocks) == 0 {
+		return hg.Block{}, false
+	}
+	return q.blocks[0], true
+}
+
+//reset replaces the content of the queue
+func (q *blockQueue) reset(blocks []hg.Block) {
+	q.Lock()
+	q.blocks = blocks
+	q.Unlock()
+
+	select {
+	case q.readyCh <- struct{}{}:
+	default:
+	}
+}
+
+func (q *blockQueue) pop() {
+	q.Lock()
+	defer q.Unlock()
+	if len(q.blocks) > 0 {
+		q.blocks = q.blocks[1:]
+	}
+}
+
+func (q *blockQueue) len() int {
+	q.Lock()
+	defer q.Unlock()
+	return len(q.blocks)
+}
+
+func (q *blockQueue) retried() {
+	q.Lock()
+	defer q.Unlock()
+	q.retries++
+}
+
+func (q *blockQueue) getRetries() int {
+	q.Lock()
+	defer q.Unlock()
+	return q.retries
+}
+
+//appHandshake carries the Handshake of an App to deliverBlocks
+type appHandshake struct {
+	lastBlockIndex int
+	stateHash      []byte
+	respCh         chan error
+}
+
+//deliverBlocks commits the queued Blocks to the App, in order, until the node
+//shuts down. A Block that the App fails to commit is tried again, with
+//exponential backoff, and the following Blocks wait for it. Handshakes and
+//fast-syncs are handled between two commits.
+func (n *Node) deliverBlocks() {
+	backoff := time.Duration(0)
+	//the end of the backoff does not move when queries are answered
+	var retry <-chan time.Time
+	for {
+		//Wait for a Block if there is none, or for the end of the backoff
+		var ready <-chan struct{}
+		var wait <-chan time.Time
+		block, ok := n.blocks.peek()
+		if !ok {
+			ready = n.blocks.readyCh
+		} else if backoff > 0 {
+			wait = retry
+		}
+
+		if ready != nil || wait != nil {
+			select {
+			case <-ready:
+				continue
+			case <-wait:
+			case h := <-n.handshakeCh:
+				h.respCh <- n.resumeApp(h.lastBlockIndex, h.stateHash)
+				backoff = 0
+				continue
+			case r := <-n.restoreCh:
+				r.respCh <- n.restoreApp(r)
+				backoff = 0
+				continue
+			case <-n.shutdownCh:
+				return
+			}
+		}
+
+		select {
+		case h := <-n.handshakeCh:
+			h.respCh <- n.resumeApp(h.lastBlockIndex, h.stateHash)
+			backoff = 0
+			continue
+		case r := <-n.restoreCh:
+			r.respCh <- n.restoreApp(r)
+			backoff = 0
+			continue
+		default:
+		}
+
+		if err := n.commit(block); err != nil {
+			if backoff == 0 {
+				backoff = commitBaseBackoff
+			} else if backoff < commitMaxBackoff*commitBaseBackoff {
+				backoff *= 2
+			}
+			retry = time.After(backoff)
+			n.blocks.retried()
+			n.logger.WithFields(logrus.Fields{
+				"index":    block.Index(),
+				"error":    err,
+				"retry_in": backoff,
+			}).Error("Committing Block")
+			continue
+		}
+
+		backoff = 0
+		n.blocks.pop()
+	}
+}
+
+//handleAppHandshake is the handler of the Handshakes of the App. It waits
+//until deliverBlocks has handled the Handshake.
+func (n *Node) handleAppHandshake(lastBlockIndex int, stateHash []byte) error {
+	h := appHandshake{
+		lastBlockIndex: lastBlockIndex,
+		stateHash:      stateHash,
+		respCh:         make(chan error, 1),
+	}
+	select {
+	case n.handshakeCh <- h:
+	case <-n.shutdownCh:
+		return fmt.Errorf("node is shutting down")
+	}
+	select {
+	case err := <-h.respCh:
+		return err
+	case <-n.shutdownCh:
+		return fmt.Errorf("node is shutting down")
+	}
+}
+
+//resumeApp checks the last Block committed by the App against the one the
+//node signed, and queues the Blocks that follow it
+func (n *Node) resumeApp(lastBlockIndex int, stateHash []byte) error {
+	n.coreLock.Lock()
+	defer n.coreLock.Unlock()
+
+	logger := n.logger.WithFields(logrus.Fields{
+		"last_block_index": lastBlockIndex,
+		"state_hash":       fmt.Sprintf("0x%X", stateHash),
+	})
+
+	last := n.core.GetLastBlockIndex()
+	if lastBlockIndex > last {
+		err := fmt.Errorf("App is ahead of the node: Block %d, node has %d",
+			lastBlockIndex, last)
+		logger.WithField("error", err).Error("App Handshake")
+		return err
+	}
+
+	if lastBlockIndex >= 0 {
+		if err := n.checkAppBlock(lastBlockIndex, stateHash); err != nil {
+			logger.WithField("error", err).Error("App Handshake")
+			return err
+		}
+	}
+
+	var blocks []hg.Block
+	if lastBlockIndex < last {
+		stored, err := n.core.hg.Store.GetBlocks(lastBlockIndex+1, last)
+		if err == nil && len(stored) != last-lastBlockIndex {
+			err = fmt.Errorf("Blocks %d to %d are no longer available",
+				lastBlockIndex+1, last)
+		}
+		if err != nil {
+			logger.WithField("error", err).Error("App Handshake")
+			return err
+		}
+
+		//Replayed Blocks are sent as they were produced, without the state
+		//hash and signatures of their previous delivery
+		for _, b := range stored {
+			blocks = append(blocks, hg.NewBlock(b.Index(), b.RoundReceived(), b.Transactions()))
+		}
+	}
+
+	if err := n.core.SetLastDeliveredBlockIndex(lastBlockIndex); err != nil {
+		return err
+	}
+	n.blocks.reset(blocks)
+
+	logger.WithField("replay", len(blocks)).Info("App Handshake")
+	return nil
+}
+
+//checkAppBlock returns an error unless the state hash reported by the App for
+//a Block is the one the node signed. Blocks that the node has not signed, in
+//a database created after the App committed them, are not checked.
+func (n *Node) checkAppBlock(index int, stateHash []byte) error {
+	block, err := n.core.hg.Store.GetBlock(index)
+	if err != nil {
+		return fmt.Errorf("Block %d not found: %s", index, err)
+	}
+
+	sig, err := block.GetSignature(n.core.HexID())
+	if err != nil {
+		return nil
+	}
+	if ok, err := block.Verify(sig); err != nil || !ok {
+		return fmt.Errorf("invalid signature on Block %d", index)
+	}
+	if !bytes.Equal(block.StateHash(), stateHash) {
+		return fmt.Errorf("state hash of Block %d does not match: App has 0x%X, node signed 0x%X",
+			index, stateHash, block.StateHash())
+	}
+	return nil
+}
@@ -0,0 +1,273 @@
+package node
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/champii/babble/common"
+	hg "github.com/champii/babble/hashgraph"
+	"github.com/champii/babble/net"
+	aproxy "github.com/champii/babble/proxy/app"
+)
+
+//flakyAppProxy fails to commit Blocks until it is given a number of attempts
+type flakyAppProxy struct {
+	*aproxy.InmemAppProxy
+
+	sync.Mutex
+	failures  int
+	committed []int
+}
+
+func (p *flakyAppProxy) CommitBlock(block hg.Block) ([]byte, error) {
+	p.Lock()
+	defer p.Unlock()
+	if p.failures > 0 {
+		p.failures--
+		return nil, fmt.Errorf("app unavailable")
+	}
+	p.committed = append(p.committed, block.Index())
+	return p.InmemAppProxy.CommitBlock(block)
+}
+
+func (p *flakyAppProxy) getCommitted() []int {
+	p.Lock()
+	defer p.Unlock()
+	return append([]int{}, p.committed...)
+}
+
+func TestDeliverBlocks(t *testing.T) {
+	logger := common.NewTestLogger(t)
+	keys, peers, pmap := initPeers(1)
+	_, trans := net.NewInmemTransport(peers[0].NetAddr)
+
+	prox := &flakyAppProxy{
+		InmemAppProxy: aproxy.NewInmemAppProxy(logger),
+		failures:      2,
+	}
+	node := NewNode(TestConfig(t), 0, keys[0], peers,
+		hg.NewInmemStore(pmap, 100), trans, prox)
+	node.goFunc(node.deliverBlocks)
+	defer node.Shutdown()
+
+	waitDelivered := func(index int) {
+		timeout := time.After(5 * time.Second)
+		for node.core.GetLastDeliveredBlockIndex() != index {
+			select {
+			case <-timeout:
+				t.Fatalf("timeout waiting for Block %d, last delivered: %d",
+					index, node.core.GetLastDeliveredBlockIndex())
+			case <-time.After(10 * time.Millisecond):
+			}
+		}
+	}
+
+	// The first Block is retried until the App commits it, and the next ones
+	// wait for it
+	for i := 0; i < 3; i++ {
+		node.blocks.push(hg.NewBlock(i, i, [][]byte{[]byte(fmt.Sprintf("tx%d", i))}))
+	}
+	waitDelivered(2)
+
+	if c := prox.getCommitted(); fmt.Sprint(c) != "[0 1 2]" {
+		t.Fatalf("Blocks should be committed once and in order, got %v", c)
+	}
+	if r := node.blocks.getRetries(); r != 2 {
+		t.Fatalf("expected 2 retries, got %d", r)
+	}
+
+	// Blocks produced again, as when the node bootstraps, are only delivered
+	// if the App has not acknowledged them
+	for i := 0; i < 5; i++ {
+		node.blocks.push(hg.NewBlock(i, i, [][]byte{[]byte(fmt.Sprintf("tx%d", i))}))
+	}
+	waitDelivered(4)
+
+	if c := prox.getCommitted(); fmt.Sprint(c) != "[0 1 2 3 4]" {
+		t.Fatalf("acknowledged Blocks should not be delivered again, got %v", c)
+	}
+
+	// Delivered Blocks are signed with the state hash returned by the App
+	block, err := node.core.hg.Store.GetBlock(4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(block.StateHash()) == 0 || len(block.Signatures) != 1 {
+		t.Fatalf("Block 4 should be signed with a state hash")
+	}
+}
+
+func TestAppHandshake(t *testing.T) {
+	logger := common.NewTestLogger(t)
+	keys, peers, pmap := initPeers(1)
+	_, trans := net.NewInmemTransport(peers[0].NetAddr)
+
+	prox := &flakyAppProxy{InmemAppProxy: aproxy.NewInmemAppProxy(logger)}
+	node := NewNode(TestConfig(t), 0, keys[0], peers,
+		hg.NewInmemStore(pmap, 100), trans, prox)
+	node.goFunc(node.deliverBlocks)
+	defer node.Shutdown()
+
+	//Blocks 0 to 4 are produced and delivered
+	for i := 0; i < 5; i++ {
+		block := hg.NewBlock(i, i, [][]byte{[]byte(fmt.Sprintf("tx%d", i))})
+		if err := node.core.hg.Store.SetBlock(block); err != nil {
+			t.Fatal(err)
+		}
+		node.core.hg.LastBlockIndex = i
+		node.blocks.push(block)
+	}
+	waitCommitted := func(expected string) {
+		timeout := time.After(5 * time.Second)
+		for fmt.Sprint(prox.getCommitted()) != expected {
+			select {
+			case <-timeout:
+				t.Fatalf("expected committed Blocks %s, got %v", expected, prox.getCommitted())
+			case <-time.After(10 * time.Millisecond):
+			}
+		}
+	}
+	waitCommitted("[0 1 2 3 4]")
+
+	block2, err := node.core.hg.Store.GetBlock(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := node.handleAppHandshake(5, []byte("hash")); err == nil {
+		t.Fatalf("an App ahead of the node should be refused")
+	}
+	if err := node.handleAppHandshake(2, []byte("other hash")); err == nil {
+		t.Fatalf("an App with a different state hash should be refused")
+	}
+
+	//The App lost Blocks 3 and 4
+	if err := node.handleAppHandshake(2, block2.StateHash()); err != nil {
+		t.Fatal(err)
+	}
+	waitCommitted("[0 1 2 3 4 3 4]")
+
+	//The App lost everything
+	if err := node.handleAppHandshake(-1, nil); err != nil {
+		t.Fatal(err)
+	}
+	waitCommitted("[0 1 2 3 4 3 4 0 1 2 3 4]")
+
+	if d := node.core.GetLastDeliveredBlockIndex(); d != 4 {
+		t.Fatalf("last delivered Block should be 4, not %d", d)
+	}
+}
+
+//queryAppProxy answers queries with the number of Blocks it committed
+type queryAppProxy struct {
+	*flakyAppProxy
+}
+
+func (p *queryAppProxy) Query(query []byte) ([]byte, error) {
+	return []byte(fmt.Sprint(len(p.getCommitted()))), nil
+}
+
+func TestQuery(t *testing.T) {
+	logger := common.NewTestLogger(t)
+	keys, peers, pmap := initPeers(1)
+	_, trans := net.NewInmemTransport(peers[0].NetAddr)
+
+	prox := &flakyAppProxy{InmemAppProxy: aproxy.NewInmemAppProxy(logger)}
+	node := NewNode(TestConfig(t), 0, keys[0], peers,
+		hg.NewInmemStore(pmap, 100), trans, prox)
+	if _, err := node.Query([]byte("blocks")); err != ErrQueryUnsupported {
+		t.Fatalf("expected ErrQueryUnsupported, got %v", err)
+	}
+	node.Shutdown()
+
+	_, trans = net.NewInmemTransport(peers[0].NetAddr)
+	qprox := &queryAppProxy{
+		flakyAppProxy: &flakyAppProxy{
+			InmemAppProxy: aproxy.NewInmemAppProxy(logger),
+			failures:      2,
+		},
+	}
+	node = NewNode(TestConfig(t), 0, keys[0], peers,
+		hg.NewInmemStore(pmap, 100), trans, qprox)
+	node.goFunc(node.deliverBlocks)
+	defer node.Shutdown()
+
+	res, err := node.Query([]byte("blocks"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.BlockIndex != -1 || string(res.Result) != "0" {
+		t.Fatalf("expected 0 Blocks at index -1, got %s at index %d", res.Result, res.BlockIndex)
+	}
+
+	//Queries are answered while the App fails to commit Blocks, and their
+	//result is that of the last committed Block
+	for i := 0; i < 3; i++ {
+		node.blocks.push(hg.NewBlock(i, i, [][]byte{[]byte(fmt.Sprintf("tx%d", i))}))
+	}
+	timeout := time.After(5 * time.Second)
+	for {
+		res, err := node.Query([]byte("blocks"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fmt.Sprint(res.BlockIndex+1) != string(res.Result) {
+			t.Fatalf("%s Blocks committed at index %d", res.Result, res.BlockIndex)
+		}
+		if res.BlockIndex == 2 {
+			break
+		}
+		select {
+		case <-timeout:
+			t.Fatalf("timeout waiting for Block 2, last queried: %d", res.BlockIndex)
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+//silentAppProxy never answers queries
+type silentAppProxy struct {
+	*flakyAppProxy
+	quit chan struct{}
+}
+
+func (p *silentAppProxy) Query(query []byte) ([]byte, error) {
+	<-p.quit
+	return nil, fmt.Errorf("quit")
+}
+
+func TestQueryTimeout(t *testing.T) {
+	defer func(timeout time.Duration) { queryTimeout = timeout }(queryTimeout)
+	queryTimeout = 50 * time.Millisecond
+
+	logger := common.NewTestLogger(t)
+	keys, peers, pmap := initPeers(1)
+	_, trans := net.NewInmemTransport(peers[0].NetAddr)
+
+	prox := &silentAppProxy{
+		flakyAppProxy: &flakyAppProxy{InmemAppProxy: aproxy.NewInmemAppProxy(logger)},
+		quit:          make(chan struct{}),
+	}
+	defer close(prox.quit)
+	node := NewNode(TestConfig(t), 0, keys[0], peers,
+		hg.NewInmemStore(pmap, 100), trans, prox)
+	node.goFunc(node.deliverBlocks)
+	defer node.Shutdown()
+
+	if _, err := node.Query([]byte("blocks")); err == nil {
+		t.Fatalf("a query that the App does not answer should time out")
+	}
+
+	//Blocks are still delivered
+	node.blocks.push(hg.NewBlock(0, 0, [][]byte{[]byte("tx0")}))
+	timeout := time.After(5 * time.Second)
+	for len(prox.getCommitted()) == 0 {
+		select {
+		case <-timeout:
+			t.Fatalf("timeout waiting for Block 0")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
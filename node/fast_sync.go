@@ -0,0 +1,412 @@
+package node
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	hg "github.com/champii/babble/hashgraph"
+	"github.com/champii/babble/net"
+)
+
+//snapshotChunkSize is the size of the chunks of the App snapshots that are
+//sent to the nodes that fast-sync from us. It is a var so that tests can split
+//small snapshots.
+var snapshotChunkSize = 1024 * 1024
+
+//maxSnapshotSize bounds the App snapshots that we download from a peer
+var maxSnapshotSize = 1024 * 1024 * 1024
+
+//appSnapshot is the state of the App at a Block
+type appSnapshot struct {
+	blockIndex int
+	data       []byte
+}
+
+//appRestore carries a fast-sync to deliverBlocks, so that it does not happen
+//while a Block is committed
+type appRestore struct {
+	block    hg.Block
+	frame    hg.Frame
+	snapshot []byte
+	respCh   chan error
+}
+
+//fastForward catches up with a peer when we are too far behind to sync the
+//Events we miss: the App is restored from a snapshot of the App of the peer,
+//and the hashgraph is reset from the Frame that follows the Block of the
+//snapshot. Failed attempts are tried again with the next peer.
+func (n *Node) fastForward() error {
+	n.logger.Debug("IN CATCHING-UP STATE")
+
+	trans, ok := n.trans.(net.WithFastForward)
+	if !ok {
+		n.logger.Debug("Transport does not support fast-sync")
+		n.compareAndSetState(CatchingUp, Babbling)
+		return net.ErrFastForwardUnsupported
+	}
+
+	n.selectorLock.Lock()
+	peer := n.peerSelector.Next()
+	n.selectorLock.Unlock()
+
+	start := time.Now()
+	block, err := n.fastForwardFrom(trans, peer.NetAddr)
+	if err != nil {
+		n.logger.WithFields(logrus.Fields{
+			"from":  peer.NetAddr,
+			"error": err,
+		}).Error("fastForward()")
+		n.peerFailure(peer.NetAddr)
+
+		select {
+		case <-time.After(n.conf.HeartbeatTimeout):
+		case <-n.shutdownCh:
+		}
+		return err
+	}
+
+	n.logger.WithFields(logrus.Fields{
+		"from":       peer.NetAddr,
+		"block":      block.Index(),
+		"state_hash": fmt.Sprintf("0x%X", block.StateHash()),
+		"duration":   time.Since(start).Nanoseconds(),
+	}).Info("Fast-forward")
+
+	//Gossip right away to get the Events that followed the Frame
+	n.setStarting(true)
+	n.compareAndSetState(CatchingUp, Babbling)
+	return nil
+}
+
+//fastForwardFrom gets the last Block, the Frame and the App snapshot of a peer
+//and has deliverBlocks restore them
+func (n *Node) fastForwardFrom(trans net.WithFastForward, peerAddr string) (hg.Block, error) {
+	args := net.FastForwardRequest{
+		FromID: n.id,
+		ToID:   n.peerID(peerAddr),
+	}
+	if err := args.Sign(n.core.key); err != nil {
+		return hg.Block{}, err
+	}
+
+	var resp net.FastForwardResponse
+	if err := trans.FastForward(peerAddr, &args, &resp); err != nil {
+		return hg.Block{}, err
+	}
+	block, frame := resp.Block, resp.Frame
+	n.logger.WithFields(logrus.Fields{
+		"from_id":       resp.FromID,
+		"block":         block.Index(),
+		"events":        len(frame.Events),
+		"snapshot_size": resp.SnapshotSize,
+	}).Debug("FastForwardResponse")
+
+	n.coreLock.Lock()
+	last := n.core.GetLastBlockIndex()
+	n.coreLock.Unlock()
+	if block.Index() <= last {
+		return hg.Block{}, fmt.Errorf("peer is not ahead: Block %d, we have %d",
+			block.Index(), last)
+	}
+	if err := n.checkPeerBlock(block); err != nil {
+		return hg.Block{}, err
+	}
+	if resp.SnapshotSize < 0 || resp.SnapshotSize > maxSnapshotSize {
+		return hg.Block{}, fmt.Errorf("snapshot of %d bytes announced, at most %d accepted",
+			resp.SnapshotSize, maxSnapshotSize)
+	}
+	if len(resp.WireInfo) != len(frame.Events) {
+		return hg.Block{}, fmt.Errorf("wire info of %d Events for a Frame of %d",
+			len(resp.WireInfo), len(frame.Events))
+	}
+	for i, w := range resp.WireInfo {
+		frame.Events[i].SetWireInfo(w.SelfParentIndex,
+			w.OtherParentCreatorID,
+			w.OtherParentIndex,
+			w.CreatorID)
+	}
+	if err := n.checkPeerFrame(block, frame); err != nil {
+		return hg.Block{}, err
+	}
+
+	snapshot, err := n.fetchSnapshot(trans, peerAddr, block.Index(), resp.SnapshotSize)
+	if err != nil {
+		return hg.Block{}, err
+	}
+
+	r := appRestore{
+		block:    block,
+		frame:    frame,
+		snapshot: snapshot,
+		respCh:   make(chan error, 1),
+	}
+	select {
+	case n.restoreCh <- r:
+	case <-n.shutdownCh:
+		return hg.Block{}, fmt.Errorf("node is shutting down")
+	}
+	select {
+	case err := <-r.respCh:
+		return block, err
+	case <-n.shutdownCh:
+		return hg.Block{}, fmt.Errorf("node is shutting down")
+	}
+}
+
+//checkPeerBlock returns an error unless a Block received from a peer carries
+//valid signatures of its body and state hash from more than a third of the
+//participants, so that at least one honest participant vouches for it
+func (n *Node) checkPeerBlock(block hg.Block) error {
+	valid := 0
+	for validator := range block.Signatures {
+		if _, ok := n.core.hg.Participants[validator]; !ok {
+			return fmt.Errorf("Block %d signed by unknown validator %s",
+				block.Index(), validator)
+		}
+		sig, err := block.GetSignature(validator)
+		if err != nil {
+			return err
+		}
+		if ok, err := block.Verify(sig); err != nil || !ok {
+			return fmt.Errorf("invalid signature on Block %d", block.Index())
+		}
+		valid++
+	}
+	if threshold := len(n.core.hg.Participants)/3 + 1; valid < threshold {
+		return fmt.Errorf("Block %d has %d valid signatures, %d required",
+			block.Index(), valid, threshold)
+	}
+	return nil
+}
+
+//checkPeerFrame returns an error unless a Frame received from a peer can
+//follow its Block: there is a Root for every participant, at least one of them
+//in the rounds of the Block, and the Events are signed by their creators and
+//chained onto the Roots. It is checked before the App is restored, since the
+//hashgraph can only refuse the Frame after.
+func (n *Node) checkPeerFrame(block hg.Block, frame hg.Frame) error {
+	participants := n.core.hg.Participants
+	if len(frame.Roots) != len(participants) {
+		return fmt.Errorf("Frame has %d Roots for %d participants",
+			len(frame.Roots), len(participants))
+	}
+	lastRound := -1
+	for p, root := range frame.Roots {
+		if _, ok := participants[p]; !ok {
+			return fmt.Errorf("Frame has a Root for unknown participant %s", p)
+		}
+		if root.Round > lastRound {
+			lastRound = root.Round
+		}
+	}
+	//Witnesses of the last consensus round decided the Block, so their
+	//self-parents are at most one round before it
+	if lastRound < block.RoundReceived()-1 {
+		return fmt.Errorf("Frame ends at round %d, before Block %d of round %d",
+			lastRound, block.Index(), block.RoundReceived())
+	}
+
+	heads := make(map[string]hg.Root, len(frame.Roots))
+	for p, root := range frame.Roots {
+		heads[p] = root
+	}
+	for _, ev := range frame.Events {
+		head, ok := heads[ev.Creator()]
+		if !ok {
+			return fmt.Errorf("Frame Event %s from unknown participant %s",
+				ev.Hex(), ev.Creator())
+		}
+		if ev.SelfParent() != head.X || ev.Index() != head.Index+1 {
+			return fmt.Errorf("Frame Event %s does not follow %s", ev.Hex(), head.X)
+		}
+		if ok, err := ev.Verify(); err != nil || !ok {
+			return fmt.Errorf("invalid signature on Frame Event %s", ev.Hex())
+		}
+		heads[ev.Creator()] = hg.Root{X: ev.Hex(), Index: ev.Index()}
+	}
+	return nil
+}
+
+//fetchSnapshot downloads the App snapshot of a peer at a Block, chunk by chunk
+func (n *Node) fetchSnapshot(trans net.WithFastForward, peerAddr string, blockIndex, size int) ([]byte, error) {
+	snapshot := []byte{}
+	for {
+		args := net.SnapshotRequest{
+			FromID:     n.id,
+			ToID:       n.peerID(peerAddr),
+			BlockIndex: blockIndex,
+			Offset:     len(snapshot),
+		}
+		if err := args.Sign(n.core.key); err != nil {
+			return nil, err
+		}
+
+		var resp net.SnapshotResponse
+		if err := trans.Snapshot(peerAddr, &args, &resp); err != nil {
+			return nil, err
+		}
+		snapshot = append(snapshot, resp.Chunk...)
+		if len(snapshot) > size {
+			return nil, fmt.Errorf("snapshot larger than announced: %d bytes", size)
+		}
+		if resp.Done {
+			break
+		}
+		if len(resp.Chunk) == 0 {
+			return nil, fmt.Errorf("empty snapshot chunk at offset %d", args.Offset)
+		}
+	}
+	if len(snapshot) != size {
+		return nil, fmt.Errorf("snapshot of %d bytes, %d announced", len(snapshot), size)
+	}
+	return snapshot, nil
+}
+
+//restoreApp restores the App from a snapshot taken at a Block, checking that
+//it results in the state hash of the Block, then resets the hashgraph from the
+//Frame that follows it. The Blocks that were not delivered are dropped.
+func (n *Node) restoreApp(r appRestore) error {
+	n.appLock.Lock()
+	defer n.appLock.Unlock()
+
+	stateHash, err := n.proxy.Restore(r.snapshot)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(stateHash, r.block.StateHash()) {
+		return fmt.Errorf("state hash of restored App does not match Block %d: App has 0x%X, Block has 0x%X",
+			r.block.Index(), stateHash, r.block.StateHash())
+	}
+
+	n.coreLock.Lock()
+	defer n.coreLock.Unlock()
+
+	n.blocks.reset(nil)
+	if err := n.core.FastForward(r.block, r.frame); err != nil {
+		return err
+	}
+	return n.core.SetLastDeliveredBlockIndex(r.block.Index())
+}
+
+//++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++
+
+//processFastForwardRequest answers from another goroutine, so that other RPCs
+//are processed while the App takes its snapshot
+func (n *Node) processFastForwardRequest(rpc net.RPC, cmd *net.FastForwardRequest) {
+	n.logger.WithField("from_id", cmd.FromID).Debug("process FastForwardRequest")
+
+	//Only Blocks that the App committed have a snapshot
+	n.coreLock.Lock()
+	block, frame, err := n.core.GetLastBlockWithFrame()
+	delivered := n.core.GetLastDeliveredBlockIndex()
+	n.coreLock.Unlock()
+	if err == nil && block.Index() > delivered {
+		err = fmt.Errorf("Block %d not committed by the App yet", block.Index())
+	}
+	if err != nil {
+		n.logger.WithField("error", err).Error("Preparing FastForwardResponse")
+		rpc.Respond(nil, toRPCErr(err, net.Internal))
+		return
+	}
+
+	n.goFunc(func() {
+		snapshot, err := n.announceSnapshot(block.Index())
+		if err != nil {
+			n.logger.WithField("error", err).Error("Preparing FastForwardResponse")
+			rpc.Respond(nil, err)
+			return
+		}
+
+		wireInfo := make([]net.EventWireInfo, len(frame.Events))
+		for i, e := range frame.Events {
+			wireInfo[i] = net.NewEventWireInfo(e)
+		}
+
+		n.logger.WithFields(logrus.Fields{
+			"block":         block.Index(),
+			"events":        len(frame.Events),
+			"snapshot_size": len(snapshot),
+		}).Debug("Responding to FastForwardRequest")
+
+		rpc.Respond(&net.FastForwardResponse{
+			FromID:       n.id,
+			Block:        block,
+			Frame:        frame,
+			WireInfo:     wireInfo,
+			SnapshotSize: len(snapshot),
+		}, nil)
+	})
+}
+
+func (n *Node) processSnapshotRequest(rpc net.RPC, cmd *net.SnapshotRequest) {
+	n.logger.WithFields(logrus.Fields{
+		"from_id": cmd.FromID,
+		"block":   cmd.BlockIndex,
+		"offset":  cmd.Offset,
+	}).Debug("process SnapshotRequest")
+
+	snapshot, err := n.announcedSnapshot(cmd.BlockIndex)
+	if err == nil && (cmd.Offset < 0 || cmd.Offset > len(snapshot)) {
+		err = fmt.Errorf("offset %d out of snapshot of %d bytes", cmd.Offset, len(snapshot))
+	}
+	if err != nil {
+		n.logger.WithField("error", err).Error("Preparing SnapshotResponse")
+		rpc.Respond(nil, toRPCErr(err, net.Internal))
+		return
+	}
+
+	end := cmd.Offset + snapshotChunkSize
+	if end > len(snapshot) {
+		end = len(snapshot)
+	}
+	rpc.Respond(&net.SnapshotResponse{
+		FromID: n.id,
+		Chunk:  snapshot[cmd.Offset:end],
+		Done:   end == len(snapshot),
+	}, nil)
+}
+
+//announceSnapshot returns the App snapshot at a Block, and keeps it for the
+//SnapshotRequests that follow the FastForwardResponse. The App is asked for one
+//snapshot at a time; other FastForwardRequests are refused meanwhile.
+func (n *Node) announceSnapshot(blockIndex int) ([]byte, error) {
+	n.snapshotLock.Lock()
+	if s := n.snapshot; s != nil && s.blockIndex == blockIndex {
+		n.snapshotLock.Unlock()
+		return s.data, nil
+	}
+	if n.fetchingSnapshot {
+		n.snapshotLock.Unlock()
+		return nil, net.NewRPCErr(net.Overloaded, "already preparing a snapshot")
+	}
+	n.fetchingSnapshot = true
+	n.snapshotLock.Unlock()
+
+	data, err := n.proxy.GetSnapshot(blockIndex)
+
+	n.snapshotLock.Lock()
+	defer n.snapshotLock.Unlock()
+	n.fetchingSnapshot = false
+	if err != nil {
+		return nil, toRPCErr(err, net.Internal)
+	}
+	n.snapshot = &appSnapshot{
+		blockIndex: blockIndex,
+		data:       data,
+	}
+	return data, nil
+}
+
+//announcedSnapshot returns the snapshot of the last FastForwardResponse, which
+//is the only one served to SnapshotRequests
+func (n *Node) announcedSnapshot(blockIndex int) ([]byte, error) {
+	n.snapshotLock.Lock()
+	defer n.snapshotLock.Unlock()
+	if n.snapshot == nil || n.snapshot.blockIndex != blockIndex {
+		return nil, fmt.Errorf("no snapshot announced at Block %d", blockIndex)
+	}
+	return n.snapshot.data, nil
+}
@@ -0,0 +1,200 @@
+package node
+
+import (
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/champii/babble/common"
+	hg "github.com/champii/babble/hashgraph"
+	"github.com/champii/babble/net"
+	aproxy "github.com/champii/babble/proxy/app"
+)
+
+//initFaultyNodes creates nodes connected by in-memory transports wrapped in
+//FaultyTransports. Each transport gets its own seed derived from seed.
+func initFaultyNodes(n int, seed int64, faults net.FaultConfig,
+	logger *logrus.Logger, t testing.TB) ([]*Node, []*net.FaultyTransport) {
+
+	keys, peers, pmap := initPeers(n)
+
+	inmems := []*net.InmemTransport{}
+	for _, p := range peers {
+		_, inmem := net.NewInmemTransport(p.NetAddr)
+		inmems = append(inmems, inmem)
+	}
+	for i, a := range inmems {
+		for j, b := range inmems {
+			if i != j {
+				a.Connect(peers[j].NetAddr, b)
+			}
+		}
+	}
+
+	nodes := []*Node{}
+	transports := []*net.FaultyTransport{}
+	for i := 0; i < len(peers); i++ {
+		conf := NewConfig(5*time.Millisecond, time.Second, 1000, 1000,
+			"inmem", "", logger)
+
+		trans := net.NewFaultyTransport(inmems[i], faults, seed+int64(i))
+		node := NewNode(conf, pmap[peers[i].PubKeyHex], keys[i], peers,
+			hg.NewInmemStore(pmap, conf.CacheSize),
+			trans,
+			aproxy.NewInmemAppProxy(logger))
+		if err := node.Init(false); err != nil {
+			t.Fatalf("failed to initialize node%d: %s", i, err)
+		}
+		nodes = append(nodes, node)
+		transports = append(transports, trans)
+	}
+	return nodes, transports
+}
+
+//cut prevents the nodes in from from sending requests to the nodes in to
+func cut(nodes []*Node, transports []*net.FaultyTransport, from, to []int) {
+	for _, i := range from {
+		for _, j := range to {
+			transports[i].Partition(nodes[j].localAddr)
+		}
+	}
+}
+
+func healAll(transports []*net.FaultyTransport) {
+	for _, trans := range transports {
+		trans.Heal()
+	}
+}
+
+func TestFaultsLatency(t *testing.T) {
+	logger := common.NewTestLogger(t)
+	nodes, _ := initFaultyNodes(4, 1, net.FaultConfig{
+		Latency: 2 * time.Millisecond,
+		Jitter:  5 * time.Millisecond,
+	}, logger, t)
+	defer shutdownNodes(nodes)
+
+	if err := gossip(nodes, 10, false, 10*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	checkGossip(nodes, t)
+}
+
+func TestFaultsDropsAndDuplicates(t *testing.T) {
+	logger := common.NewTestLogger(t)
+	nodes, transports := initFaultyNodes(4, 2, net.FaultConfig{
+		Jitter:        2 * time.Millisecond,
+		DropRate:      0.2,
+		DuplicateRate: 0.2,
+	}, logger, t)
+	defer shutdownNodes(nodes)
+
+	if err := gossip(nodes, 10, false, 10*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	checkGossip(nodes, t)
+
+	stats := transports[0].FaultStats()
+	if stats.Dropped == 0 || stats.Duplicated == 0 {
+		t.Fatalf("faults should have been injected: %#v", stats)
+	}
+}
+
+func TestFaultsAsymmetricPartition(t *testing.T) {
+	logger := common.NewTestLogger(t)
+	nodes, transports := initFaultyNodes(4, 3, net.FaultConfig{}, logger, t)
+	defer shutdownNodes(nodes)
+
+	//node0 can not reach anyone but everyone can reach node0, so its Events
+	//are still pulled by the others and it keeps up through EagerSyncs
+	cut(nodes, transports, []int{0}, []int{1, 2, 3})
+
+	if err := gossip(nodes, 10, false, 10*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	checkGossip(nodes, t)
+}
+
+func TestFaultsPartitionAndHeal(t *testing.T) {
+	logger := common.NewTestLogger(t)
+	nodes, transports := initFaultyNodes(4, 4, net.FaultConfig{
+		Jitter: time.Millisecond,
+	}, logger, t)
+	defer shutdownNodes(nodes)
+
+	//Isolate node3. The other three are a supermajority so they keep going
+	cut(nodes, transports, []int{3}, []int{0, 1, 2})
+	cut(nodes, transports, []int{0, 1, 2}, []int{3})
+
+	if err := gossip(nodes[:3], 10, false, 10*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	runNodes(nodes[3:], true)
+
+	//node3 is stuck
+	time.Sleep(100 * time.Millisecond)
+	if r := lastRounds(nodes[3:])[0]; r > 0 {
+		t.Fatalf("isolated node should not reach consensus, got round %d", r)
+	}
+
+	//Once healed, node3 catches up and everyone keeps going
+	healAll(transports)
+
+	if err := bombardAndWait(nodes, 15, 10*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	checkGossip(nodes, t)
+}
+
+func TestFaultsMinorityPartition(t *testing.T) {
+	logger := common.NewTestLogger(t)
+	nodes, transports := initFaultyNodes(4, 5, net.FaultConfig{}, logger, t)
+	defer shutdownNodes(nodes)
+
+	if err := gossip(nodes, 5, false, 10*time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	//Split the network in two halves. Neither is a supermajority so no new
+	//round can be decided, but nothing inconsistent can be decided either
+	cut(nodes, transports, []int{0, 1}, []int{2, 3})
+	cut(nodes, transports, []int{2, 3}, []int{0, 1})
+
+	//Let the Events that were in flight settle before measuring progress
+	time.Sleep(100 * time.Millisecond)
+	before := lastRounds(nodes)
+
+	quit := make(chan struct{})
+	makeRandomTransactions(nodes, quit)
+	time.Sleep(300 * time.Millisecond)
+	close(quit)
+
+	after := lastRounds(nodes)
+	for i := range nodes {
+		if after[i] > before[i]+1 {
+			t.Fatalf("node%d decided rounds %d to %d without a supermajority", i, before[i], after[i])
+		}
+	}
+	checkGossip(nodes, t)
+
+	//Healing restores liveness
+	healAll(transports)
+
+	if err := bombardAndWait(nodes, after[0]+5, 10*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	checkGossip(nodes, t)
+}
+
+func lastRounds(nodes []*Node) []int {
+	rounds := make([]int, len(nodes))
+	for i, n := range nodes {
+		n.coreLock.Lock()
+		if r := n.core.GetLastConsensusRoundIndex(); r != nil {
+			rounds[i] = *r
+		}
+		n.coreLock.Unlock()
+	}
+	return rounds
+}
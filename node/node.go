@@ -2,6 +2,7 @@ package node
 
 import (
 	"crypto/ecdsa"
+	"encoding/hex"
 	"fmt"
 	"sync"
 	"time"
@@ -10,6 +11,7 @@ import (
 
 	"strconv"
 
+	cm "github.com/champii/babble/common"
 	hg "github.com/champii/babble/hashgraph"
 	"github.com/champii/babble/net"
 	"github.com/champii/babble/proxy"
@@ -28,8 +30,14 @@ type Node struct {
 	localAddr string
 
 	peerSelector PeerSelector
+	gossiping    map[string]bool //peers with a gossip in progress
 	selectorLock sync.Mutex
 
+	peerBook  *peerBook
+	peerStore net.PeerStore
+
+	requests *requestFilter
+
 	trans net.Transport
 	netCh <-chan net.RPC
 
@@ -37,6 +45,17 @@ type Node struct {
 	submitCh chan []byte
 
 	commitCh chan hg.Block
+	blocks   *blockQueue
+
+	handshakeCh chan appHandshake
+	restoreCh   chan appRestore
+
+	//held by queries, so that commits and restores of the App wait for them
+	appLock sync.RWMutex
+
+	snapshot         *appSnapshot //announced in the last FastForwardResponse
+	fetchingSnapshot bool
+	snapshotLock     sync.Mutex
 
 	shutdownCh chan struct{}
 
@@ -53,16 +72,31 @@ func NewNode(conf *Config,
 	participants []net.Peer,
 	store hg.Store,
 	trans net.Transport,
-	proxy proxy.AppProxy) *Node {
+	appProxy proxy.AppProxy) *Node {
 
 	localAddr := trans.LocalAddr()
 
 	pmap, _ := store.Participants()
 
+	//The network is identified by the participants of the Store rather than
+	//by the peers, which may be missing the local node
+	if t, ok := trans.(net.WithNetworkID); ok {
+		t.SetNetworkID(net.NetworkID(pmap))
+	}
+
 	commitCh := make(chan hg.Block, 400)
 	core := NewCore(id, key, pmap, store, commitCh, conf.Logger)
+	core.Mempool().SetLimits(conf.Mempool)
+	if p, ok := appProxy.(proxy.WithMempool); ok {
+		p.SetMempool(core.Mempool())
+	}
+
+	peerSelector := NewPeerSelector(conf.PeerSelector, participants, core.HexID(), conf)
 
-	peerSelector := NewRandomPeerSelector(participants, localAddr)
+	selfRecord, err := net.NewPeerRecord(key, localAddr)
+	if err != nil {
+		conf.Logger.WithField("error", err).Error("Signing PeerRecord")
+	}
 
 	node := Node{
 		id:           id,
@@ -71,15 +105,25 @@ func NewNode(conf *Config,
 		localAddr:    localAddr,
 		logger:       conf.Logger.WithField("this_id", id),
 		peerSelector: peerSelector,
+		gossiping:    make(map[string]bool),
+		peerBook:     newPeerBook(selfRecord, participants),
+		requests:     newRequestFilter(maxRequestAge),
 		trans:        trans,
 		netCh:        trans.Consumer(),
-		proxy:        proxy,
-		submitCh:     proxy.SubmitCh(),
+		proxy:        appProxy,
+		submitCh:     appProxy.SubmitCh(),
 		commitCh:     commitCh,
+		blocks:       newBlockQueue(),
+		handshakeCh:  make(chan appHandshake),
+		restoreCh:    make(chan appRestore),
 		shutdownCh:   make(chan struct{}),
 		controlTimer: NewRandomControlTimer(conf.HeartbeatTimeout),
 	}
 
+	if p, ok := appProxy.(proxy.WithHandshake); ok {
+		p.SetHandshakeHandler(node.handleAppHandshake)
+	}
+
 	//Initialize as Babbling
 	node.setStarting(true)
 	node.setState(Babbling)
@@ -89,7 +133,7 @@ func NewNode(conf *Config,
 
 func (n *Node) Init(bootstrap bool) error {
 	peerAddresses := []string{}
-	for _, p := range n.peerSelector.Peers() {
+	for _, p := range n.getPeers() {
 		peerAddresses = append(peerAddresses, p.NetAddr)
 	}
 	n.logger.WithField("peers", peerAddresses).Debug("Init Node")
@@ -101,6 +145,32 @@ func (n *Node) Init(bootstrap bool) error {
 	return n.core.Init()
 }
 
+//peerID returns the participant ID of the peer at addr, the receiver of the
+//requests signed for it, or -1 if it is unknown
+func (n *Node) peerID(addr string) int {
+	for _, p := range n.getPeers() {
+		if p.NetAddr == addr {
+			if id, ok := n.core.hg.Participants[p.PubKeyHex]; ok {
+				return id
+			}
+		}
+	}
+	return -1
+}
+
+//getPeers returns the peers of the selector, which learnPeers may replace
+func (n *Node) getPeers() []net.Peer {
+	n.selectorLock.Lock()
+	defer n.selectorLock.Unlock()
+	return n.peerSelector.Peers()
+}
+
+//SetPeerStore sets the PeerStore where the addresses learned from other nodes
+//are persisted. It must be called before Run.
+func (n *Node) SetPeerStore(store net.PeerStore) {
+	n.peerStore = store
+}
+
 func (n *Node) RunAsync(gossip bool) {
 	n.logger.Debug("runasync")
 	go n.Run(gossip)
@@ -116,6 +186,10 @@ func (n *Node) Run(gossip bool) {
 	//Process RPC requests as well as SumbitTx and CommitBlock requests
 	n.goFunc(n.doBackgroundWork)
 
+	//Deliver committed Blocks to the App, separately so that a slow App does
+	//not hold up the processing of RPCs
+	n.goFunc(n.deliverBlocks)
+
 	//Execute Node State Machine
 	for {
 		// Run different routines depending on node state
@@ -134,7 +208,15 @@ func (n *Node) Run(gossip bool) {
 }
 
 func (n *Node) doBackgroundWork() {
+	mempool := n.core.Mempool()
 	for {
+		//Stop reading submitCh while the mempool is full, so that
+		//submitters block instead of having their transactions dropped
+		submitCh := n.submitCh
+		if mempool.Full() {
+			submitCh = nil
+		}
+
 		select {
 		case rpc := <-n.netCh:
 			n.logger.Debug("Processing RPC")
@@ -142,21 +224,23 @@ func (n *Node) doBackgroundWork() {
 			if n.core.NeedGossip() && !n.controlTimer.set {
 				n.controlTimer.resetCh <- struct{}{}
 			}
-		case t := <-n.submitCh:
+		case t := <-submitCh:
 			n.logger.Debug("Adding Transaction")
 			n.addTransaction(t)
 			if !n.controlTimer.set {
 				n.controlTimer.resetCh <- struct{}{}
 			}
+		case <-mempool.AddedCh():
+			if !n.controlTimer.set {
+				n.controlTimer.resetCh <- struct{}{}
+			}
 		case block := <-n.commitCh:
 			n.logger.WithFields(logrus.Fields{
 				"index":          block.Index(),
 				"round_received": block.RoundReceived(),
 				"txs":            len(block.Transactions()),
-			}).Debug("Committing Block")
-			if err := n.commit(block); err != nil {
-				n.logger.WithField("error", err).Error("Committing Block")
-			}
+			}).Debug("Queueing Block")
+			n.blocks.push(block)
 		case <-n.shutdownCh:
 			return
 		}
@@ -171,9 +255,13 @@ func (n *Node) babble(gossip bool) {
 			if gossip {
 				proceed, err := n.preGossip()
 				if proceed && err == nil {
-					n.logger.Debug("Time to gossip!")
-					peer := n.peerSelector.Next()
-					n.goFunc(func() { n.gossip(peer.NetAddr) })
+					if peerAddr, ok := n.startGossip(); ok {
+						n.logger.Debug("Time to gossip!")
+						n.goFunc(func() {
+							defer n.endGossip(peerAddr)
+							n.gossip(peerAddr)
+						})
+					}
 				}
 			}
 			if !n.core.NeedGossip() {
@@ -196,12 +284,17 @@ func (n *Node) processRPC(rpc net.RPC) {
 
 	if s := n.getState(); s != Babbling {
 		n.logger.WithField("state", s.String()).Debug("Discarding RPC Request")
-		//XXX Use a SyncResponse by default but this should be either a special
-		//ErrorResponse type or a type that corresponds to the request
-		resp := &net.SyncResponse{
-			FromID: n.id,
+		rpc.Respond(nil, net.NewRPCErr(net.NotBabbling, s.String()))
+		return
+	}
+
+	if err := n.authenticate(rpc.Command); err != nil {
+		n.logger.WithField("error", err).Error("Rejecting RPC Request")
+		errType := net.Unauthenticated
+		if net.IsAuthErr(err, net.UnknownSender) {
+			errType = net.UnknownParticipant
 		}
-		rpc.Respond(resp, fmt.Errorf("not ready: %s", s.String()))
+		rpc.Respond(nil, net.NewRPCErr(errType, err.Error()))
 		return
 	}
 
@@ -210,20 +303,79 @@ func (n *Node) processRPC(rpc net.RPC) {
 		n.processSyncRequest(rpc, cmd)
 	case *net.EagerSyncRequest:
 		n.processEagerSyncRequest(rpc, cmd)
+	case *net.StreamSyncRequest:
+		n.processStreamSyncRequest(rpc, cmd)
+	case *net.FastForwardRequest:
+		n.processFastForwardRequest(rpc, cmd)
+	case *net.SnapshotRequest:
+		n.processSnapshotRequest(rpc, cmd)
 	default:
 		n.logger.WithField("cmd", rpc.Command).Error("Unexpected RPC command")
 		rpc.Respond(nil, fmt.Errorf("unexpected command"))
 	}
 }
 
+//authenticate checks that a request is signed by the participant it claims to
+//come from, for this node, recently, and that it was not received before
+func (n *Node) authenticate(cmd interface{}) error {
+	req, ok := cmd.(net.SignedRequest)
+	if !ok {
+		return nil
+	}
+
+	pubHex, ok := n.core.hg.ReverseParticipants[req.Sender()]
+	if !ok || len(pubHex) < 2 {
+		return net.NewAuthErr(net.UnknownSender, req.Sender())
+	}
+	pubBytes, err := hex.DecodeString(pubHex[2:])
+	if err != nil {
+		return err
+	}
+
+	valid, err := req.Verify(pubBytes)
+	if err != nil || !valid {
+		return net.NewAuthErr(net.InvalidSignature, req.Sender())
+	}
+
+	if req.Receiver() != n.id {
+		return net.NewAuthErr(net.WrongReceiver, req.Sender())
+	}
+	hash, err := req.Hash()
+	if err != nil {
+		return err
+	}
+	if !n.requests.accept(hash, req.SignedAt()) {
+		return net.NewAuthErr(net.StaleRequest, req.Sender())
+	}
+	return nil
+}
+
+//toRPCErr gives a type to an error returned to a remote node. Store errors
+//keep their meaning, other errors get the default type.
+func toRPCErr(err error, defaultType net.RPCErrType) error {
+	switch {
+	case err == nil:
+		return nil
+	case cm.Is(err, cm.TooLate):
+		return net.NewRPCErr(net.TooLate, err.Error())
+	case cm.Is(err, cm.UnknownParticipant):
+		return net.NewRPCErr(net.UnknownParticipant, err.Error())
+	default:
+		return net.NewRPCErr(defaultType, err.Error())
+	}
+}
+
 func (n *Node) processSyncRequest(rpc net.RPC, cmd *net.SyncRequest) {
 	n.logger.WithFields(logrus.Fields{
 		"from_id": cmd.FromID,
 		"known":   cmd.Known,
 	}).Debug("process SyncRequest")
 
+	n.learnPeers(cmd.Peers)
+
 	resp := &net.SyncResponse{
 		FromID: n.id,
+		Peers:  n.peerBook.getRecords(),
 	}
 	var respErr error
 
@@ -245,14 +397,14 @@ func (n *Node) processSyncRequest(rpc net.RPC, cmd *net.SyncRequest) {
 		n.logger.WithField("duration", elapsed.Nanoseconds()).Debug("Diff()")
 		if err != nil {
 			n.logger.WithField("error", err).Error("Calculating Diff")
-			respErr = err
+			respErr = toRPCErr(err, net.Internal)
 		}
 
 		//Convert to WireEvents
 		wireEvents, err := n.core.ToWire(eventDiff)
 		if err != nil {
 			n.logger.WithField("error", err).Debug("Converting to WireEvent")
-			respErr = err
+			respErr = toRPCErr(err, net.Internal)
 		} else {
 			resp.Events = wireEvents
 		}
@@ -293,7 +445,71 @@ func (n *Node) processEagerSyncRequest(rpc net.RPC, cmd *net.EagerSyncRequest) {
 		FromID:  n.id,
 		Success: success,
 	}
-	rpc.Respond(resp, err)
+	rpc.Respond(resp, toRPCErr(err, net.InvalidEvent))
+}
+
+func (n *Node) processStreamSyncRequest(rpc net.RPC, cmd *net.StreamSyncRequest) {
+	n.logger.WithFields(logrus.Fields{
+		"from_id":    cmd.FromID,
+		"known":      cmd.Known,
+		"chunk_size": cmd.ChunkSize,
+	}).Debug("process StreamSyncRequest")
+
+	//Compute Diff and Self Known
+	n.coreLock.Lock()
+	eventDiff, err := n.core.EventDiff(cmd.Known)
+	knownEvents := n.core.KnownEvents()
+	n.coreLock.Unlock()
+	if err != nil {
+		n.logger.WithField("error", err).Error("Calculating Diff")
+		rpc.Respond(nil, toRPCErr(err, net.Internal))
+		return
+	}
+
+	chunkSize := cmd.ChunkSize
+	if chunkSize <= 0 || chunkSize > n.conf.SyncLimit {
+		chunkSize = n.conf.SyncLimit
+	}
+
+	chunks := make(chan net.StreamSyncChunk)
+	rpc.Respond(&net.StreamSyncResponse{
+		FromID: n.id,
+		Known:  knownEvents,
+		Chunks: chunks,
+	}, nil)
+
+	//Only one chunk of WireEvents is held at a time; the transport asks for
+	//the next one when the requester has room for it
+	n.goFunc(func() {
+		start := 0
+		for {
+			end := start + chunkSize
+			if end > len(eventDiff) {
+				end = len(eventDiff)
+			}
+
+			chunk := net.StreamSyncChunk{Done: end == len(eventDiff)}
+			wireEvents, err := n.core.ToWire(eventDiff[start:end])
+			if err != nil {
+				n.logger.WithField("error", err).Debug("Converting to WireEvent")
+				chunk = net.NewStreamSyncErr(toRPCErr(err, net.Internal))
+			} else {
+				chunk.Events = wireEvents
+			}
+
+			select {
+			case chunks <- chunk:
+			case <-cmd.Canceled():
+				return
+			case <-n.shutdownCh:
+				return
+			}
+			if chunk.Done {
+				return
+			}
+			start = end
+		}
+	})
 }
 
 func (n *Node) preGossip() (bool, error) {
@@ -317,23 +533,53 @@ func (n *Node) preGossip() (bool, error) {
 	return true, nil
 }
 
+//startGossip selects the next peer to gossip with, unless a gossip with that
+//peer is still in progress. Gossips pile up otherwise when peers are slow to
+//answer, and hold the coreLock against each other and against incoming RPCs.
+func (n *Node) startGossip() (string, bool) {
+	n.selectorLock.Lock()
+	defer n.selectorLock.Unlock()
+	peerAddr := n.peerSelector.Next().NetAddr
+	if n.gossiping[peerAddr] {
+		return "", false
+	}
+	n.gossiping[peerAddr] = true
+	return peerAddr, true
+}
+
+func (n *Node) endGossip(peerAddr string) {
+	n.selectorLock.Lock()
+	defer n.selectorLock.Unlock()
+	delete(n.gossiping, peerAddr)
+}
+
 func (n *Node) gossip(peerAddr string) error {
 	//pull
 	syncLimit, otherKnownEvents, err := n.pull(peerAddr)
 	if err != nil {
+		n.handleGossipErr(peerAddr, err)
 		return err
 	}
 
-	//check and handle syncLimit
+	//check and handle syncLimit: pull everything in chunks if the peer
+	//supports it, or catch up otherwise
 	if syncLimit {
 		n.logger.WithField("from", peerAddr).Debug("SyncLimit")
-		n.setState(CatchingUp)
-		return nil
+		otherKnownEvents, err = n.streamSync(peerAddr)
+		if err == net.ErrStreamSyncUnsupported {
+			n.setState(CatchingUp)
+			return nil
+		}
+		if err != nil {
+			n.handleGossipErr(peerAddr, err)
+			return err
+		}
 	}
 
 	//push
 	err = n.push(peerAddr, otherKnownEvents)
 	if err != nil {
+		n.handleGossipErr(peerAddr, err)
 		return err
 	}
 
@@ -356,6 +602,9 @@ func (n *Node) pull(peerAddr string) (syncLimit bool, otherKnownEvents map[int]i
 	n.coreLock.Unlock()
 
 	//Send SyncRequest
+	n.coreLock.Lock()
+	n.syncRequests++
+	n.coreLock.Unlock()
 	start := time.Now()
 	resp, err := n.requestSync(peerAddr, knownEvents)
 	elapsed := time.Since(start)
@@ -364,6 +613,8 @@ func (n *Node) pull(peerAddr string) (syncLimit bool, otherKnownEvents map[int]i
 		n.logger.WithField("error", err).Error("requestSync()")
 		return false, nil, err
 	}
+	n.peerSuccess(peerAddr, elapsed, knownEvents, resp.Known)
+	n.learnPeers(resp.Peers)
 	n.logger.WithFields(logrus.Fields{
 		"from_id":    resp.FromID,
 		"sync_limit": resp.SyncLimit,
@@ -387,6 +638,139 @@ func (n *Node) pull(peerAddr string) (syncLimit bool, otherKnownEvents map[int]i
 	return false, resp.Known, nil
 }
 
+//streamSync pulls the Events we do not know from peerAddr in chunks, inserting
+//every chunk as it arrives. The new head is only created with the last chunk.
+func (n *Node) streamSync(peerAddr string) (otherKnownEvents map[int]int, err error) {
+	trans, ok := n.trans.(net.WithStreamSync)
+	if !ok {
+		return nil, net.ErrStreamSyncUnsupported
+	}
+
+	n.coreLock.Lock()
+	knownEvents := n.core.KnownEvents()
+	n.coreLock.Unlock()
+
+	args := net.StreamSyncRequest{
+		FromID:    n.id,
+		ToID:      n.peerID(peerAddr),
+		Known:     knownEvents,
+		ChunkSize: n.conf.SyncLimit,
+	}
+	if err := args.Sign(n.core.key); err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	events := 0
+	var resp net.StreamSyncResponse
+	err = trans.StreamSync(peerAddr, &args, &resp, func(chunk *net.StreamSyncChunk) error {
+		events += len(chunk.Events)
+
+		n.coreLock.Lock()
+		defer n.coreLock.Unlock()
+		if chunk.Done {
+			return n.sync(chunk.Events)
+		}
+		if _, err := n.core.InsertWireEvents(chunk.Events); err != nil {
+			return err
+		}
+		return n.core.RunConsensus()
+	})
+	n.logger.WithFields(logrus.Fields{
+		"from":     peerAddr,
+		"events":   events,
+		"duration": time.Since(start).Nanoseconds(),
+	}).Debug("streamSync()")
+	if err != nil {
+		if err != net.ErrStreamSyncUnsupported {
+			n.logger.WithField("error", err).Error("streamSync()")
+		}
+		return nil, err
+	}
+
+	return resp.Known, nil
+}
+
+//handleGossipErr reacts to an error returned by a peer. If the peer no longer
+//has the Events we need, we are too far behind and need to catch up. In any
+//case, the PeerSelector is told to back off from the peer.
+func (n *Node) handleGossipErr(peerAddr string, err error) {
+	n.coreLock.Lock()
+	n.syncErrors++
+	n.coreLock.Unlock()
+
+	if net.IsRPCErr(err, net.TooLate) {
+		n.logger.WithField("from", peerAddr).Debug("TooLate")
+		n.setState(CatchingUp)
+	}
+
+	n.peerFailure(peerAddr)
+}
+
+//learnPeers adds PeerRecords received from another node to the peerBook. If
+//the address of a participant changed, the PeerSelector and the PeerStore are
+//updated.
+func (n *Node) learnPeers(records []net.PeerRecord) {
+	changed := false
+	for _, r := range records {
+		ok, err := n.peerBook.add(r)
+		if err != nil {
+			n.logger.WithField("error", err).Debug("Rejecting PeerRecord")
+			continue
+		}
+		if ok {
+			n.logger.WithFields(logrus.Fields{
+				"pub_key":  r.PubKeyHex,
+				"net_addr": r.NetAddr,
+			}).Info("New peer address")
+			changed = true
+		}
+	}
+	if !changed {
+		return
+	}
+
+	peers := n.peerBook.getPeers()
+	_, otherPeers := net.ExcludePubKey(peers, n.core.HexID())
+
+	n.selectorLock.Lock()
+	n.peerSelector.SetPeers(otherPeers)
+	n.selectorLock.Unlock()
+
+	if n.peerStore != nil {
+		if err := n.peerStore.SetPeers(peers); err != nil {
+			n.logger.WithField("error", err).Error("Saving peers")
+		}
+	}
+}
+
+//peerSuccess informs the PeerSelector, if it cares, that peerAddr answered a
+//SyncRequest after rtt
+func (n *Node) peerSuccess(peerAddr string, rtt time.Duration, known, otherKnown map[int]int) {
+	lag := 0
+	for id, index := range known {
+		if d := index - otherKnown[id]; d > 0 {
+			lag += d
+		}
+	}
+
+	n.selectorLock.Lock()
+	defer n.selectorLock.Unlock()
+	if obs, ok := n.peerSelector.(PeerObserver); ok {
+		obs.Success(peerAddr, rtt, lag)
+	}
+}
+
+//peerFailure informs the PeerSelector, if it cares, that gossip with peerAddr
+//failed
+func (n *Node) peerFailure(peerAddr string) {
+	n.selectorLock.Lock()
+	defer n.selectorLock.Unlock()
+	if obs, ok := n.peerSelector.(PeerObserver); ok {
+		obs.Failure(peerAddr)
+	}
+}
+
 func (n *Node) push(peerAddr string, knownEvents map[int]int) error {
 
 	//Check SyncLimit
@@ -434,22 +818,16 @@ func (n *Node) push(peerAddr string, knownEvents map[int]int) error {
 	return nil
 }
 
-func (n *Node) fastForward() error {
-	n.logger.Debug("IN CATCHING-UP STATE")
-	n.logger.Debug("fast-sync not implemented yet")
-
-	//XXX Work in Progress on fsync branch
-
-	n.setState(Babbling)
-
-	return nil
-}
-
 func (n *Node) requestSync(target string, known map[int]int) (net.SyncResponse, error) {
 
 	args := net.SyncRequest{
 		FromID: n.id,
+		ToID:   n.peerID(target),
 		Known:  known,
+		Peers:  n.peerBook.getRecords(),
+	}
+	if err := args.Sign(n.core.key); err != nil {
+		return net.SyncResponse{}, err
 	}
 
 	var out net.SyncResponse
@@ -461,8 +839,12 @@ func (n *Node) requestSync(target string, known map[int]int) (net.SyncResponse,
 func (n *Node) requestEagerSync(target string, events []hg.WireEvent) (net.EagerSyncResponse, error) {
 	args := net.EagerSyncRequest{
 		FromID: n.id,
+		ToID:   n.peerID(target),
 		Events: events,
 	}
+	if err := args.Sign(n.core.key); err != nil {
+		return net.EagerSyncResponse{}, err
+	}
 
 	var out net.EagerSyncResponse
 	err := n.trans.EagerSync(target, &args, &out)
@@ -492,7 +874,20 @@ func (n *Node) sync(events []hg.WireEvent) error {
 	return nil
 }
 
+//commit commits a Block to the App, then signs it with the resulting state
+//hash and moves the delivery cursor past it. Blocks that the App already
+//acknowledged, and are produced again when the node bootstraps, are skipped.
 func (n *Node) commit(block hg.Block) error {
+	n.appLock.Lock()
+	defer n.appLock.Unlock()
+
+	n.coreLock.Lock()
+	delivered := n.core.GetLastDeliveredBlockIndex()
+	n.coreLock.Unlock()
+	if block.Index() <= delivered {
+		n.logger.WithField("block", block.Index()).Debug("Block already delivered")
+		return nil
+	}
 
 	stateHash, err := n.proxy.CommitBlock(block)
 	n.logger.WithFields(logrus.Fields{
@@ -500,24 +895,42 @@ func (n *Node) commit(block hg.Block) error {
 		"state_hash": fmt.Sprintf("0x%X", stateHash),
 		"err":        err,
 	}).Debug("CommitBlock Response")
+	if err != nil {
+		return err
+	}
 
 	block.Body.StateHash = stateHash
 
 	n.coreLock.Lock()
 	defer n.coreLock.Unlock()
+
+	//The App has the Block at this point; failing to record it would only
+	//deliver it again after a restart, so the error is not returned
+	if err := n.core.SetLastDeliveredBlockIndex(block.Index()); err != nil {
+		n.logger.WithField("error", err).Error("Saving delivery cursor")
+	}
+
 	sig, err := n.core.SignBlock(block)
 	if err != nil {
-		return err
+		n.logger.WithField("error", err).Error("Signing Block")
+		return nil
 	}
 	n.core.AddBlockSignature(sig)
 
-	return err
+	return nil
 }
 
 func (n *Node) addTransaction(tx []byte) {
-	n.coreLock.Lock()
-	defer n.coreLock.Unlock()
-	n.core.AddTransactions([][]byte{tx})
+	if err := n.core.Mempool().Add(tx, ""); err != nil {
+		n.logger.WithField("error", err).Error("Adding Transaction")
+	}
+}
+
+// SubmitTxs adds a batch of transactions to the pool of the node, atomically,
+// and returns the error of each transaction, nil if it was added. submitter
+// identifies the origin of the batch for the per-submitter limit of the pool.
+func (n *Node) SubmitTxs(txs [][]byte, submitter string) []error {
+	return n.core.Mempool().AddBatch(txs, submitter)
 }
 
 func (n *Node) Shutdown() {
@@ -561,14 +974,23 @@ func (n *Node) GetStats() map[string]string {
 		consensusRoundsPerSecond = float64(*lastConsensusRound) / timeElapsed.Seconds()
 	}
 
+	mempoolStats := n.core.Mempool().Stats()
+
 	s := map[string]string{
 		"last_consensus_round":   toString(lastConsensusRound),
 		"last_block_index":       strconv.Itoa(n.core.GetLastBlockIndex()),
 		"consensus_events":       strconv.Itoa(consensusEvents),
 		"consensus_transactions": strconv.Itoa(n.core.GetConsensusTransactionsCount()),
 		"undetermined_events":    strconv.Itoa(len(n.core.GetUndeterminedEvents())),
-		"transaction_pool":       strconv.Itoa(len(n.core.transactionPool)),
-		"num_peers":              strconv.Itoa(len(n.peerSelector.Peers())),
+		"transaction_pool":       strconv.Itoa(mempoolStats.Txs),
+		"last_delivered_block":   strconv.Itoa(n.core.GetLastDeliveredBlockIndex()),
+		"undelivered_blocks":     strconv.Itoa(n.blocks.len()),
+		"commit_retries":         strconv.Itoa(n.blocks.getRetries()),
+		"mempool_bytes":          strconv.Itoa(mempoolStats.Bytes),
+		"mempool_duplicates":     strconv.FormatUint(mempoolStats.Duplicates, 10),
+		"mempool_rejected_full":  strconv.FormatUint(mempoolStats.RejectedFull, 10),
+		"mempool_rejected_quota": strconv.FormatUint(mempoolStats.RejectedSubmitter, 10),
+		"num_peers":              strconv.Itoa(len(n.getPeers())),
 		"sync_rate":              strconv.FormatFloat(n.SyncRate(), 'f', 2, 64),
 		"events_per_second":      strconv.FormatFloat(consensusEventsPerSecond, 'f', 2, 64),
 		"rounds_per_second":      strconv.FormatFloat(consensusRoundsPerSecond, 'f', 2, 64),
@@ -576,6 +998,19 @@ func (n *Node) GetStats() map[string]string {
 		"id":                     strconv.Itoa(n.id),
 		"state":                  n.getState().String(),
 	}
+
+	if trans, ok := n.trans.(net.WithStats); ok {
+		transStats := trans.Stats()
+		s["rejected_conns"] = strconv.FormatUint(transStats.RejectedConns, 10)
+		s["oversized_requests"] = strconv.FormatUint(transStats.OversizedRequests, 10)
+		s["rate_limited_requests"] = strconv.FormatUint(transStats.RateLimited, 10)
+		s["read_timeouts"] = strconv.FormatUint(transStats.ReadTimeouts, 10)
+		s["pooled_conns"] = strconv.Itoa(transStats.PooledConns)
+		s["idle_evictions"] = strconv.FormatUint(transStats.IdleEvictions, 10)
+		s["dead_evictions"] = strconv.FormatUint(transStats.DeadEvictions, 10)
+		s["rpc_retries"] = strconv.FormatUint(transStats.Retries, 10)
+		s["incompatible_peers"] = strconv.FormatUint(transStats.IncompatiblePeers, 10)
+	}
 	return s
 }
 
@@ -609,3 +1044,26 @@ func (n *Node) SyncRate() float64 {
 func (n *Node) GetBlock(blockIndex int) (hg.Block, error) {
 	return n.core.hg.Store.GetBlock(blockIndex)
 }
+
+func (n *Node) GetBlocks(from, to int) ([]hg.Block, error) {
+	return n.core.hg.Store.GetBlocks(from, to)
+}
+
+func (n *Node) GetConsensusEvents(offset, limit int) ([]hg.Event, error) {
+	hashes, err := n.core.hg.Store.ConsensusEventsFrom(offset, limit)
+	if err != nil {
+		return nil, err
+	}
+	events := make([]hg.Event, len(hashes))
+	for i, h := range hashes {
+		events[i], err = n.core.hg.Store.GetEvent(h)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return events, nil
+}
+
+func (n *Node) GetLastBlockIndex() int {
+	return n.core.GetLastBlockIndex()
+}
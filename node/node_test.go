@@ -1,6 +1,7 @@
 package node
 
 import (
+	"bytes"
 	"crypto/ecdsa"
 	"fmt"
 	"math/rand"
@@ -40,6 +41,12 @@ func initPeers(n int) ([]*ecdsa.PrivateKey, []net.Peer, map[string]int) {
 		pmap[p.PubKeyHex] = i
 	}
 
+	//keys[i] must be the key of peers[i] for nodes to sign their requests
+	sort.Slice(keys, func(i, j int) bool {
+		return pmap[fmt.Sprintf("0x%X", crypto.FromECDSAPub(&keys[i].PublicKey))] <
+			pmap[fmt.Sprintf("0x%X", crypto.FromECDSAPub(&keys[j].PublicKey))]
+	})
+
 	return keys, peers, pmap
 }
 
@@ -95,8 +102,12 @@ func TestProcessSync(t *testing.T) {
 
 	args := net.SyncRequest{
 		FromID: node0.id,
+		ToID:   node1.id,
 		Known:  node0KnownEvents,
 	}
+	if err := args.Sign(keys[0]); err != nil {
+		t.Fatal(err)
+	}
 	expectedResp := net.SyncResponse{
 		FromID: node1.id,
 		Events: unknownWireEvents,
@@ -188,8 +199,12 @@ func TestProcessEagerSync(t *testing.T) {
 
 	args := net.EagerSyncRequest{
 		FromID: node0.id,
+		ToID:   node1.id,
 		Events: unknownWireEvents,
 	}
+	if err := args.Sign(keys[0]); err != nil {
+		t.Fatal(err)
+	}
 	expectedResp := net.EagerSyncResponse{
 		FromID:  node1.id,
 		Success: true,
@@ -211,6 +226,193 @@ func TestProcessEagerSync(t *testing.T) {
 	node1.Shutdown()
 }
 
+func TestAuthenticateRequests(t *testing.T) {
+	keys, peers, pmap := initPeers(2)
+	testLogger := common.NewTestLogger(t)
+	config := TestConfig(t)
+
+	peer0Trans, err := net.NewTCPTransport(peers[0].NetAddr, nil, 2, time.Second, testLogger)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer peer0Trans.Close()
+	peer0Trans.SetNetworkID(net.NetworkID(pmap))
+
+	peer1Trans, err := net.NewTCPTransport(peers[1].NetAddr, nil, 2, time.Second, testLogger)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer peer1Trans.Close()
+
+	node1 := NewNode(config, pmap[peers[1].PubKeyHex], keys[1], peers,
+		hg.NewInmemStore(pmap, config.CacheSize),
+		peer1Trans,
+		aproxy.NewInmemAppProxy(testLogger))
+	node1.Init(false)
+
+	node1.RunAsync(false)
+	defer node1.Shutdown()
+
+	otherKey, _ := crypto.GenerateECDSAKey()
+
+	cases := []struct {
+		name    string
+		fromID  int
+		toID    int
+		key     *ecdsa.PrivateKey
+		errType net.AuthErrType
+		rpcErr  net.RPCErrType
+	}{
+		{"unsigned", 0, 1, nil, net.InvalidSignature, net.Unauthenticated},
+		{"wrong key", 0, 1, otherKey, net.InvalidSignature, net.Unauthenticated},
+		{"impersonation", 0, 1, keys[1], net.InvalidSignature, net.Unauthenticated},
+		{"unknown sender", 5, 1, otherKey, net.UnknownSender, net.UnknownParticipant},
+		{"wrong receiver", 0, 0, keys[0], net.WrongReceiver, net.Unauthenticated},
+	}
+
+	for _, c := range cases {
+		args := net.SyncRequest{
+			FromID: c.fromID,
+			ToID:   c.toID,
+			Known:  map[int]int{0: -1, 1: -1},
+		}
+		if c.key != nil {
+			if err := args.Sign(c.key); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if err := node1.authenticate(&args); !net.IsAuthErr(err, c.errType) {
+			t.Fatalf("%s: expected AuthErr %d, got %v", c.name, c.errType, err)
+		}
+
+		var out net.SyncResponse
+		if err := peer0Trans.Sync(peers[1].NetAddr, &args, &out); !net.IsRPCErr(err, c.rpcErr) {
+			t.Fatalf("%s: expected RPCErr %d, got %v", c.name, c.rpcErr, err)
+		}
+	}
+
+	//A properly signed request goes through
+	args := net.SyncRequest{
+		FromID: 0,
+		ToID:   1,
+		Known:  map[int]int{0: -1, 1: -1},
+	}
+	if err := args.Sign(keys[0]); err != nil {
+		t.Fatal(err)
+	}
+	var out net.SyncResponse
+	if err := peer0Trans.Sync(peers[1].NetAddr, &args, &out); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	//but only once
+	if err := peer0Trans.Sync(peers[1].NetAddr, &args, &out); !net.IsRPCErr(err, net.Unauthenticated) {
+		t.Fatalf("replayed request: expected RPCErr %d, got %v", net.Unauthenticated, err)
+	}
+	if err := node1.authenticate(&args); !net.IsAuthErr(err, net.StaleRequest) {
+		t.Fatalf("replayed request: expected AuthErr %d, got %v", net.StaleRequest, err)
+	}
+}
+
+func TestProcessRPCNotBabbling(t *testing.T) {
+	keys, peers, pmap := initPeers(2)
+	testLogger := common.NewTestLogger(t)
+	config := TestConfig(t)
+
+	peer1Trans, err := net.NewTCPTransport(peers[1].NetAddr, nil, 2, time.Second, testLogger)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer peer1Trans.Close()
+
+	node1 := NewNode(config, pmap[peers[1].PubKeyHex], keys[1], peers,
+		hg.NewInmemStore(pmap, config.CacheSize),
+		peer1Trans,
+		aproxy.NewInmemAppProxy(testLogger))
+	node1.Init(false)
+	node1.setState(CatchingUp)
+
+	args := net.EagerSyncRequest{FromID: 0}
+	if err := args.Sign(keys[0]); err != nil {
+		t.Fatal(err)
+	}
+	respCh := make(chan net.RPCResponse, 1)
+	node1.processRPC(net.RPC{Command: &args, RespChan: respCh})
+
+	resp := <-respCh
+	if !net.IsRPCErr(resp.Error, net.NotBabbling) {
+		t.Fatalf("expected RPCErr NotBabbling, got %v", resp.Error)
+	}
+}
+
+func TestLearnPeers(t *testing.T) {
+	keys, peers, pmap := initPeers(3)
+	testLogger := common.NewTestLogger(t)
+	config := TestConfig(t)
+
+	peer0Trans, err := net.NewTCPTransport(peers[0].NetAddr, nil, 2, time.Second, testLogger)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer peer0Trans.Close()
+
+	peer1Trans, err := net.NewTCPTransport(peers[1].NetAddr, nil, 2, time.Second, testLogger)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer peer1Trans.Close()
+	peer1Trans.SetNetworkID(net.NetworkID(pmap))
+
+	peerStore := &net.StaticPeers{StaticPeers: peers}
+	node0 := NewNode(config, pmap[peers[0].PubKeyHex], keys[0], peers,
+		hg.NewInmemStore(pmap, config.CacheSize),
+		peer0Trans,
+		aproxy.NewInmemAppProxy(testLogger))
+	node0.SetPeerStore(peerStore)
+	node0.Init(false)
+
+	node0.RunAsync(false)
+	defer node0.Shutdown()
+
+	//node1 tells node0 that node2 moved
+	record, err := net.NewPeerRecord(keys[2], "127.0.0.1:7777")
+	if err != nil {
+		t.Fatal(err)
+	}
+	args := net.SyncRequest{
+		FromID: 1,
+		ToID:   0,
+		Known:  map[int]int{0: -1, 1: -1, 2: -1},
+		Peers:  []net.PeerRecord{record},
+	}
+	if err := args.Sign(keys[1]); err != nil {
+		t.Fatal(err)
+	}
+
+	var out net.SyncResponse
+	if err := peer1Trans.Sync(peers[0].NetAddr, &args, &out); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	//node0 answers with its own record and the one it learned
+	if len(out.Peers) != 2 {
+		t.Fatalf("SyncResponse should contain 2 PeerRecords, not %d", len(out.Peers))
+	}
+
+	node0.selectorLock.Lock()
+	selectorPeers := node0.peerSelector.Peers()
+	node0.selectorLock.Unlock()
+	if selectorPeers[1].NetAddr != "127.0.0.1:7777" {
+		t.Fatalf("PeerSelector should use the new address of node2, not %s", selectorPeers[1].NetAddr)
+	}
+
+	storedPeers, _ := peerStore.Peers()
+	if storedPeers[2].NetAddr != "127.0.0.1:7777" {
+		t.Fatalf("PeerStore should contain the new address of node2, not %s", storedPeers[2].NetAddr)
+	}
+}
+
 func TestAddTransaction(t *testing.T) {
 	keys, peers, pmap := initPeers(2)
 	testLogger := common.NewTestLogger(t)
@@ -258,8 +460,12 @@ func TestAddTransaction(t *testing.T) {
 	node0KnownEvents := node0.core.KnownEvents()
 	args := net.SyncRequest{
 		FromID: node0.id,
+		ToID:   node1.id,
 		Known:  node0KnownEvents,
 	}
+	if err := args.Sign(keys[0]); err != nil {
+		t.Fatal(err)
+	}
 
 	var out net.SyncResponse
 	if err := peer0Trans.Sync(peers[1].NetAddr, &args, &out); err != nil {
@@ -272,7 +478,7 @@ func TestAddTransaction(t *testing.T) {
 
 	//check the Tx was removed from the transactionPool and added to the new Head
 
-	if l := len(node0.core.transactionPool); l > 0 {
+	if l := node0.core.Mempool().Len(); l > 0 {
 		t.Fatalf("node0's transactionPool should have 0 elements, not %d\n", l)
 	}
 
@@ -351,7 +557,9 @@ func recycleNode(oldNode *Node, logger *logrus.Logger, t *testing.T) *Node {
 	if err != nil {
 		t.Fatal(err)
 	}
-	prox := aproxy.NewInmemAppProxy(logger)
+	//The App keeps its state across the restart of the node, which only
+	//delivers the Blocks that the App has not acknowledged
+	prox := oldNode.proxy
 
 	newNode := NewNode(conf, id, key, peers, store, trans, prox)
 
@@ -398,6 +606,7 @@ func TestGossip(t *testing.T) {
 	logger := common.NewTestLogger(t)
 
 	_, nodes := initNodes(4, 1000, 1000, "inmem", logger, t)
+	defer shutdownNodes(nodes)
 
 	err := gossip(nodes, 50, true, 3*time.Second)
 	if err != nil {
@@ -438,8 +647,12 @@ func TestSyncLimit(t *testing.T) {
 
 	args := net.SyncRequest{
 		FromID: nodes[0].id,
+		ToID:   nodes[1].id,
 		Known:  node0KnownEvents,
 	}
+	if err := args.Sign(nodes[0].core.key); err != nil {
+		t.Fatal(err)
+	}
 	expectedResp := net.SyncResponse{
 		FromID:    nodes[1].id,
 		SyncLimit: true,
@@ -459,6 +672,237 @@ func TestSyncLimit(t *testing.T) {
 	}
 }
 
+func TestStreamSync(t *testing.T) {
+	logger := common.NewTestLogger(t)
+	_, nodes := initNodes(4, 1000, 10, "inmem", logger, t)
+	defer shutdownNodes(nodes)
+
+	//Only the first three nodes gossip so the last one falls far behind
+	err := gossip(nodes[:3], 5, false, 6*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	nodes[0].coreLock.Lock()
+	known := nodes[0].core.KnownEvents()
+	nodes[0].coreLock.Unlock()
+
+	//The SyncRequest of the last node is over the SyncLimit so it pulls the
+	//Events in chunks instead
+	if err := nodes[3].gossip(nodes[0].localAddr); err != nil {
+		t.Fatal(err)
+	}
+
+	if s := nodes[3].getState(); s != Babbling {
+		t.Fatalf("node3 should be Babbling, not %s", s)
+	}
+	streamKnown := nodes[3].core.KnownEvents()
+	for id, index := range known {
+		if streamKnown[id] < index {
+			t.Fatalf("node3 should know Event %d of participant %d, not %d", index, id, streamKnown[id])
+		}
+	}
+}
+
+func TestFastForward(t *testing.T) {
+	logger := common.NewTestLogger(t)
+	_, nodes := initNodes(4, 1000, 1000, "inmem", logger, t)
+	defer shutdownNodes(nodes)
+
+	//Only the first three nodes gossip so the last one falls far behind
+	err := gossip(nodes[:3], 10, false, 6*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//The last node catches up from the state of a peer instead of its Events,
+	//while the others keep committing transactions
+	quit := make(chan struct{})
+	defer close(quit)
+	makeRandomTransactions(nodes[:3], quit)
+	nodes[3].setState(CatchingUp)
+	runNodes(nodes[3:], true)
+
+	waitDelivered := func(n *Node, index int) {
+		timeout := time.After(6 * time.Second)
+		for {
+			n.coreLock.Lock()
+			delivered := n.core.GetLastDeliveredBlockIndex()
+			n.coreLock.Unlock()
+			if n.getState() == Babbling && delivered >= index {
+				return
+			}
+			select {
+			case <-timeout:
+				t.Fatalf("timeout waiting for Block %d, last delivered: %d, state: %s",
+					index, delivered, n.getState())
+			case <-time.After(10 * time.Millisecond):
+			}
+		}
+	}
+	waitDelivered(nodes[3], 0)
+
+	//Blocks have a state hash once the App committed them
+	getBlock := func(n *Node, index int) hg.Block {
+		waitDelivered(n, index)
+		n.coreLock.Lock()
+		defer n.coreLock.Unlock()
+		block, err := n.core.hg.Store.GetBlock(index)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return block
+	}
+
+	nodes[3].coreLock.Lock()
+	restored := nodes[3].core.GetLastDeliveredBlockIndex()
+	nodes[3].coreLock.Unlock()
+	if restored <= 0 {
+		t.Fatalf("node3 should have fast-forwarded past Block 0, not to Block %d", restored)
+	}
+
+	txs3, err := getCommittedTransactions(nodes[3])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(txs3) == 0 {
+		t.Fatalf("node3 should have restored committed transactions")
+	}
+
+	//It resumes from a Block of the others and then decides the same Blocks
+	for i := restored; i <= restored+3; i++ {
+		block0, block3 := getBlock(nodes[0], i), getBlock(nodes[3], i)
+		hash0, _ := block0.Body.Hash()
+		hash3, _ := block3.Body.Hash()
+		if !bytes.Equal(hash0, hash3) || !bytes.Equal(block0.StateHash(), block3.StateHash()) {
+			t.Fatalf("node3 Block %d should be that of node0", i)
+		}
+	}
+}
+
+func TestCheckPeerBlockAndFrame(t *testing.T) {
+	logger := common.NewTestLogger(t)
+	_, nodes := initNodes(4, 1000, 1000, "inmem", logger, t)
+
+	defer shutdownNodes(nodes)
+	if err := gossip(nodes[:3], 10, false, 6*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	node := nodes[0]
+
+	//Like a FastForwardRequest, it fails while some undetermined Events are
+	//older than the Frame
+	var block hg.Block
+	var frame hg.Frame
+	var err error
+	timeout := time.After(6 * time.Second)
+	for {
+		node.coreLock.Lock()
+		block, frame, err = node.core.GetLastBlockWithFrame()
+		node.coreLock.Unlock()
+		if err == nil {
+			break
+		}
+		select {
+		case <-timeout:
+			t.Fatal(err)
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+
+	//A Block needs the signatures of more than a third of the participants
+	var signed hg.Block
+	for i := block.Index(); i >= 0; i-- {
+		if signed, err = node.core.hg.Store.GetBlock(i); err != nil {
+			t.Fatal(err)
+		}
+		if len(signed.Signatures) >= 2 {
+			break
+		}
+	}
+	if err := node.checkPeerBlock(signed); err != nil {
+		t.Fatalf("Block %d should be accepted: %v", signed.Index(), err)
+	}
+	for validator := range signed.Signatures {
+		signed.Signatures = map[string]string{validator: signed.Signatures[validator]}
+		break
+	}
+	if err := node.checkPeerBlock(signed); err == nil {
+		t.Fatalf("Block with a single signature should be refused")
+	}
+
+	if err := node.checkPeerFrame(block, frame); err != nil {
+		t.Fatalf("Frame should be accepted: %v", err)
+	}
+
+	withRoots := func(change func(map[string]hg.Root)) hg.Frame {
+		roots := make(map[string]hg.Root, len(frame.Roots))
+		for p, r := range frame.Roots {
+			roots[p] = r
+		}
+		change(roots)
+		return hg.Frame{Roots: roots, Events: frame.Events}
+	}
+
+	missing := withRoots(func(roots map[string]hg.Root) {
+		delete(roots, node.core.HexID())
+	})
+	if err := node.checkPeerFrame(block, missing); err == nil {
+		t.Fatalf("Frame without a Root for every participant should be refused")
+	}
+
+	old := withRoots(func(roots map[string]hg.Root) {
+		for p, r := range roots {
+			r.Round = -1
+			roots[p] = r
+		}
+	})
+	if err := node.checkPeerFrame(block, old); err == nil && block.RoundReceived() > 0 {
+		t.Fatalf("Frame of rounds before the Block should be refused")
+	}
+
+	if len(frame.Events) > 0 {
+		creator := frame.Events[0].Creator()
+		unchained := withRoots(func(roots map[string]hg.Root) {
+			r := roots[creator]
+			r.X = "bogus"
+			roots[creator] = r
+		})
+		if err := node.checkPeerFrame(block, unchained); err == nil {
+			t.Fatalf("Frame Events that do not follow their Root should be refused")
+		}
+	}
+}
+
+func TestSnapshotRequestAnnounced(t *testing.T) {
+	logger := common.NewTestLogger(t)
+	_, nodes := initNodes(1, 1000, 1000, "inmem", logger, t)
+	defer shutdownNodes(nodes)
+	node := nodes[0]
+
+	request := func(blockIndex int) net.RPCResponse {
+		respCh := make(chan net.RPCResponse, 1)
+		node.processSnapshotRequest(net.RPC{RespChan: respCh},
+			&net.SnapshotRequest{BlockIndex: blockIndex})
+		return <-respCh
+	}
+
+	//The App is not asked for snapshots that were not announced
+	if resp := request(-1); resp.Error == nil {
+		t.Fatalf("snapshot that was not announced should be refused")
+	}
+
+	if _, err := node.announceSnapshot(-1); err != nil {
+		t.Fatal(err)
+	}
+	if resp := request(-1); resp.Error != nil {
+		t.Fatalf("announced snapshot should be served: %v", resp.Error)
+	}
+	if resp := request(0); resp.Error == nil {
+		t.Fatalf("snapshot at another Block should be refused")
+	}
+}
+
 func TestShutdown(t *testing.T) {
 	logger := common.NewTestLogger(t)
 	_, nodes := initNodes(2, 1000, 1000, "inmem", logger, t)
@@ -0,0 +1,110 @@
+package node
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/champii/babble/net"
+)
+
+//maxPeerRecordSkew is how far in the future a PeerRecord can be dated
+const maxPeerRecordSkew = 10 * time.Minute
+
+//peerBook keeps the most recent PeerRecord of every participant. Records are
+//exchanged with SyncRequests and SyncResponses so that nodes learn the new
+//address of a participant without editing their peers.json.
+type peerBook struct {
+	self    string                    //PubKeyHex of this node
+	peers   []net.Peer                //participants with their latest address
+	records map[string]net.PeerRecord //by PubKeyHex
+	l       sync.Mutex
+}
+
+func newPeerBook(self net.PeerRecord, participants []net.Peer) *peerBook {
+	peers := make([]net.Peer, len(participants))
+	copy(peers, participants)
+
+	pb := &peerBook{
+		self:    self.PubKeyHex,
+		peers:   peers,
+		records: make(map[string]net.PeerRecord),
+	}
+	pb.records[self.PubKeyHex] = self
+	pb.setAddr(self.PubKeyHex, self.NetAddr)
+	return pb
+}
+
+//add checks a record and keeps it if it is more recent than the one we have.
+//It reports whether the address of a participant changed. Records about this
+//node are ignored; it is the only authority on its own address.
+func (pb *peerBook) add(r net.PeerRecord) (bool, error) {
+	pb.l.Lock()
+	defer pb.l.Unlock()
+
+	if r.PubKeyHex == pb.self {
+		return false, nil
+	}
+	if pb.indexOf(r.PubKeyHex) < 0 {
+		return false, fmt.Errorf("PeerRecord of unknown participant %s", r.PubKeyHex)
+	}
+	if old, ok := pb.records[r.PubKeyHex]; ok && old.Timestamp >= r.Timestamp {
+		return false, nil
+	}
+	if time.Unix(0, r.Timestamp).After(time.Now().Add(maxPeerRecordSkew)) {
+		return false, fmt.Errorf("PeerRecord of %s is dated in the future", r.PubKeyHex)
+	}
+	valid, err := r.Verify()
+	if err != nil {
+		return false, err
+	}
+	if !valid {
+		return false, fmt.Errorf("Invalid PeerRecord signature for %s", r.PubKeyHex)
+	}
+
+	pb.records[r.PubKeyHex] = r
+	return pb.setAddr(r.PubKeyHex, r.NetAddr), nil
+}
+
+//getRecords returns the PeerRecords to send to other nodes
+func (pb *peerBook) getRecords() []net.PeerRecord {
+	pb.l.Lock()
+	defer pb.l.Unlock()
+
+	records := make([]net.PeerRecord, 0, len(pb.records))
+	for _, p := range pb.peers {
+		if r, ok := pb.records[p.PubKeyHex]; ok {
+			records = append(records, r)
+		}
+	}
+	return records
+}
+
+//getPeers returns all the participants, including this node, with their latest
+//address
+func (pb *peerBook) getPeers() []net.Peer {
+	pb.l.Lock()
+	defer pb.l.Unlock()
+
+	peers := make([]net.Peer, len(pb.peers))
+	copy(peers, pb.peers)
+	return peers
+}
+
+func (pb *peerBook) indexOf(pubKeyHex string) int {
+	for i, p := range pb.peers {
+		if p.PubKeyHex == pubKeyHex {
+			return i
+		}
+	}
+	return -1
+}
+
+func (pb *peerBook) setAddr(pubKeyHex, netAddr string) bool {
+	i := pb.indexOf(pubKeyHex)
+	if i < 0 || pb.peers[i].NetAddr == netAddr {
+		return false
+	}
+	pb.peers[i].NetAddr = netAddr
+	return true
+}
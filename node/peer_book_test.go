@@ -0,0 +1,61 @@
+package node
+
+import (
+	"testing"
+	"time"
+
+	"github.com/champii/babble/crypto"
+	"github.com/champii/babble/net"
+)
+
+func TestPeerBook(t *testing.T) {
+	keys, peers, _ := initPeers(3)
+
+	self, err := net.NewPeerRecord(keys[0], "newaddr0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pb := newPeerBook(self, peers)
+
+	if addr := pb.getPeers()[0].NetAddr; addr != "newaddr0" {
+		t.Fatalf("Own address should be newaddr0, not %s", addr)
+	}
+
+	//A newer record changes the address
+	old, _ := net.NewPeerRecord(keys[1], "oldaddr1")
+	time.Sleep(time.Millisecond)
+	r, _ := net.NewPeerRecord(keys[1], "newaddr1")
+	if changed, err := pb.add(r); err != nil || !changed {
+		t.Fatalf("PeerRecord should change the address: %v", err)
+	}
+	if addr := pb.getPeers()[1].NetAddr; addr != "newaddr1" {
+		t.Fatalf("Address of peer 1 should be newaddr1, not %s", addr)
+	}
+
+	//An older record is ignored
+	if changed, err := pb.add(old); err != nil || changed {
+		t.Fatalf("Older PeerRecord should be ignored: %v", err)
+	}
+
+	//Records about ourselves are ignored
+	r, _ = net.NewPeerRecord(keys[0], "addr0")
+	if changed, _ := pb.add(r); changed {
+		t.Fatalf("PeerRecord about ourselves should be ignored")
+	}
+
+	//Records of unknown nodes or with invalid signatures are rejected
+	otherKey, _ := crypto.GenerateECDSAKey()
+	r, _ = net.NewPeerRecord(otherKey, "addr3")
+	if _, err := pb.add(r); err == nil {
+		t.Fatalf("PeerRecord of unknown participant should be rejected")
+	}
+	r, _ = net.NewPeerRecord(keys[2], "addr2")
+	r.NetAddr = "evil"
+	if _, err := pb.add(r); err == nil {
+		t.Fatalf("PeerRecord with invalid signature should be rejected")
+	}
+
+	if l := len(pb.getRecords()); l != 2 {
+		t.Fatalf("peerBook should have 2 records, not %d", l)
+	}
+}
@@ -1,18 +1,46 @@
 package node
 
 import (
-	"fmt"
+	"math"
 	"math/rand"
+	"time"
 
 	"github.com/champii/babble/net"
 )
 
+const (
+	RandomPeerSelectorType = "random"
+	HealthPeerSelectorType = "health"
+)
+
 type PeerSelector interface {
 	Peers() []net.Peer
+	SetPeers(peers []net.Peer)
 	UpdateLast(peer string)
 	Next() net.Peer
 }
 
+// PeerObserver is implemented by PeerSelectors that learn from the outcome of
+// gossip with each peer.
+type PeerObserver interface {
+	//Success records a SyncRequest answered after rtt by a peer that was lag
+	//Events behind us
+	Success(peer string, rtt time.Duration, lag int)
+	//Failure records a failed gossip with a peer
+	Failure(peer string)
+}
+
+//NewPeerSelector creates a PeerSelector of the given type. The node itself,
+//identified by its public key, is excluded from the participants.
+func NewPeerSelector(selectorType string, participants []net.Peer, selfPubKey string, conf *Config) PeerSelector {
+	switch selectorType {
+	case HealthPeerSelectorType:
+		return NewHealthPeerSelector(participants, selfPubKey, conf.HeartbeatTimeout)
+	default:
+		return NewRandomPeerSelector(participants, selfPubKey)
+	}
+}
+
 //+++++++++++++++++++++++++++++++++++++++
 //RANDOM
 
@@ -21,8 +49,8 @@ type RandomPeerSelector struct {
 	last  string
 }
 
-func NewRandomPeerSelector(participants []net.Peer, localAddr string) *RandomPeerSelector {
-	_, peers := net.ExcludePeer(participants, localAddr)
+func NewRandomPeerSelector(participants []net.Peer, selfPubKey string) *RandomPeerSelector {
+	_, peers := net.ExcludePubKey(participants, selfPubKey)
 	return &RandomPeerSelector{
 		peers: peers,
 	}
@@ -32,6 +60,10 @@ func (ps *RandomPeerSelector) Peers() []net.Peer {
 	return ps.peers
 }
 
+func (ps *RandomPeerSelector) SetPeers(peers []net.Peer) {
+	ps.peers = peers
+}
+
 func (ps *RandomPeerSelector) UpdateLast(peer string) {
 	ps.last = peer
 }
@@ -41,8 +73,159 @@ func (ps *RandomPeerSelector) Next() net.Peer {
 	if len(selectablePeers) > 1 {
 		_, selectablePeers = net.ExcludePeer(selectablePeers, ps.last)
 	}
-	fmt.Println("BABBLE SELECTABLE PEERS", selectablePeers)
 	i := rand.Intn(len(selectablePeers))
 	peer := selectablePeers[i]
 	return peer
 }
+
+//+++++++++++++++++++++++++++++++++++++++
+//HEALTH
+
+const (
+	//weight of the last measure in the moving averages
+	healthSmoothing = 0.2
+	//probability of picking a peer uniformly, regardless of its health
+	healthExploration = 0.1
+	//rtt added to every measure so that weights stay bounded
+	healthBaseRTT = 10 * time.Millisecond
+	//number of Events behind us that halves the weight of a peer
+	healthLagScale = 100
+	//max backoff, as a multiple of the base backoff
+	healthMaxBackoff = 64
+)
+
+//peerHealth is what a HealthPeerSelector knows about a peer
+type peerHealth struct {
+	rtt      time.Duration //moving average of round-trip times, 0 if unknown
+	errRate  float64       //moving average of failures (1) and successes (0)
+	lag      int           //number of Events the peer was behind us
+	failures int           //consecutive failures
+	retryAt  time.Time     //the peer is not selected before retryAt
+}
+
+func (h *peerHealth) weight() float64 {
+	rtt := (h.rtt + healthBaseRTT).Seconds()
+	health := math.Max(1-h.errRate, 0.05)
+	return health / rtt / (1 + float64(h.lag)/healthLagScale)
+}
+
+// HealthPeerSelector favours peers that answer quickly, do not fail and keep
+// up with the hashgraph. Peers are picked at random with a probability
+// proportional to their weight. Failing peers are left aside for a period
+// that doubles with every consecutive failure. Every peer still gets picked
+// from time to time so that measures stay current.
+type HealthPeerSelector struct {
+	peers   []net.Peer
+	last    string
+	health  map[string]*peerHealth
+	backoff time.Duration
+	rand    *rand.Rand
+}
+
+func NewHealthPeerSelector(participants []net.Peer, selfPubKey string, backoff time.Duration) *HealthPeerSelector {
+	_, peers := net.ExcludePubKey(participants, selfPubKey)
+	health := make(map[string]*peerHealth)
+	for _, p := range peers {
+		health[p.NetAddr] = &peerHealth{}
+	}
+	if backoff <= 0 {
+		backoff = healthBaseRTT
+	}
+	return &HealthPeerSelector{
+		peers:   peers,
+		health:  health,
+		backoff: backoff,
+		rand:    rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+func (ps *HealthPeerSelector) Peers() []net.Peer {
+	return ps.peers
+}
+
+//SetPeers replaces the list of peers. The health of a peer is kept as long as
+//its address does not change.
+func (ps *HealthPeerSelector) SetPeers(peers []net.Peer) {
+	ps.peers = peers
+}
+
+func (ps *HealthPeerSelector) UpdateLast(peer string) {
+	ps.last = peer
+}
+
+func (ps *HealthPeerSelector) Next() net.Peer {
+	now := time.Now()
+
+	//Peers that are not backing off, excluding the last one
+	candidates := []net.Peer{}
+	for _, p := range ps.peers {
+		if len(ps.peers) > 1 && p.NetAddr == ps.last {
+			continue
+		}
+		if now.Before(ps.getHealth(p.NetAddr).retryAt) {
+			continue
+		}
+		candidates = append(candidates, p)
+	}
+
+	//If everyone is backing off, try the peer that will be available first
+	if len(candidates) == 0 {
+		next := ps.peers[0]
+		for _, p := range ps.peers[1:] {
+			if ps.getHealth(p.NetAddr).retryAt.Before(ps.getHealth(next.NetAddr).retryAt) {
+				next = p
+			}
+		}
+		return next
+	}
+
+	if ps.rand.Float64() < healthExploration {
+		return candidates[ps.rand.Intn(len(candidates))]
+	}
+
+	total := 0.0
+	for _, p := range candidates {
+		total += ps.getHealth(p.NetAddr).weight()
+	}
+	r := ps.rand.Float64() * total
+	for _, p := range candidates {
+		r -= ps.getHealth(p.NetAddr).weight()
+		if r <= 0 {
+			return p
+		}
+	}
+	return candidates[len(candidates)-1]
+}
+
+// Success implements the PeerObserver interface.
+func (ps *HealthPeerSelector) Success(peer string, rtt time.Duration, lag int) {
+	h := ps.getHealth(peer)
+	if h.rtt == 0 {
+		h.rtt = rtt
+	} else {
+		h.rtt = time.Duration(healthSmoothing*float64(rtt) + (1-healthSmoothing)*float64(h.rtt))
+	}
+	h.errRate = (1 - healthSmoothing) * h.errRate
+	h.lag = lag
+	h.failures = 0
+	h.retryAt = time.Time{}
+}
+
+// Failure implements the PeerObserver interface.
+func (ps *HealthPeerSelector) Failure(peer string) {
+	h := ps.getHealth(peer)
+	h.errRate = healthSmoothing + (1-healthSmoothing)*h.errRate
+	h.failures++
+
+	factor := math.Min(math.Pow(2, float64(h.failures-1)), healthMaxBackoff)
+	h.retryAt = time.Now().Add(time.Duration(factor * float64(ps.backoff)))
+}
+
+func (ps *HealthPeerSelector) getHealth(peer string) *peerHealth {
+	h, ok := ps.health[peer]
+	if !ok {
+		h = &peerHealth{}
+		ps.health[peer] = h
+	}
+	return h
+}
@@ -0,0 +1,102 @@
+package node
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/champii/babble/net"
+)
+
+func healthPeers(n int) []net.Peer {
+	peers := []net.Peer{}
+	for i := 0; i < n; i++ {
+		peers = append(peers, net.Peer{
+			NetAddr:   fmt.Sprintf("addr%d", i),
+			PubKeyHex: fmt.Sprintf("0x%d", i),
+		})
+	}
+	return peers
+}
+
+func TestRandomPeerSelectorExcludesSelfByPubKey(t *testing.T) {
+	//The address of this node in the list is not the one it listens on
+	peers := healthPeers(3)
+	peers[1].NetAddr = "0.0.0.0:1337"
+
+	ps := NewRandomPeerSelector(peers, "0x1")
+
+	if l := len(ps.Peers()); l != 2 {
+		t.Fatalf("Peers() should contain 2 peers, not %d", l)
+	}
+	for i := 0; i < 100; i++ {
+		if p := ps.Next(); p.PubKeyHex == "0x1" {
+			t.Fatalf("Next() should not return this node")
+		}
+	}
+}
+
+func TestHealthPeerSelectorExcludesSelfAndLast(t *testing.T) {
+	ps := NewHealthPeerSelector(healthPeers(3), "0x0", time.Second)
+
+	if l := len(ps.Peers()); l != 2 {
+		t.Fatalf("Peers() should contain 2 peers, not %d", l)
+	}
+
+	ps.UpdateLast("addr1")
+	for i := 0; i < 100; i++ {
+		if p := ps.Next(); p.NetAddr != "addr2" {
+			t.Fatalf("Next() should return addr2, not %s", p.NetAddr)
+		}
+	}
+}
+
+func TestHealthPeerSelectorPrefersHealthyPeers(t *testing.T) {
+	ps := NewHealthPeerSelector(healthPeers(4), "0x0", time.Second)
+
+	ps.Success("addr1", 5*time.Millisecond, 0)
+	ps.Success("addr2", 500*time.Millisecond, 0)
+	ps.Success("addr3", 5*time.Millisecond, 1000)
+
+	counts := make(map[string]int)
+	for i := 0; i < 1000; i++ {
+		counts[ps.Next().NetAddr]++
+	}
+
+	if counts["addr1"] <= counts["addr2"] || counts["addr1"] <= counts["addr3"] {
+		t.Fatalf("addr1 should be picked more than the others: %v", counts)
+	}
+	if counts["addr2"] == 0 || counts["addr3"] == 0 {
+		t.Fatalf("Every peer should be explored: %v", counts)
+	}
+}
+
+func TestHealthPeerSelectorBackoff(t *testing.T) {
+	ps := NewHealthPeerSelector(healthPeers(3), "0x0", time.Hour)
+
+	ps.Failure("addr1")
+	for i := 0; i < 100; i++ {
+		if p := ps.Next(); p.NetAddr != "addr2" {
+			t.Fatalf("Next() should not return a peer in backoff")
+		}
+	}
+
+	first := ps.health["addr1"].retryAt
+	ps.Failure("addr1")
+	if d := ps.health["addr1"].retryAt.Sub(first); d < 30*time.Minute {
+		t.Fatalf("Backoff should double after consecutive failures")
+	}
+
+	//When every peer is backing off, the first one to recover is picked
+	ps.Failure("addr2")
+	if p := ps.Next(); p.NetAddr != "addr2" {
+		t.Fatalf("Next() should return addr2, not %s", p.NetAddr)
+	}
+
+	//A success resets the backoff
+	ps.Success("addr1", time.Millisecond, 0)
+	ps.UpdateLast("addr2")
+	if p := ps.Next(); p.NetAddr != "addr1" {
+		t.Fatalf("Next() should return addr1, not %s", p.NetAddr)
+	}
+}
@@ -0,0 +1,73 @@
+package node
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/champii/babble/proxy"
+)
+
+// ErrQueryUnsupported is returned by Query when the AppProxy does not
+// implement proxy.WithQuery.
+var ErrQueryUnsupported = errors.New("the App does not support queries")
+
+// QueryResult is the answer of the App to a query, with the index of the last
+// Block the App had committed when it answered, -1 if none.
+type QueryResult struct {
+	BlockIndex int
+	Result     []byte
+}
+
+//queryTimeout is how long the App has to answer a query. Commits wait for the
+//queries in progress, so this bounds the delay a query adds to delivery. It is
+//a var so that tests can shorten it.
+var queryTimeout = 5 * time.Second
+
+type appQueryResponse struct {
+	result QueryResult
+	err    error
+}
+
+// Query sends a read-only query to the App. It is never answered while a Block
+// is committed, so the result reflects the state of the App right after the
+// Block of the returned index.
+func (n *Node) Query(query []byte) (QueryResult, error) {
+	prox, ok := n.proxy.(proxy.WithQuery)
+	if !ok {
+		return QueryResult{}, ErrQueryUnsupported
+	}
+
+	n.appLock.RLock()
+	defer n.appLock.RUnlock()
+
+	n.coreLock.Lock()
+	index := n.core.GetLastDeliveredBlockIndex()
+	n.coreLock.Unlock()
+
+	//An App that does not answer must not hold the lock
+	respCh := make(chan appQueryResponse, 1)
+	go func() {
+		result, err := prox.Query(query)
+		respCh <- appQueryResponse{
+			result: QueryResult{
+				BlockIndex: index,
+				Result:     result,
+			},
+			err: err,
+		}
+	}()
+
+	select {
+	case resp := <-respCh:
+		if resp.err != nil {
+			n.logger.WithField("error", resp.err).Debug("App Query")
+			return QueryResult{}, resp.err
+		}
+		return resp.result, nil
+	case <-time.After(queryTimeout):
+		return QueryResult{}, fmt.Errorf("the App did not answer the query within %s", queryTimeout)
+	case <-n.shutdownCh:
+		return QueryResult{}, fmt.Errorf("node is shutting down")
+	}
+}
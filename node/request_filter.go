@@ -0,0 +1,58 @@
+package node
+
+import (
+	"sync"
+	"time"
+)
+
+//maxRequestAge is how long a signed request is accepted after it was signed.
+//The clocks of the nodes must agree within this delay.
+const maxRequestAge = time.Minute
+
+//requestFilter refuses the signed requests that are too old, or that were
+//already received. The hashes of the requests are remembered in two
+//generations that are rotated every 2*maxAge, so that a hash is kept for at
+//least as long as its request is accepted, including requests signed up to
+//maxAge in the future.
+type requestFilter struct {
+	maxAge time.Duration
+
+	lock     sync.Mutex
+	current  map[string]bool
+	previous map[string]bool
+	rotated  time.Time
+}
+
+func newRequestFilter(maxAge time.Duration) *requestFilter {
+	return &requestFilter{
+		maxAge:   maxAge,
+		current:  make(map[string]bool),
+		previous: make(map[string]bool),
+		rotated:  time.Now(),
+	}
+}
+
+//accept returns false if the request signed at signedAt, with the given hash,
+//is too old, too far in the future, or was already accepted
+func (f *requestFilter) accept(hash []byte, signedAt time.Time) bool {
+	now := time.Now()
+	if age := now.Sub(signedAt); age > f.maxAge || age < -f.maxAge {
+		return false
+	}
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if now.Sub(f.rotated) >= 2*f.maxAge {
+		f.previous = f.current
+		f.current = make(map[string]bool)
+		f.rotated = now
+	}
+
+	key := string(hash)
+	if f.current[key] || f.previous[key] {
+		return false
+	}
+	f.current[key] = true
+	return true
+}
@@ -0,0 +1,36 @@
+package node
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRequestFilter(t *testing.T) {
+	maxAge := 50 * time.Millisecond
+	f := newRequestFilter(maxAge)
+
+	if !f.accept([]byte("a"), time.Now()) {
+		t.Fatalf("a fresh request should be accepted")
+	}
+	if f.accept([]byte("a"), time.Now()) {
+		t.Fatalf("a request should only be accepted once")
+	}
+	if f.accept([]byte("b"), time.Now().Add(-2*maxAge)) {
+		t.Fatalf("an old request should be refused")
+	}
+	if f.accept([]byte("c"), time.Now().Add(2*maxAge)) {
+		t.Fatalf("a request from the future should be refused")
+	}
+
+	//A request signed ahead of our clock is remembered until it is too old
+	future := time.Now().Add(maxAge / 2)
+	if !f.accept([]byte("d"), future) {
+		t.Fatalf("a request signed slightly ahead should be accepted")
+	}
+	for time.Since(future) <= maxAge {
+		if f.accept([]byte("d"), future) {
+			t.Fatalf("a replayed request should be refused while it is fresh")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
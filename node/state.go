@@ -50,6 +50,13 @@ func (b *nodeState) setState(s NodeState) {
 	atomic.StoreUint32(stateAddr, uint32(s))
 }
 
+//compareAndSetState changes the state only if it is still old, so that a
+//Shutdown is not overridden
+func (b *nodeState) compareAndSetState(old, s NodeState) bool {
+	stateAddr := (*uint32)(&b.state)
+	return atomic.CompareAndSwapUint32(stateAddr, uint32(old), uint32(s))
+}
+
 func (b *nodeState) isStarting() bool {
 	return b.starting > 0
 }
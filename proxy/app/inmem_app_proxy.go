@@ -1,6 +1,10 @@
 package app
 
 import (
+	"encoding/json"
+	"fmt"
+	"sync"
+
 	bcrypto "github.com/champii/babble/crypto"
 	"github.com/champii/babble/hashgraph"
 	"github.com/sirupsen/logrus"
@@ -9,11 +13,20 @@ import (
 //InmemProxy is used for testing
 type InmemAppProxy struct {
 	submitCh              chan []byte
+	lock                  sync.Mutex
 	stateHash             []byte
 	committedTransactions [][]byte
+	lastBlockIndex        int
 	logger                *logrus.Logger
 }
 
+//inmemSnapshot is the state of an InmemAppProxy after a Block
+type inmemSnapshot struct {
+	BlockIndex   int
+	StateHash    []byte
+	Transactions [][]byte
+}
+
 func NewInmemAppProxy(logger *logrus.Logger) *InmemAppProxy {
 	if logger == nil {
 		logger = logrus.New()
@@ -23,11 +36,14 @@ func NewInmemAppProxy(logger *logrus.Logger) *InmemAppProxy {
 		submitCh:              make(chan []byte),
 		stateHash:             []byte{},
 		committedTransactions: [][]byte{},
+		lastBlockIndex:        -1,
 		logger:                logger,
 	}
 }
 
 func (iap *InmemAppProxy) commit(block hashgraph.Block) ([]byte, error) {
+	iap.lock.Lock()
+	defer iap.lock.Unlock()
 
 	iap.committedTransactions = append(iap.committedTransactions, block.Transactions()...)
 
@@ -38,6 +54,7 @@ func (iap *InmemAppProxy) commit(block hashgraph.Block) ([]byte, error) {
 	}
 
 	iap.stateHash = hash
+	iap.lastBlockIndex = block.Index()
 
 	return iap.stateHash, nil
 
@@ -58,6 +75,42 @@ func (p *InmemAppProxy) CommitBlock(block hashgraph.Block) (stateHash []byte, er
 	return p.commit(block)
 }
 
+func (p *InmemAppProxy) GetSnapshot(blockIndex int) ([]byte, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if blockIndex < 0 {
+		return json.Marshal(inmemSnapshot{BlockIndex: -1, StateHash: []byte{}})
+	}
+	//Only the current state is kept, which is that of the last Block
+	if blockIndex != p.lastBlockIndex {
+		return nil, fmt.Errorf("no snapshot for Block %d, the last Block is %d",
+			blockIndex, p.lastBlockIndex)
+	}
+	return json.Marshal(inmemSnapshot{
+		BlockIndex:   p.lastBlockIndex,
+		StateHash:    p.stateHash,
+		Transactions: p.committedTransactions,
+	})
+}
+
+func (p *InmemAppProxy) Restore(snapshot []byte) ([]byte, error) {
+	var state inmemSnapshot
+	if err := json.Unmarshal(snapshot, &state); err != nil {
+		return nil, err
+	}
+	if state.Transactions == nil {
+		state.Transactions = [][]byte{}
+	}
+	p.logger.WithField("txs", len(state.Transactions)).Debug("InmemProxy Restore")
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.stateHash = state.StateHash
+	p.committedTransactions = state.Transactions
+	p.lastBlockIndex = state.BlockIndex
+	return p.stateHash, nil
+}
+
 //------------------------------------------------------------------------------
 
 func (p *InmemAppProxy) SubmitTx(tx []byte) {
@@ -65,5 +118,7 @@ func (p *InmemAppProxy) SubmitTx(tx []byte) {
 }
 
 func (p *InmemAppProxy) GetCommittedTransactions() [][]byte {
+	p.lock.Lock()
+	defer p.lock.Unlock()
 	return p.committedTransactions
 }
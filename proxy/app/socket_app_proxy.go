@@ -4,6 +4,7 @@ import (
 	"time"
 
 	"github.com/champii/babble/hashgraph"
+	"github.com/champii/babble/mempool"
 	"github.com/sirupsen/logrus"
 )
 
@@ -48,3 +49,38 @@ func (p *SocketAppProxy) SubmitCh() chan []byte {
 func (p *SocketAppProxy) CommitBlock(block hashgraph.Block) ([]byte, error) {
 	return p.client.CommitBlock(block)
 }
+
+func (p *SocketAppProxy) GetSnapshot(blockIndex int) ([]byte, error) {
+	return p.client.GetSnapshot(blockIndex)
+}
+
+func (p *SocketAppProxy) Restore(snapshot []byte) ([]byte, error) {
+	return p.client.Restore(snapshot)
+}
+
+//++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++
+//Implement WithMempool Interface
+
+// SetMempool makes SubmitTx add transactions to m, and return its errors to
+// the App. It must be called before the App connects.
+func (p *SocketAppProxy) SetMempool(m *mempool.Mempool) {
+	p.server.setMempool(m)
+}
+
+//++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++
+//Implement WithHandshake Interface
+
+// SetHandshakeHandler sets the function that handles the Handshake RPCs of the
+// App. It must be called before the App connects.
+func (p *SocketAppProxy) SetHandshakeHandler(handler func(lastBlockIndex int, stateHash []byte) error) {
+	p.server.setHandshake(handler)
+}
+
+//++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++
+//Implement WithQuery Interface
+
+// Query sends a read-only query to the App with the State.Query RPC. Apps that
+// do not implement it return an error.
+func (p *SocketAppProxy) Query(query []byte) ([]byte, error) {
+	return p.client.Query(query)
+}
@@ -1,9 +1,6 @@
 package app
 
 import (
-	"net"
-	"net/rpc"
-	"net/rpc/jsonrpc"
 	"time"
 
 	"github.com/champii/babble/hashgraph"
@@ -11,36 +8,25 @@ import (
 	"github.com/sirupsen/logrus"
 )
 
+//SocketAppProxyClient sends the RPCs of the node to the App over a single
+//connection, which is dialed again if the App restarts
 type SocketAppProxyClient struct {
 	clientAddr string
-	timeout    time.Duration
+	rpc        *bp.RPCClient
 	logger     *logrus.Logger
 }
 
 func NewSocketAppProxyClient(clientAddr string, timeout time.Duration, logger *logrus.Logger) *SocketAppProxyClient {
 	return &SocketAppProxyClient{
 		clientAddr: clientAddr,
-		timeout:    timeout,
+		rpc:        bp.NewRPCClient(clientAddr, timeout),
 		logger:     logger,
 	}
 }
 
-func (p *SocketAppProxyClient) getConnection() (*rpc.Client, error) {
-	conn, err := net.DialTimeout("tcp", p.clientAddr, p.timeout)
-	if err != nil {
-		return nil, err
-	}
-	return jsonrpc.NewClient(conn), nil
-}
-
 func (p *SocketAppProxyClient) CommitBlock(block hashgraph.Block) ([]byte, error) {
-	rpcConn, err := p.getConnection()
-	if err != nil {
-		return nil, err
-	}
-
 	var stateHash bp.StateHash
-	err = rpcConn.Call("State.CommitBlock", block, &stateHash)
+	err := p.rpc.Call("State.CommitBlock", block, &stateHash)
 
 	p.logger.WithFields(logrus.Fields{
 		"block":      block.Index(),
@@ -49,3 +35,39 @@ func (p *SocketAppProxyClient) CommitBlock(block hashgraph.Block) ([]byte, error
 
 	return stateHash.Hash, err
 }
+
+func (p *SocketAppProxyClient) GetSnapshot(blockIndex int) ([]byte, error) {
+	var snapshot []byte
+	err := p.rpc.Call("State.GetSnapshot", blockIndex, &snapshot)
+
+	p.logger.WithFields(logrus.Fields{
+		"block":    blockIndex,
+		"snapshot": len(snapshot),
+	}).Debug("AppProxyClient.GetSnapshot")
+
+	return snapshot, err
+}
+
+func (p *SocketAppProxyClient) Restore(snapshot []byte) ([]byte, error) {
+	var stateHash bp.StateHash
+	err := p.rpc.Call("State.Restore", snapshot, &stateHash)
+
+	p.logger.WithFields(logrus.Fields{
+		"snapshot":   len(snapshot),
+		"state_hash": stateHash.Hash,
+	}).Debug("AppProxyClient.Restore")
+
+	return stateHash.Hash, err
+}
+
+func (p *SocketAppProxyClient) Query(query []byte) ([]byte, error) {
+	var result []byte
+	err := p.rpc.Call("State.Query", query, &result)
+
+	p.logger.WithFields(logrus.Fields{
+		"query":  len(query),
+		"result": len(result),
+	}).Debug("AppProxyClient.Query")
+
+	return result, err
+}
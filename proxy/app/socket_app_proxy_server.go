@@ -1,10 +1,14 @@
 package app
 
 import (
+	"fmt"
 	"net"
 	"net/rpc"
 	"net/rpc/jsonrpc"
+	"sync"
 
+	"github.com/champii/babble/mempool"
+	bp "github.com/champii/babble/proxy/babble"
 	"github.com/sirupsen/logrus"
 )
 
@@ -13,6 +17,11 @@ type SocketAppProxyServer struct {
 	rpcServer   *rpc.Server
 	submitCh    chan []byte
 	logger      *logrus.Logger
+
+	//set by the node while the server is already listening
+	lock      sync.RWMutex
+	mempool   *mempool.Mempool
+	handshake func(lastBlockIndex int, stateHash []byte) error
 }
 
 func NewSocketAppProxyServer(bindAddress string, logger *logrus.Logger) *SocketAppProxyServer {
@@ -43,13 +52,128 @@ func (p *SocketAppProxyServer) listen() {
 			p.logger.WithField("error", err).Error("Failed to accept")
 		}
 
-		go (*p.rpcServer).ServeCodec(jsonrpc.NewServerCodec(conn))
+		go p.serveConn(conn)
+	}
+}
+
+func (p *SocketAppProxyServer) setMempool(m *mempool.Mempool) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.mempool = m
+}
+
+func (p *SocketAppProxyServer) getMempool() *mempool.Mempool {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	return p.mempool
+}
+
+func (p *SocketAppProxyServer) setHandshake(handler func(lastBlockIndex int, stateHash []byte) error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.handshake = handler
+}
+
+func (p *SocketAppProxyServer) getHandshake() func(lastBlockIndex int, stateHash []byte) error {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	return p.handshake
+}
+
+//serveConn serves the RPCs of one App connection. With a mempool, the
+//transactions of the connection are counted together for the per-submitter
+//limit.
+func (p *SocketAppProxyServer) serveConn(conn net.Conn) {
+	if p.getMempool() == nil {
+		p.rpcServer.ServeCodec(jsonrpc.NewServerCodec(conn))
+		return
+	}
+
+	rpcServer := rpc.NewServer()
+	rpcServer.RegisterName("Babble", &connProxyServer{
+		SocketAppProxyServer: p,
+		submitter:            conn.RemoteAddr().String(),
+	})
+	rpcServer.ServeCodec(jsonrpc.NewServerCodec(conn))
+}
+
+func (p *SocketAppProxyServer) Handshake(handshake bp.Handshake, ack *bool) error {
+	p.logger.WithFields(logrus.Fields{
+		"last_block_index": handshake.LastBlockIndex,
+		"state_hash":       handshake.StateHash,
+	}).Debug("Handshake")
+	handler := p.getHandshake()
+	if handler == nil {
+		return fmt.Errorf("handshake not supported")
+	}
+	if err := handler(handshake.LastBlockIndex, handshake.StateHash); err != nil {
+		return err
 	}
+	*ack = true
+	return nil
 }
 
 func (p *SocketAppProxyServer) SubmitTx(tx []byte, ack *bool) error {
+	return p.submitTx(tx, "", ack)
+}
+
+//submitTx adds tx to the mempool if there is one, or sends it on submitCh. The
+//errors of the mempool are returned to the App.
+func (p *SocketAppProxyServer) submitTx(tx []byte, submitter string, ack *bool) error {
 	p.logger.Debug("SubmitTx")
-	p.submitCh <- tx
+	pool := p.getMempool()
+	if pool == nil {
+		p.submitCh <- tx
+		*ack = true
+		return nil
+	}
+	if err := pool.Add(tx, submitter); err != nil {
+		p.logger.WithFields(logrus.Fields{
+			"submitter": submitter,
+			"error":     err,
+		}).Debug("Refused transaction")
+		return err
+	}
 	*ack = true
 	return nil
 }
+
+// SubmitTxs submits a batch of transactions. The results hold the error of
+// each transaction, or an empty string if it was accepted.
+func (p *SocketAppProxyServer) SubmitTxs(txs [][]byte, results *[]string) error {
+	return p.submitTxs(txs, "", results)
+}
+
+//submitTxs adds a batch of transactions to the mempool at once if there is
+//one, or sends them on submitCh
+func (p *SocketAppProxyServer) submitTxs(txs [][]byte, submitter string, results *[]string) error {
+	p.logger.WithField("txs", len(txs)).Debug("SubmitTxs")
+	*results = make([]string, len(txs))
+	pool := p.getMempool()
+	if pool == nil {
+		for _, tx := range txs {
+			p.submitCh <- tx
+		}
+		return nil
+	}
+	for i, err := range pool.AddBatch(txs, submitter) {
+		if err != nil {
+			(*results)[i] = err.Error()
+		}
+	}
+	return nil
+}
+
+//connProxyServer serves the RPCs of a single App connection
+type connProxyServer struct {
+	*SocketAppProxyServer
+	submitter string
+}
+
+func (c *connProxyServer) SubmitTx(tx []byte, ack *bool) error {
+	return c.submitTx(tx, c.submitter, ack)
+}
+
+func (c *connProxyServer) SubmitTxs(txs [][]byte, results *[]string) error {
+	return c.submitTxs(txs, c.submitter, results)
+}
@@ -0,0 +1,503 @@
+package app
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/champii/babble/hashgraph"
+	"github.com/champii/babble/mempool"
+	bp "github.com/champii/babble/proxy/babble"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	//delay before starting the App again after it exited
+	restartBaseBackoff = 100 * time.Millisecond
+	//max delay between two starts; an App that ran longer than this is
+	//started again after restartBaseBackoff
+	restartMaxBackoff = 5 * time.Second
+	//max size of a message, which must hold a whole Block
+	maxStdioMessage = 64 * 1024 * 1024
+)
+
+var (
+	errAppNotRunning = errors.New("App process is not running")
+	errProxyClosed   = errors.New("StdioAppProxy is closed")
+)
+
+//stdioMessage is a line exchanged with the App process. Requests have a
+//method, and an id if they expect a response. Responses have the id of the
+//request they answer, and a result or an error.
+type stdioMessage struct {
+	ID     *uint64         `json:"id,omitempty"`
+	Method string          `json:"method,omitempty"`
+	Params json.RawMessage `json:"params,omitempty"`
+	Result json.RawMessage `json:"result,omitempty"`
+	Error  string          `json:"error,omitempty"`
+}
+
+type stdioResponse struct {
+	result json.RawMessage
+	err    error
+}
+
+//stdioProcess is one run of the App process
+type stdioProcess struct {
+	cmd   *exec.Cmd
+	stdin io.WriteCloser
+
+	writeLock sync.Mutex
+
+	lock    sync.Mutex
+	pending map[uint64]chan stdioResponse
+	exited  bool
+}
+
+//send writes a message to the stdin of the process
+func (s *stdioProcess) send(msg stdioMessage) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	s.writeLock.Lock()
+	defer s.writeLock.Unlock()
+	_, err = s.stdin.Write(append(data, '\n'))
+	return err
+}
+
+//register waits for the response to a request, unless the process exited
+func (s *stdioProcess) register(id uint64, respCh chan stdioResponse) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.exited {
+		return false
+	}
+	s.pending[id] = respCh
+	return true
+}
+
+func (s *stdioProcess) resolve(id uint64, resp stdioResponse) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if respCh, ok := s.pending[id]; ok {
+		respCh <- resp
+		delete(s.pending, id)
+	}
+}
+
+//exit fails the requests that are still waiting for a response
+func (s *stdioProcess) exit(err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.exited = true
+	for id, respCh := range s.pending {
+		respCh <- stdioResponse{err: err}
+		delete(s.pending, id)
+	}
+}
+
+// StdioAppProxy runs the App as a child process and exchanges newline-delimited
+// JSON messages with it over its stdin and stdout. The messages are those of
+// the SocketAppProxy: the node sends State.CommitBlock, State.GetSnapshot,
+// State.Restore and State.Query requests to the App, and the App sends
+// Babble.SubmitTx, Babble.SubmitTxs and Babble.Handshake requests to the
+// node. The App is started again, with backoff, whenever it exits or does not
+// answer a request within the timeout; it should then Handshake to get the
+// Blocks it lost.
+type StdioAppProxy struct {
+	command   []string
+	timeout   time.Duration
+	submitCh  chan []byte
+	mempool   *mempool.Mempool
+	handshake func(lastBlockIndex int, stateHash []byte) error
+	logger    *logrus.Logger
+
+	shutdownCh chan struct{}
+	doneCh     chan struct{}
+
+	lock    sync.Mutex
+	proc    *stdioProcess
+	nextID  uint64
+	started bool
+	closed  bool
+}
+
+// NewStdioAppProxy creates a StdioAppProxy for the App started by command.
+// The command is split on white space; it is not run by a shell. The App has
+// timeout to answer each request.
+func NewStdioAppProxy(command string,
+	timeout time.Duration,
+	logger *logrus.Logger) (*StdioAppProxy, error) {
+
+	if logger == nil {
+		logger = logrus.New()
+		logger.Level = logrus.DebugLevel
+	}
+
+	args := strings.Fields(command)
+	if len(args) == 0 {
+		return nil, errors.New("empty App command")
+	}
+
+	return &StdioAppProxy{
+		command:    args,
+		timeout:    timeout,
+		submitCh:   make(chan []byte),
+		logger:     logger,
+		shutdownCh: make(chan struct{}),
+		doneCh:     make(chan struct{}),
+	}, nil
+}
+
+// Start starts the App process, and starts it again whenever it exits, until
+// Close is called. It must be called after the proxy is given to the node.
+func (p *StdioAppProxy) Start() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.started || p.closed {
+		return
+	}
+	p.started = true
+	go p.supervise()
+}
+
+// Close stops the App process and does not start it again. The App is asked
+// to exit by closing its stdin, and killed if it has not exited within the
+// timeout.
+func (p *StdioAppProxy) Close() {
+	p.lock.Lock()
+	if p.closed {
+		p.lock.Unlock()
+		return
+	}
+	p.closed = true
+	close(p.shutdownCh)
+	proc := p.proc
+	started := p.started
+	p.lock.Unlock()
+
+	if !started {
+		return
+	}
+	if proc != nil {
+		proc.stdin.Close()
+	}
+	select {
+	case <-p.doneCh:
+	case <-time.After(p.timeout):
+		p.lock.Lock()
+		proc = p.proc
+		p.lock.Unlock()
+		if proc != nil {
+			proc.cmd.Process.Kill()
+		}
+		<-p.doneCh
+	}
+}
+
+//supervise runs the App process, again and again, until the proxy is closed
+func (p *StdioAppProxy) supervise() {
+	defer close(p.doneCh)
+
+	backoff := time.Duration(0)
+	for {
+		start := time.Now()
+		err := p.runProcess()
+
+		select {
+		case <-p.shutdownCh:
+			p.logger.WithField("error", err).Debug("App process stopped")
+			return
+		default:
+		}
+
+		if time.Since(start) > restartMaxBackoff || backoff == 0 {
+			backoff = restartBaseBackoff
+		} else if backoff < restartMaxBackoff {
+			backoff *= 2
+		}
+		p.logger.WithFields(logrus.Fields{
+			"command":    strings.Join(p.command, " "),
+			"error":      err,
+			"restart_in": backoff,
+		}).Error("App process exited")
+
+		select {
+		case <-time.After(backoff):
+		case <-p.shutdownCh:
+			return
+		}
+	}
+}
+
+//runProcess starts the App process and serves its messages until it exits
+func (p *StdioAppProxy) runProcess() error {
+	cmd := exec.Command(p.command[0], p.command[1:]...)
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	proc := &stdioProcess{
+		cmd:     cmd,
+		stdin:   stdin,
+		pending: make(map[uint64]chan stdioResponse),
+	}
+	p.lock.Lock()
+	if p.closed {
+		//Close did not see this process, so it is stopped here
+		p.lock.Unlock()
+		cmd.Process.Kill()
+		cmd.Wait()
+		return errProxyClosed
+	}
+	p.proc = proc
+	p.lock.Unlock()
+
+	p.logger.WithField("pid", cmd.Process.Pid).Info("App process started")
+
+	if err := p.read(proc, stdout); err != nil {
+		//the stream can not be read further, so the process is of no use
+		p.logger.WithField("error", err).Error("Reading App process")
+		cmd.Process.Kill()
+	}
+
+	p.lock.Lock()
+	if p.proc == proc {
+		p.proc = nil
+	}
+	p.lock.Unlock()
+	proc.exit(errAppNotRunning)
+	stdin.Close()
+
+	return cmd.Wait()
+}
+
+//read handles the messages of the App process until its stdout is closed
+func (p *StdioAppProxy) read(proc *stdioProcess, stdout io.Reader) error {
+	scanner := bufio.NewScanner(stdout)
+	scanner.Buffer(make([]byte, 64*1024), maxStdioMessage)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(strings.TrimSpace(string(line))) == 0 {
+			continue
+		}
+
+		var msg stdioMessage
+		if err := json.Unmarshal(line, &msg); err != nil {
+			p.logger.WithFields(logrus.Fields{
+				"line":  string(line),
+				"error": err,
+			}).Warn("Invalid message from App process")
+			continue
+		}
+
+		switch {
+		case msg.Method == "Babble.Handshake":
+			//the handshake waits for the Blocks being committed, whose
+			//responses are read here
+			go p.handleRequest(proc, msg)
+		case msg.Method != "":
+			p.handleRequest(proc, msg)
+		case msg.ID != nil:
+			resp := stdioResponse{result: msg.Result}
+			if msg.Error != "" {
+				resp.err = errors.New(msg.Error)
+			}
+			proc.resolve(*msg.ID, resp)
+		}
+	}
+	return scanner.Err()
+}
+
+//handleRequest serves a request of the App process, and responds to it if it
+//has an id
+func (p *StdioAppProxy) handleRequest(proc *stdioProcess, msg stdioMessage) {
+	result, err := p.serve(msg.Method, msg.Params)
+	if err != nil {
+		p.logger.WithFields(logrus.Fields{
+			"method": msg.Method,
+			"error":  err,
+		}).Debug("App request")
+	}
+	if msg.ID == nil {
+		return
+	}
+
+	resp := stdioMessage{ID: msg.ID}
+	if err != nil {
+		resp.Error = err.Error()
+	} else if resp.Result, err = json.Marshal(result); err != nil {
+		resp.Error = err.Error()
+	}
+	if err := proc.send(resp); err != nil {
+		p.logger.WithField("error", err).Error("Responding to App process")
+	}
+}
+
+func (p *StdioAppProxy) serve(method string, params json.RawMessage) (interface{}, error) {
+	switch method {
+	case "Babble.SubmitTx":
+		var tx []byte
+		if err := json.Unmarshal(params, &tx); err != nil {
+			return nil, err
+		}
+		if p.mempool == nil {
+			p.submitCh <- tx
+			return true, nil
+		}
+		if err := p.mempool.Add(tx, ""); err != nil {
+			return nil, err
+		}
+		return true, nil
+	case "Babble.SubmitTxs":
+		var txs [][]byte
+		if err := json.Unmarshal(params, &txs); err != nil {
+			return nil, err
+		}
+		results := make([]string, len(txs))
+		if p.mempool == nil {
+			for _, tx := range txs {
+				p.submitCh <- tx
+			}
+			return results, nil
+		}
+		for i, err := range p.mempool.AddBatch(txs, "") {
+			if err != nil {
+				results[i] = err.Error()
+			}
+		}
+		return results, nil
+	case "Babble.Handshake":
+		var handshake bp.Handshake
+		if err := json.Unmarshal(params, &handshake); err != nil {
+			return nil, err
+		}
+		if p.handshake == nil {
+			return nil, fmt.Errorf("handshake not supported")
+		}
+		if err := p.handshake(handshake.LastBlockIndex, handshake.StateHash); err != nil {
+			return nil, err
+		}
+		return true, nil
+	}
+	return nil, fmt.Errorf("unknown method %s", method)
+}
+
+//call sends a request to the App process and waits for its response, or for
+//the process to exit. An App that does not answer within the timeout is
+//killed, so that it is started again.
+func (p *StdioAppProxy) call(method string, params interface{}, result interface{}) error {
+	data, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+
+	p.lock.Lock()
+	proc := p.proc
+	p.nextID++
+	id := p.nextID
+	p.lock.Unlock()
+
+	respCh := make(chan stdioResponse, 1)
+	if proc == nil || !proc.register(id, respCh) {
+		return errAppNotRunning
+	}
+	if err := proc.send(stdioMessage{ID: &id, Method: method, Params: data}); err != nil {
+		proc.resolve(id, stdioResponse{err: err})
+	}
+
+	var resp stdioResponse
+	select {
+	case resp = <-respCh:
+	case <-time.After(p.timeout):
+		//the App is stuck; killing it fails its other requests, which would
+		//time out as well
+		p.logger.WithFields(logrus.Fields{
+			"method":  method,
+			"timeout": p.timeout,
+		}).Error("App request timed out, killing App process")
+		proc.cmd.Process.Kill()
+		return fmt.Errorf("%s timed out after %s", method, p.timeout)
+	}
+	if resp.err != nil {
+		return resp.err
+	}
+	return json.Unmarshal(resp.result, result)
+}
+
+//++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++
+//Implement AppProxy Interface
+
+func (p *StdioAppProxy) SubmitCh() chan []byte {
+	return p.submitCh
+}
+
+func (p *StdioAppProxy) CommitBlock(block hashgraph.Block) ([]byte, error) {
+	var stateHash bp.StateHash
+	err := p.call("State.CommitBlock", block, &stateHash)
+
+	p.logger.WithFields(logrus.Fields{
+		"block":      block.Index(),
+		"state_hash": stateHash.Hash,
+	}).Debug("StdioAppProxy.CommitBlock")
+
+	return stateHash.Hash, err
+}
+
+func (p *StdioAppProxy) GetSnapshot(blockIndex int) ([]byte, error) {
+	var snapshot []byte
+	err := p.call("State.GetSnapshot", blockIndex, &snapshot)
+	return snapshot, err
+}
+
+func (p *StdioAppProxy) Restore(snapshot []byte) ([]byte, error) {
+	var stateHash bp.StateHash
+	err := p.call("State.Restore", snapshot, &stateHash)
+	return stateHash.Hash, err
+}
+
+//++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++
+//Implement WithMempool Interface
+
+// SetMempool makes SubmitTx add transactions to m, and return its errors to
+// the App. It must be called before Start.
+func (p *StdioAppProxy) SetMempool(m *mempool.Mempool) {
+	p.mempool = m
+}
+
+//++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++
+//Implement WithHandshake Interface
+
+// SetHandshakeHandler sets the function that handles the Handshakes of the
+// App. It must be called before Start.
+func (p *StdioAppProxy) SetHandshakeHandler(handler func(lastBlockIndex int, stateHash []byte) error) {
+	p.handshake = handler
+}
+
+//++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++
+//Implement WithQuery Interface
+
+// Query sends a read-only query to the App with a State.Query request. Apps
+// that do not implement it return an error.
+func (p *StdioAppProxy) Query(query []byte) ([]byte, error) {
+	var result []byte
+	err := p.call("State.Query", query, &result)
+	return result, err
+}
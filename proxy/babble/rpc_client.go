@@ -0,0 +1,153 @@
+package babble
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+	"sync"
+	"time"
+)
+
+const (
+	//delay before dialing again after a failed dial
+	dialBaseBackoff = 100 * time.Millisecond
+	//max delay between dials
+	dialMaxBackoff = 5 * time.Second
+)
+
+// RPCClient is a JSON-RPC client that keeps its connection open between calls
+// and dials again, with exponential backoff, when the connection is lost. It is
+// safe for concurrent use; concurrent calls share the connection.
+type RPCClient struct {
+	addr    string
+	timeout time.Duration
+
+	lock     sync.Mutex
+	client   *rpc.Client
+	backoff  time.Duration
+	nextDial time.Time
+	dialErr  error
+}
+
+// NewRPCClient creates an RPCClient for the server at addr. The connection is
+// dialed on the first call, with the given timeout.
+func NewRPCClient(addr string, timeout time.Duration) *RPCClient {
+	return &RPCClient{
+		addr:    addr,
+		timeout: timeout,
+	}
+}
+
+// Call calls a method of the server and waits for its response, for at most
+// the timeout of the client. A call that could not be sent because the
+// connection was closed, for example because the server restarted, is sent
+// again on a new connection. Calls fail right away, with the error of the last
+// dial, until the end of the backoff.
+func (c *RPCClient) Call(method string, args interface{}, reply interface{}) error {
+	client, err := c.getClient()
+	if err != nil {
+		return err
+	}
+
+	err = c.call(client, method, args, reply)
+	if err == rpc.ErrShutdown {
+		c.drop(client)
+		if client, err = c.getClient(); err != nil {
+			return err
+		}
+		err = c.call(client, method, args, reply)
+	}
+
+	if isConnError(err) {
+		c.drop(client)
+	}
+	return err
+}
+
+//call sends a call over a connection and waits for its response. The
+//connection is dropped if the response does not come in time, so that the
+//next call does not queue behind a server that is stuck.
+func (c *RPCClient) call(client *rpc.Client, method string, args interface{}, reply interface{}) error {
+	if c.timeout <= 0 {
+		return client.Call(method, args, reply)
+	}
+
+	done := client.Go(method, args, reply, make(chan *rpc.Call, 1)).Done
+	select {
+	case call := <-done:
+		return call.Error
+	case <-time.After(c.timeout):
+		c.drop(client)
+		return fmt.Errorf("%s timed out after %s", method, c.timeout)
+	}
+}
+
+// Close closes the connection. The next call dials again.
+func (c *RPCClient) Close() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.client == nil {
+		return nil
+	}
+	err := c.client.Close()
+	c.client = nil
+	return err
+}
+
+//getClient returns the open connection, or dials a new one unless the last
+//dial failed less than a backoff ago
+func (c *RPCClient) getClient() (*rpc.Client, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.client != nil {
+		return c.client, nil
+	}
+	if time.Now().Before(c.nextDial) {
+		return nil, c.dialErr
+	}
+
+	conn, err := net.DialTimeout("tcp", c.addr, c.timeout)
+	if err != nil {
+		if c.backoff == 0 {
+			c.backoff = dialBaseBackoff
+		} else if c.backoff < dialMaxBackoff {
+			c.backoff *= 2
+		}
+		c.nextDial = time.Now().Add(c.backoff)
+		c.dialErr = fmt.Errorf("connecting to %s: %s", c.addr, err)
+		return nil, c.dialErr
+	}
+
+	c.backoff = 0
+	c.client = jsonrpc.NewClient(conn)
+	return c.client, nil
+}
+
+//drop closes a connection that is dead, unless it was already replaced
+func (c *RPCClient) drop(client *rpc.Client) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.client == client {
+		c.client.Close()
+		c.client = nil
+	}
+}
+
+//isConnError returns true if err means that the connection is no longer
+//usable, as opposed to an error returned by the server
+func isConnError(err error) bool {
+	if err == nil {
+		return false
+	}
+	if _, ok := err.(rpc.ServerError); ok {
+		return false
+	}
+	if err == rpc.ErrShutdown || err == io.EOF || err == io.ErrUnexpectedEOF {
+		return true
+	}
+	_, ok := err.(net.Error)
+	return ok
+}
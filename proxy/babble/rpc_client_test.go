@@ -0,0 +1,167 @@
+package babble
+
+import (
+	"fmt"
+	"net"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+	"sync"
+	"testing"
+	"time"
+)
+
+//echoServer is a JSON-RPC server that can be stopped along with all its
+//connections, as if its process died
+type echoServer struct {
+	listener net.Listener
+
+	lock  sync.Mutex
+	conns []net.Conn
+	dials int
+}
+
+type Echo struct{}
+
+func (e *Echo) Echo(arg string, reply *string) error {
+	*reply = arg
+	return nil
+}
+
+//Sleep answers after d
+func (e *Echo) Sleep(d time.Duration, reply *string) error {
+	time.Sleep(d)
+	*reply = "awake"
+	return nil
+}
+
+func startEchoServer(t *testing.T, addr string) *echoServer {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := rpc.NewServer()
+	server.Register(&Echo{})
+
+	s := &echoServer{listener: l}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			s.lock.Lock()
+			s.conns = append(s.conns, conn)
+			s.dials++
+			s.lock.Unlock()
+			go server.ServeCodec(jsonrpc.NewServerCodec(conn))
+		}
+	}()
+	return s
+}
+
+func (s *echoServer) stop() {
+	s.listener.Close()
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, c := range s.conns {
+		c.Close()
+	}
+}
+
+func (s *echoServer) getDials() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.dials
+}
+
+func TestRPCClientReconnect(t *testing.T) {
+	addr := "127.0.0.1:9970"
+	server := startEchoServer(t, addr)
+	client := NewRPCClient(addr, time.Second)
+	defer client.Close()
+
+	//Concurrent calls share one connection
+	var wg sync.WaitGroup
+	errs := make(chan error, 20)
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			var reply string
+			if err := client.Call("Echo.Echo", fmt.Sprint(i), &reply); err != nil {
+				errs <- err
+			} else if reply != fmt.Sprint(i) {
+				errs <- fmt.Errorf("reply should be %d, not %s", i, reply)
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+	if d := server.getDials(); d != 1 {
+		t.Fatalf("calls should share 1 connection, not %d", d)
+	}
+
+	//The server dies: calls fail, and succeed again once it is back
+	server.stop()
+	var reply string
+	if err := client.Call("Echo.Echo", "down", &reply); err == nil {
+		t.Fatalf("calling a stopped server should fail")
+	}
+
+	server = startEchoServer(t, addr)
+	defer server.stop()
+	timeout := time.After(5 * time.Second)
+	for {
+		err := client.Call("Echo.Echo", "up", &reply)
+		if err == nil {
+			break
+		}
+		select {
+		case <-timeout:
+			t.Fatalf("client did not reconnect: %s", err)
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	if reply != "up" {
+		t.Fatalf("reply should be up, not %s", reply)
+	}
+	if d := server.getDials(); d != 1 {
+		t.Fatalf("client should have dialed the restarted server once, not %d times", d)
+	}
+
+	//A connection closed between two calls is replaced without failing a call
+	server.stop()
+	server = startEchoServer(t, addr)
+	defer server.stop()
+	time.Sleep(50 * time.Millisecond)
+	if err := client.Call("Echo.Echo", "again", &reply); err != nil {
+		t.Fatalf("call after a restart between calls should succeed: %s", err)
+	}
+}
+
+func TestRPCClientTimeout(t *testing.T) {
+	addr := "127.0.0.1:9971"
+	server := startEchoServer(t, addr)
+	defer server.stop()
+	client := NewRPCClient(addr, 100*time.Millisecond)
+	defer client.Close()
+
+	var reply string
+	if err := client.Call("Echo.Sleep", time.Second, &reply); err == nil {
+		t.Fatalf("a call without a response in time should fail")
+	}
+
+	//The next call uses a new connection
+	if err := client.Call("Echo.Echo", "next", &reply); err != nil {
+		t.Fatal(err)
+	}
+	if reply != "next" {
+		t.Fatalf("reply should be next, not %s", reply)
+	}
+	if d := server.getDials(); d != 2 {
+		t.Fatalf("client should have dialed again after the timeout, not %d times", d)
+	}
+}
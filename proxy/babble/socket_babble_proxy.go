@@ -1,9 +1,13 @@
 package babble
 
 import (
+	"errors"
 	"fmt"
+	"net/rpc"
 	"time"
 
+	"github.com/champii/babble/mempool"
+
 	"github.com/sirupsen/logrus"
 )
 
@@ -49,13 +53,107 @@ func (p *SocketBabbleProxy) CommitCh() chan Commit {
 	return p.server.commitCh
 }
 
+// SnapshotCh receives the requests of the node for the state of the App at a
+// Block, which it sends to nodes that fast-sync from it.
+func (p *SocketBabbleProxy) SnapshotCh() chan SnapshotRequest {
+	return p.server.snapshotCh
+}
+
+// RestoreCh receives the snapshots that the App must restore its state from,
+// when the node fast-syncs from another one.
+func (p *SocketBabbleProxy) RestoreCh() chan RestoreRequest {
+	return p.server.restoreCh
+}
+
+// QueryCh receives the read-only queries of the clients of the node. They are
+// never sent while the App commits a Block.
+func (p *SocketBabbleProxy) QueryCh() chan QueryRequest {
+	return p.server.queryCh
+}
+
+// Handshake tells the node the index and state hash of the last Block the App
+// committed, or -1 and nil if it has none. The node then commits the Blocks
+// that follow it, before new ones. An error is returned if the node does not
+// have that Block, or if its state hash differs from the one the node signed.
+func (p *SocketBabbleProxy) Handshake(lastBlockIndex int, stateHash []byte) error {
+	ack, err := p.client.Handshake(Handshake{
+		LastBlockIndex: lastBlockIndex,
+		StateHash:      stateHash,
+	})
+	if err != nil {
+		return err
+	}
+	if !*ack {
+		return fmt.Errorf("Failed to deliver handshake to Babble")
+	}
+	return nil
+}
+
+// SubmitTx sends a transaction to the node. Transactions refused by the node's
+// mempool return the corresponding mempool error, like mempool.ErrPoolFull.
 func (p *SocketBabbleProxy) SubmitTx(tx []byte) error {
 	ack, err := p.client.SubmitTx(tx)
 	if err != nil {
-		return err
+		return mempoolError(err)
 	}
 	if !*ack {
 		return fmt.Errorf("Failed to deliver transaction to Babble")
 	}
 	return nil
 }
+
+// SubmitTxs sends a batch of transactions to the node, which adds them to its
+// mempool at once. It returns the error of each transaction, nil if it was
+// accepted, or an error if the batch could not be submitted.
+func (p *SocketBabbleProxy) SubmitTxs(txs [][]byte) ([]error, error) {
+	results, err := p.client.SubmitTxs(txs)
+	if err != nil {
+		return nil, err
+	}
+	if len(results) != len(txs) {
+		return nil, fmt.Errorf("%d results for %d transactions", len(results), len(txs))
+	}
+	errs := make([]error, len(results))
+	for i, r := range results {
+		if r != "" {
+			errs[i] = txError(r)
+		}
+	}
+	return errs, nil
+}
+
+//mempoolError returns the mempool error that was sent as err by the node, or
+//err itself
+func mempoolError(err error) error {
+	serverErr, ok := err.(rpc.ServerError)
+	if !ok {
+		return err
+	}
+	if e := findMempoolError(string(serverErr)); e != nil {
+		return e
+	}
+	return err
+}
+
+//txError returns the mempool error with the message msg, or a new error
+func txError(msg string) error {
+	if e := findMempoolError(msg); e != nil {
+		return e
+	}
+	return errors.New(msg)
+}
+
+//findMempoolError returns the mempool error with the message msg, or nil
+func findMempoolError(msg string) error {
+	for _, e := range []error{
+		mempool.ErrPoolFull,
+		mempool.ErrTooLarge,
+		mempool.ErrDuplicate,
+		mempool.ErrSubmitterQuota,
+	} {
+		if msg == e.Error() {
+			return e
+		}
+	}
+	return nil
+}
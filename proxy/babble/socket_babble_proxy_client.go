@@ -1,41 +1,46 @@
 package babble
 
 import (
-	"net"
-	"net/rpc"
-	"net/rpc/jsonrpc"
 	"time"
 )
 
+//SocketBabbleProxyClient sends the RPCs of the App to the node over a single
+//connection, which is dialed again if the node restarts
 type SocketBabbleProxyClient struct {
 	nodeAddr string
-	timeout  time.Duration
+	rpc      *RPCClient
 }
 
 func NewSocketBabbleProxyClient(nodeAddr string, timeout time.Duration) *SocketBabbleProxyClient {
 	return &SocketBabbleProxyClient{
 		nodeAddr: nodeAddr,
-		timeout:  timeout,
+		rpc:      NewRPCClient(nodeAddr, timeout),
 	}
 }
 
-func (p *SocketBabbleProxyClient) getConnection() (*rpc.Client, error) {
-	conn, err := net.DialTimeout("tcp", p.nodeAddr, p.timeout)
+func (p *SocketBabbleProxyClient) Handshake(handshake Handshake) (*bool, error) {
+	var ack bool
+	err := p.rpc.Call("Babble.Handshake", handshake, &ack)
 	if err != nil {
 		return nil, err
 	}
-	return jsonrpc.NewClient(conn), nil
+	return &ack, nil
 }
 
 func (p *SocketBabbleProxyClient) SubmitTx(tx []byte) (*bool, error) {
-	rpcConn, err := p.getConnection()
+	var ack bool
+	err := p.rpc.Call("Babble.SubmitTx", tx, &ack)
 	if err != nil {
 		return nil, err
 	}
-	var ack bool
-	err = rpcConn.Call("Babble.SubmitTx", tx, &ack)
+	return &ack, nil
+}
+
+func (p *SocketBabbleProxyClient) SubmitTxs(txs [][]byte) ([]string, error) {
+	var results []string
+	err := p.rpc.Call("Babble.SubmitTxs", txs, &results)
 	if err != nil {
 		return nil, err
 	}
-	return &ack, nil
+	return results, nil
 }
@@ -15,6 +15,14 @@ type StateHash struct {
 	Hash []byte
 }
 
+// Handshake is sent by an App to the node when it connects, with the index and
+// state hash of the last Block it committed. LastBlockIndex is -1 if the App
+// has not committed any Block.
+type Handshake struct {
+	LastBlockIndex int
+	StateHash      []byte
+}
+
 // CommitResponse captures both a response and a potential error.
 type CommitResponse struct {
 	StateHash []byte
@@ -32,10 +40,61 @@ func (r *Commit) Respond(stateHash []byte, err error) {
 	r.RespChan <- CommitResponse{stateHash, err}
 }
 
+// SnapshotResponse captures both a snapshot and a potential error.
+type SnapshotResponse struct {
+	Snapshot []byte
+	Error    error
+}
+
+// SnapshotRequest asks the App for its state after it committed a Block, or
+// its initial state if BlockIndex is -1.
+type SnapshotRequest struct {
+	BlockIndex int
+	RespChan   chan<- SnapshotResponse
+}
+
+// Respond is used to respond with a snapshot, error or both
+func (r *SnapshotRequest) Respond(snapshot []byte, err error) {
+	r.RespChan <- SnapshotResponse{snapshot, err}
+}
+
+// RestoreRequest asks the App to replace its state with a snapshot and respond
+// with the resulting state hash.
+type RestoreRequest struct {
+	Snapshot []byte
+	RespChan chan<- CommitResponse
+}
+
+// Respond is used to respond with a state hash, error or both
+func (r *RestoreRequest) Respond(stateHash []byte, err error) {
+	r.RespChan <- CommitResponse{stateHash, err}
+}
+
+// QueryResponse captures both the result of a query and a potential error.
+type QueryResponse struct {
+	Result []byte
+	Error  error
+}
+
+// QueryRequest asks the App to answer a read-only query on its state. The
+// format of the query and of its result is up to the App.
+type QueryRequest struct {
+	Query    []byte
+	RespChan chan<- QueryResponse
+}
+
+// Respond is used to respond with a result, error or both
+func (r *QueryRequest) Respond(result []byte, err error) {
+	r.RespChan <- QueryResponse{result, err}
+}
+
 type SocketBabbleProxyServer struct {
 	netListener *net.Listener
 	rpcServer   *rpc.Server
 	commitCh    chan Commit
+	snapshotCh  chan SnapshotRequest
+	restoreCh   chan RestoreRequest
+	queryCh     chan QueryRequest
 	timeout     time.Duration
 	logger      *logrus.Logger
 }
@@ -45,9 +104,12 @@ func NewSocketBabbleProxyServer(bindAddress string,
 	logger *logrus.Logger) (*SocketBabbleProxyServer, error) {
 
 	server := &SocketBabbleProxyServer{
-		commitCh: make(chan Commit),
-		timeout:  timeout,
-		logger:   logger,
+		commitCh:   make(chan Commit),
+		snapshotCh: make(chan SnapshotRequest),
+		restoreCh:  make(chan RestoreRequest),
+		queryCh:    make(chan QueryRequest),
+		timeout:    timeout,
+		logger:     logger,
 	}
 
 	if err := server.register(bindAddress); err != nil {
@@ -111,3 +173,105 @@ func (p *SocketBabbleProxyServer) CommitBlock(block hashgraph.Block, stateHash *
 	return
 
 }
+
+func (p *SocketBabbleProxyServer) GetSnapshot(blockIndex int, snapshot *[]byte) (err error) {
+	// The App has p.timeout to take and answer the request. A late response
+	// does not block it.
+	timeout := time.After(p.timeout)
+	respCh := make(chan SnapshotResponse, 1)
+
+	// Send the SnapshotRequest over and wait for a response
+	select {
+	case p.snapshotCh <- SnapshotRequest{
+		BlockIndex: blockIndex,
+		RespChan:   respCh,
+	}:
+		select {
+		case snapshotResp := <-respCh:
+			*snapshot = snapshotResp.Snapshot
+			if snapshotResp.Error != nil {
+				err = snapshotResp.Error
+			}
+		case <-timeout:
+			err = fmt.Errorf("command timed out")
+		}
+	case <-timeout:
+		err = fmt.Errorf("command timed out")
+	}
+
+	p.logger.WithFields(logrus.Fields{
+		"block":    blockIndex,
+		"snapshot": len(*snapshot),
+		"err":      err,
+	}).Debug("BabbleProxyServer.GetSnapshot")
+
+	return
+}
+
+func (p *SocketBabbleProxyServer) Restore(snapshot []byte, stateHash *StateHash) (err error) {
+	// The App has p.timeout to take and answer the request. A late response
+	// does not block it.
+	timeout := time.After(p.timeout)
+	respCh := make(chan CommitResponse, 1)
+
+	// Send the RestoreRequest over and wait for a response
+	select {
+	case p.restoreCh <- RestoreRequest{
+		Snapshot: snapshot,
+		RespChan: respCh,
+	}:
+		select {
+		case restoreResp := <-respCh:
+			stateHash.Hash = restoreResp.StateHash
+			if restoreResp.Error != nil {
+				err = restoreResp.Error
+			}
+		case <-timeout:
+			err = fmt.Errorf("command timed out")
+		}
+	case <-timeout:
+		err = fmt.Errorf("command timed out")
+	}
+
+	p.logger.WithFields(logrus.Fields{
+		"snapshot":   len(snapshot),
+		"state_hash": stateHash.Hash,
+		"err":        err,
+	}).Debug("BabbleProxyServer.Restore")
+
+	return
+}
+
+func (p *SocketBabbleProxyServer) Query(query []byte, result *[]byte) (err error) {
+	// The App has p.timeout to take and answer the request. A late response
+	// does not block it.
+	timeout := time.After(p.timeout)
+	respCh := make(chan QueryResponse, 1)
+
+	// Send the QueryRequest over and wait for a response
+	select {
+	case p.queryCh <- QueryRequest{
+		Query:    query,
+		RespChan: respCh,
+	}:
+		select {
+		case queryResp := <-respCh:
+			*result = queryResp.Result
+			if queryResp.Error != nil {
+				err = queryResp.Error
+			}
+		case <-timeout:
+			err = fmt.Errorf("command timed out")
+		}
+	case <-timeout:
+		err = fmt.Errorf("command timed out")
+	}
+
+	p.logger.WithFields(logrus.Fields{
+		"query":  len(query),
+		"result": len(*result),
+		"err":    err,
+	}).Debug("BabbleProxyServer.Query")
+
+	return
+}
@@ -12,8 +12,13 @@ import (
 	"github.com/sirupsen/logrus"
 )
 
+//State is the state of the dummy App. Only its state hash is part of its
+//snapshots; the messages written to the file are not restored. There is only a
+//snapshot of the last committed Block, since a restored snapshot does not say
+//which Block it was taken at.
 type State struct {
 	stateHash []byte
+	lastBlock int
 	logger    *logrus.Logger
 }
 
@@ -23,6 +28,34 @@ func (a *State) CommitBlock(block hashgraph.Block) ([]byte, error) {
 	if err != nil {
 		return a.stateHash, err
 	}
+	a.lastBlock = block.Index()
+	return a.stateHash, nil
+}
+
+func (a *State) GetSnapshot(blockIndex int) ([]byte, error) {
+	a.logger.WithField("block", blockIndex).Debug("GetSnapshot")
+	if blockIndex < 0 {
+		return []byte{}, nil
+	}
+	if blockIndex != a.lastBlock {
+		return nil, fmt.Errorf("no snapshot for Block %d", blockIndex)
+	}
+	return a.stateHash, nil
+}
+
+func (a *State) Restore(snapshot []byte) ([]byte, error) {
+	a.logger.WithField("snapshot", snapshot).Debug("Restore")
+	a.stateHash = snapshot
+	a.lastBlock = -1
+	return a.stateHash, nil
+}
+
+//Query answers the "state_hash" query with the state hash
+func (a *State) Query(query []byte) ([]byte, error) {
+	a.logger.WithField("query", string(query)).Debug("Query")
+	if string(query) != "state_hash" {
+		return nil, fmt.Errorf("unknown query %q", query)
+	}
 	return a.stateHash, nil
 }
 
@@ -98,6 +131,7 @@ func NewDummySocketClient(clientAddr string, nodeAddr string, logger *logrus.Log
 
 	state := State{
 		stateHash: []byte{},
+		lastBlock: -1,
 		logger:    logger,
 	}
 	state.writeMessage([]byte(clientAddr))
@@ -120,6 +154,15 @@ func (c *DummySocketClient) Run() {
 			c.logger.Debug("CommitBlock")
 			stateHash, err := c.state.CommitBlock(commit.Block)
 			commit.Respond(stateHash, err)
+		case req := <-c.babbleProxy.SnapshotCh():
+			snapshot, err := c.state.GetSnapshot(req.BlockIndex)
+			req.Respond(snapshot, err)
+		case req := <-c.babbleProxy.RestoreCh():
+			stateHash, err := c.state.Restore(req.Snapshot)
+			req.Respond(stateHash, err)
+		case req := <-c.babbleProxy.QueryCh():
+			result, err := c.state.Query(req.Query)
+			req.Respond(result, err)
 		}
 	}
 }
@@ -127,3 +170,7 @@ func (c *DummySocketClient) Run() {
 func (c *DummySocketClient) SubmitTx(tx []byte) error {
 	return c.babbleProxy.SubmitTx(tx)
 }
+
+func (c *DummySocketClient) SubmitTxs(txs [][]byte) ([]error, error) {
+	return c.babbleProxy.SubmitTxs(txs)
+}
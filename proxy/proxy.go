@@ -1,10 +1,42 @@
 package proxy
 
-import "github.com/champii/babble/hashgraph"
+import (
+	"github.com/champii/babble/hashgraph"
+	"github.com/champii/babble/mempool"
+)
 
+// AppProxy is the node's side of the connection to the App. GetSnapshot
+// returns the state of the App after it committed a Block, -1 for its initial
+// state, and Restore replaces the state of the App with a snapshot and returns
+// the resulting state hash. Nodes use them to catch up with their peers
+// without replaying the Blocks they missed.
 type AppProxy interface {
 	SubmitCh() chan []byte
 	CommitBlock(block hashgraph.Block) ([]byte, error)
+	GetSnapshot(blockIndex int) ([]byte, error)
+	Restore(snapshot []byte) ([]byte, error)
+}
+
+// WithMempool is an interface that an AppProxy may provide to add submitted
+// transactions to the node's Mempool directly, instead of sending them on
+// SubmitCh, so that refused transactions are reported to the App.
+type WithMempool interface {
+	SetMempool(m *mempool.Mempool)
+}
+
+// WithHandshake is an interface that an AppProxy may provide to let the App
+// resume from the last Block it committed when it connects. The handler
+// receives the index and state hash of that Block, -1 and nil for an App
+// without Blocks, and its error is returned to the App.
+type WithHandshake interface {
+	SetHandshakeHandler(handler func(lastBlockIndex int, stateHash []byte) error)
+}
+
+// WithQuery is an interface that an AppProxy may provide to answer read-only
+// queries on the state of the App. The format of the query and of its result is
+// up to the App.
+type WithQuery interface {
+	Query(query []byte) ([]byte, error)
 }
 
 type BabbleProxy interface {
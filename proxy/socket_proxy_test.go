@@ -1,13 +1,20 @@
 package proxy
 
 import (
+	"fmt"
+	"net"
+	"net/rpc"
+	"net/rpc/jsonrpc"
 	"reflect"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/champii/babble/common"
 	"github.com/champii/babble/hashgraph"
+	"github.com/champii/babble/mempool"
 	aproxy "github.com/champii/babble/proxy/app"
+	bproxy "github.com/champii/babble/proxy/babble"
 )
 
 func TestSokcetProxyServer(t *testing.T) {
@@ -74,3 +81,316 @@ func TestSocketProxyClient(t *testing.T) {
 	}
 	t.Logf("stateHash: %v", stateHash)
 }
+
+func TestSocketProxyMempool(t *testing.T) {
+	clientAddr := "127.0.0.1:9994"
+	proxyAddr := "127.0.0.1:9995"
+	proxy := aproxy.NewSocketAppProxy(clientAddr, proxyAddr, 1*time.Second, common.NewTestLogger(t))
+
+	pool := mempool.NewMempool(mempool.Limits{MaxTxs: 1})
+	proxy.SetMempool(pool)
+
+	dummyClient, err := NewDummySocketClient(clientAddr, proxyAddr, common.NewTestLogger(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := dummyClient.SubmitTx([]byte("tx1")); err != nil {
+		t.Fatal(err)
+	}
+	if err := dummyClient.SubmitTx([]byte("tx1")); err != mempool.ErrDuplicate {
+		t.Fatalf("expected ErrDuplicate, got %v", err)
+	}
+	if err := dummyClient.SubmitTx([]byte("tx2")); err != mempool.ErrPoolFull {
+		t.Fatalf("expected ErrPoolFull, got %v", err)
+	}
+
+	if pending := pool.Pending(); !reflect.DeepEqual(pending, [][]byte{[]byte("tx1")}) {
+		t.Fatalf("unexpected pending transactions: %s", pending)
+	}
+}
+
+func TestSocketProxySubmitTxs(t *testing.T) {
+	clientAddr := "127.0.0.1:9984"
+	proxyAddr := "127.0.0.1:9985"
+	proxy := aproxy.NewSocketAppProxy(clientAddr, proxyAddr, 1*time.Second, common.NewTestLogger(t))
+
+	pool := mempool.NewMempool(mempool.Limits{MaxTxs: 3})
+	proxy.SetMempool(pool)
+
+	dummyClient, err := NewDummySocketClient(clientAddr, proxyAddr, common.NewTestLogger(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	txs := [][]byte{[]byte("tx1"), []byte("tx1"), []byte("tx2"), []byte("tx3"), []byte("tx4")}
+	errs, err := dummyClient.SubmitTxs(txs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []error{nil, mempool.ErrDuplicate, nil, nil, mempool.ErrPoolFull}
+	if !reflect.DeepEqual(errs, expected) {
+		t.Fatalf("expected %v, got %v", expected, errs)
+	}
+
+	pending := [][]byte{[]byte("tx1"), []byte("tx2"), []byte("tx3")}
+	if p := pool.Pending(); !reflect.DeepEqual(p, pending) {
+		t.Fatalf("unexpected pending transactions: %s", p)
+	}
+}
+
+func TestSocketProxyHandshake(t *testing.T) {
+	clientAddr := "127.0.0.1:9996"
+	proxyAddr := "127.0.0.1:9997"
+	proxy := aproxy.NewSocketAppProxy(clientAddr, proxyAddr, 1*time.Second, common.NewTestLogger(t))
+
+	var gotIndex int
+	var gotHash []byte
+	proxy.SetHandshakeHandler(func(lastBlockIndex int, stateHash []byte) error {
+		if lastBlockIndex > 10 {
+			return fmt.Errorf("unknown block %d", lastBlockIndex)
+		}
+		gotIndex, gotHash = lastBlockIndex, stateHash
+		return nil
+	})
+
+	dummyClient, err := NewDummySocketClient(clientAddr, proxyAddr, common.NewTestLogger(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := dummyClient.babbleProxy.Handshake(3, []byte("state hash")); err != nil {
+		t.Fatal(err)
+	}
+	if gotIndex != 3 || string(gotHash) != "state hash" {
+		t.Fatalf("handler received %d, %s", gotIndex, gotHash)
+	}
+
+	if err := dummyClient.babbleProxy.Handshake(11, nil); err == nil {
+		t.Fatalf("the error of the handler should be returned")
+	}
+}
+
+func TestSocketProxySnapshot(t *testing.T) {
+	clientAddr := "127.0.0.1:9998"
+	proxyAddr := "127.0.0.1:9999"
+	proxy := aproxy.NewSocketAppProxy(clientAddr, proxyAddr, 1*time.Second, common.NewTestLogger(t))
+
+	_, err := NewDummySocketClient(clientAddr, proxyAddr, common.NewTestLogger(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	block := hashgraph.NewBlock(0, 1, [][]byte{[]byte("the test transaction")})
+	stateHash, err := proxy.CommitBlock(block)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	snapshot, err := proxy.GetSnapshot(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := proxy.GetSnapshot(1); err == nil {
+		t.Fatalf("there should be no snapshot for a Block that was not committed")
+	}
+
+	//Restoring the initial state, then the snapshot of Block 0, gives back the
+	//state hash of Block 0
+	initial, err := proxy.GetSnapshot(-1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := proxy.Restore(initial); err != nil {
+		t.Fatal(err)
+	}
+	restoredHash, err := proxy.Restore(snapshot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(restoredHash, stateHash) {
+		t.Fatalf("restored state hash should be %X, not %X", stateHash, restoredHash)
+	}
+}
+
+func TestSocketProxyQuery(t *testing.T) {
+	clientAddr := "127.0.0.1:9988"
+	proxyAddr := "127.0.0.1:9989"
+	proxy := aproxy.NewSocketAppProxy(clientAddr, proxyAddr, 1*time.Second, common.NewTestLogger(t))
+
+	_, err := NewDummySocketClient(clientAddr, proxyAddr, common.NewTestLogger(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	block := hashgraph.NewBlock(0, 1, [][]byte{[]byte("the test transaction")})
+	stateHash, err := proxy.CommitBlock(block)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := proxy.Query([]byte("state_hash"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(result, stateHash) {
+		t.Fatalf("query result should be %X, not %X", stateHash, result)
+	}
+
+	if _, err := proxy.Query([]byte("unknown")); err == nil {
+		t.Fatalf("unknown queries should return an error")
+	}
+}
+
+//restartingApp is an App that can die in the middle of a commit, with its
+//connections, and be started again
+type restartingApp struct {
+	listener net.Listener
+	conns    []net.Conn
+	//receives the index of every Block the App starts committing; the commit
+	//waits for a value on release
+	started chan int
+	release chan struct{}
+
+	lock      sync.Mutex
+	runs      int
+	committed []int
+}
+
+func (a *restartingApp) CommitBlock(block hashgraph.Block, stateHash *bproxy.StateHash) error {
+	a.lock.Lock()
+	run, release := a.runs, a.release
+	a.lock.Unlock()
+
+	a.started <- block.Index()
+	<-release
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	//a dead App does not finish its commits
+	if a.runs != run {
+		return fmt.Errorf("App stopped")
+	}
+	a.committed = append(a.committed, block.Index())
+	stateHash.Hash = []byte(fmt.Sprint(len(a.committed)))
+	return nil
+}
+
+func (a *restartingApp) start(t *testing.T, addr string) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := rpc.NewServer()
+	server.RegisterName("State", a)
+	a.lock.Lock()
+	a.listener = l
+	a.conns = nil
+	a.lock.Unlock()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			a.lock.Lock()
+			a.conns = append(a.conns, conn)
+			a.lock.Unlock()
+			go server.ServeCodec(jsonrpc.NewServerCodec(conn))
+		}
+	}()
+}
+
+//setRelease makes the next commits wait for a release, or not
+func (a *restartingApp) setRelease(released bool) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if released {
+		close(a.release)
+	} else {
+		a.release = make(chan struct{})
+	}
+}
+
+func (a *restartingApp) stop() {
+	a.listener.Close()
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	a.runs++
+	for _, c := range a.conns {
+		c.Close()
+	}
+}
+
+func TestSocketProxyAppRestart(t *testing.T) {
+	clientAddr := "127.0.0.1:9986"
+	proxyAddr := "127.0.0.1:9987"
+	proxy := aproxy.NewSocketAppProxy(clientAddr, proxyAddr, 1*time.Second, common.NewTestLogger(t))
+
+	app := &restartingApp{
+		started: make(chan int, 10),
+		release: make(chan struct{}),
+	}
+	app.start(t, clientAddr)
+	app.setRelease(true)
+
+	if _, err := proxy.CommitBlock(hashgraph.NewBlock(0, 1, [][]byte{[]byte("tx0")})); err != nil {
+		t.Fatal(err)
+	}
+	<-app.started
+
+	//The App dies while it commits Block 1
+	app.setRelease(false)
+	errCh := make(chan error)
+	go func() {
+		_, err := proxy.CommitBlock(hashgraph.NewBlock(1, 2, [][]byte{[]byte("tx1")}))
+		errCh <- err
+	}()
+	<-app.started
+	app.stop()
+	if err := <-errCh; err == nil {
+		t.Fatalf("commit interrupted by the App's death should fail")
+	}
+
+	//The node commits Block 1 again until the App is back
+	app.start(t, clientAddr)
+	defer app.stop()
+	app.setRelease(true)
+	timeout := time.After(5 * time.Second)
+	for {
+		stateHash, err := proxy.CommitBlock(hashgraph.NewBlock(1, 2, [][]byte{[]byte("tx1")}))
+		if err == nil {
+			if string(stateHash) != "2" {
+				t.Fatalf("state hash should be 2, not %s", stateHash)
+			}
+			break
+		}
+		select {
+		case <-timeout:
+			t.Fatalf("commit did not succeed after the App restarted: %s", err)
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+
+	//Concurrent calls share the new connection
+	var wg sync.WaitGroup
+	for i := 2; i < 6; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if _, err := proxy.CommitBlock(hashgraph.NewBlock(i, i+1, nil)); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	app.lock.Lock()
+	defer app.lock.Unlock()
+	if len(app.conns) != 1 {
+		t.Fatalf("the proxy should use 1 connection to the restarted App, not %d", len(app.conns))
+	}
+	if len(app.committed) != 6 {
+		t.Fatalf("App should have committed 6 Blocks, not %v", app.committed)
+	}
+}
@@ -0,0 +1,193 @@
+package proxy
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/champii/babble/common"
+	"github.com/champii/babble/hashgraph"
+	"github.com/champii/babble/mempool"
+	aproxy "github.com/champii/babble/proxy/app"
+)
+
+//TestStdioHelperApp is the App run by TestStdioProxy as a child process. It
+//handshakes, submits a transaction, and commits Blocks; it dies the first time
+//it commits Block 1, and never answers the query "hang".
+func TestStdioHelperApp(t *testing.T) {
+	crashMarker := os.Getenv("BABBLE_STDIO_TEST_APP")
+	if crashMarker == "" {
+		return
+	}
+
+	out := json.NewEncoder(os.Stdout)
+	out.Encode(map[string]interface{}{
+		"id":     1,
+		"method": "Babble.Handshake",
+		"params": map[string]interface{}{"LastBlockIndex": -1},
+	})
+	out.Encode(map[string]interface{}{
+		"method": "Babble.SubmitTx",
+		"params": []byte(fmt.Sprintf("app tx %d", os.Getpid())),
+	})
+
+	scanner := bufio.NewScanner(os.Stdin)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		var msg struct {
+			ID     *uint64
+			Method string
+			Params json.RawMessage
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &msg); err != nil || msg.Method == "" {
+			continue
+		}
+
+		switch msg.Method {
+		case "State.CommitBlock":
+			var block hashgraph.Block
+			json.Unmarshal(msg.Params, &block)
+			if block.Index() == 1 {
+				if _, err := os.Stat(crashMarker); os.IsNotExist(err) {
+					os.Create(crashMarker)
+					os.Exit(1)
+				}
+			}
+			out.Encode(map[string]interface{}{
+				"id":     msg.ID,
+				"result": map[string]interface{}{"Hash": []byte(fmt.Sprint(block.Index()))},
+			})
+		case "State.Query":
+			var query []byte
+			json.Unmarshal(msg.Params, &query)
+			if string(query) == "hang" {
+				continue
+			}
+			out.Encode(map[string]interface{}{
+				"id":    msg.ID,
+				"error": "unknown query " + string(query),
+			})
+		default:
+			out.Encode(map[string]interface{}{
+				"id":    msg.ID,
+				"error": "unknown method " + msg.Method,
+			})
+		}
+	}
+	os.Exit(0)
+}
+
+func TestStdioProxy(t *testing.T) {
+	dir, err := ioutil.TempDir("", "babble_stdio")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	os.Setenv("BABBLE_STDIO_TEST_APP", filepath.Join(dir, "crashed"))
+	defer os.Unsetenv("BABBLE_STDIO_TEST_APP")
+
+	proxy, err := aproxy.NewStdioAppProxy(os.Args[0]+" -test.run=^TestStdioHelperApp$",
+		time.Second, common.NewTestLogger(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pool := mempool.NewMempool(mempool.Limits{MaxTxs: 10})
+	proxy.SetMempool(pool)
+	handshakes := make(chan int, 10)
+	proxy.SetHandshakeHandler(func(lastBlockIndex int, stateHash []byte) error {
+		handshakes <- lastBlockIndex
+		return nil
+	})
+	proxy.Start()
+
+	select {
+	case index := <-handshakes:
+		if index != -1 {
+			t.Fatalf("handshake should be for Block -1, not %d", index)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("App did not handshake")
+	}
+
+	//The App commits Blocks, and its transactions reach the mempool
+	var stateHash []byte
+	timeout := time.After(5 * time.Second)
+	for {
+		if stateHash, err = proxy.CommitBlock(hashgraph.NewBlock(0, 1, [][]byte{[]byte("tx0")})); err == nil {
+			break
+		}
+		select {
+		case <-timeout:
+			t.Fatalf("commit failed: %s", err)
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	if string(stateHash) != "0" {
+		t.Fatalf("state hash should be 0, not %s", stateHash)
+	}
+	if pending := pool.Pending(); len(pending) != 1 {
+		t.Fatalf("the transaction of the App should be pending, not %s", pending)
+	}
+
+	if _, err := proxy.Query([]byte("query")); err == nil {
+		t.Fatalf("the error of the App should be returned")
+	}
+
+	//The App dies while it commits Block 1
+	if _, err := proxy.CommitBlock(hashgraph.NewBlock(1, 2, nil)); err == nil {
+		t.Fatalf("commit interrupted by the App's death should fail")
+	}
+
+	//It is started again, and handshakes again
+	select {
+	case <-handshakes:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("restarted App did not handshake")
+	}
+	timeout = time.After(5 * time.Second)
+	for {
+		if stateHash, err = proxy.CommitBlock(hashgraph.NewBlock(1, 2, nil)); err == nil {
+			break
+		}
+		select {
+		case <-timeout:
+			t.Fatalf("commit did not succeed after the App restarted: %s", err)
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	if !reflect.DeepEqual(stateHash, []byte("1")) {
+		t.Fatalf("state hash should be 1, not %s", stateHash)
+	}
+	if pending := pool.Pending(); len(pending) != 2 {
+		t.Fatalf("the transactions of both Apps should be pending, not %s", pending)
+	}
+
+	//The App does not answer: the request times out and the App is killed
+	//and started again
+	if _, err := proxy.Query([]byte("hang")); err == nil {
+		t.Fatalf("a request without a response in time should fail")
+	}
+	select {
+	case <-handshakes:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("App did not handshake after it timed out")
+	}
+
+	//Once closed, the App is stopped and not started again
+	proxy.Close()
+	if _, err := proxy.CommitBlock(hashgraph.NewBlock(2, 3, nil)); err == nil {
+		t.Fatalf("commit after Close should fail")
+	}
+	select {
+	case <-handshakes:
+		t.Fatalf("App should not be started after Close")
+	case <-time.After(500 * time.Millisecond):
+	}
+}
@@ -2,9 +2,13 @@ package service
 
 import (
 	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
 	"net/http"
 	"strconv"
 
+	hg "github.com/champii/babble/hashgraph"
 	"github.com/champii/babble/node"
 	"github.com/sirupsen/logrus"
 )
@@ -29,6 +33,10 @@ func (s *Service) Serve() {
 	s.logger.WithField("bind_address", s.bindAddress).Debug("Service serving")
 	http.HandleFunc("/stats", s.GetStats)
 	http.HandleFunc("/block/", s.GetBlock)
+	http.HandleFunc("/blocks", s.GetBlocks)
+	http.HandleFunc("/consensus_events", s.GetConsensusEvents)
+	http.HandleFunc("/query", s.Query)
+	http.HandleFunc("/submit_txs", s.SubmitTxs)
 	err := http.ListenAndServe(s.bindAddress, nil)
 	if err != nil {
 		s.logger.WithField("error", err).Error("Service failed")
@@ -61,3 +69,217 @@ func (s *Service) GetBlock(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(block)
 }
+
+//maxBlocks is the max number of Blocks returned by one /blocks request
+const maxBlocks = 100
+
+//BlocksPage is the response of /blocks. Next is the index of the first Block
+//of the range that was left out because of maxBlocks, or -1 if the range is
+//complete.
+type BlocksPage struct {
+	Blocks []hg.Block
+	Next   int
+}
+
+//GetBlocks returns the blocks between the 'from' and 'to' query parameters
+//(both included), at most maxBlocks of them. 'to' defaults to the last block.
+func (s *Service) GetBlocks(w http.ResponseWriter, r *http.Request) {
+	from, err := intParam(r, "from", 0)
+	if err != nil {
+		s.logger.WithError(err).Error("Parsing from parameter")
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	to, err := intParam(r, "to", s.node.GetLastBlockIndex())
+	if err != nil {
+		s.logger.WithError(err).Error("Parsing to parameter")
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	page := BlocksPage{Next: -1}
+	if to-from >= maxBlocks {
+		to = from + maxBlocks - 1
+		page.Next = to + 1
+	}
+
+	page.Blocks, err = s.node.GetBlocks(from, to)
+	if err != nil {
+		s.logger.WithError(err).Errorf("Retrieving blocks %d to %d", from, to)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(page)
+}
+
+//maxConsensusEvents is the max number of Events returned by one
+///consensus_events request
+const maxConsensusEvents = 1000
+
+//ConsensusEventsPage is the response of /consensus_events. Next is the offset
+//of the first Event left out because of the limit, or -1 if there are no
+//more consensus Events yet.
+type ConsensusEventsPage struct {
+	Events []hg.Event
+	Next   int
+}
+
+//GetConsensusEvents returns at most 'limit' consensus events, starting at
+//position 'offset' in the consensus order. 'limit' defaults to 100, and must
+//be between 1 and maxConsensusEvents.
+func (s *Service) GetConsensusEvents(w http.ResponseWriter, r *http.Request) {
+	offset, err := intParam(r, "offset", 0)
+	if err != nil {
+		s.logger.WithError(err).Error("Parsing offset parameter")
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	limit, err := intParam(r, "limit", 100)
+	if err != nil {
+		s.logger.WithError(err).Error("Parsing limit parameter")
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if offset < 0 || limit <= 0 {
+		http.Error(w, "offset must not be negative and limit must be positive",
+			http.StatusBadRequest)
+		return
+	}
+	if limit > maxConsensusEvents {
+		limit = maxConsensusEvents
+	}
+
+	//one more Event tells whether there is a next page
+	events, err := s.node.GetConsensusEvents(offset, limit+1)
+	if err != nil {
+		s.logger.WithError(err).Errorf("Retrieving consensus events from %d", offset)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	page := ConsensusEventsPage{Events: events, Next: -1}
+	if len(events) > limit {
+		page.Events = events[:limit]
+		page.Next = offset + limit
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(page)
+}
+
+//maxQuerySize is the max size of the body of a /query request
+const maxQuerySize = 1024 * 1024
+
+//Query sends the body of the request to the App as a read-only query. The
+//response holds the result of the App and the index of the last Block it had
+//committed when it answered.
+func (s *Service) Query(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "query must be a POST request", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query, status, err := readBody(w, r, maxQuerySize)
+	if err != nil {
+		s.logger.WithError(err).Error("Reading query")
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	result, err := s.node.Query(query)
+	if err == node.ErrQueryUnsupported {
+		http.Error(w, err.Error(), http.StatusNotImplemented)
+		return
+	}
+	if err != nil {
+		s.logger.WithError(err).Error("Querying App")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+const (
+	//maxSubmitTxs is the max number of transactions in a /submit_txs batch
+	maxSubmitTxs = 1000
+	//maxSubmitTxsSize is the max size of the body of a /submit_txs request
+	maxSubmitTxsSize = 8 * 1024 * 1024
+)
+
+//SubmitTxs adds the batch of transactions of the request to the pool of the
+//node. The body is a JSON array of base64 transactions, and the response an
+//array with the error of each transaction, or an empty string if it was
+//accepted. Batches are counted together, by remote host, for the
+//per-submitter limit of the pool. Batches are bounded by maxSubmitTxs and
+//maxSubmitTxsSize before they reach the pool.
+func (s *Service) SubmitTxs(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "submit_txs must be a POST request", http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, status, err := readBody(w, r, maxSubmitTxsSize)
+	if err != nil {
+		s.logger.WithError(err).Error("Reading transactions")
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	var txs [][]byte
+	if err := json.Unmarshal(body, &txs); err != nil {
+		s.logger.WithError(err).Error("Decoding transactions")
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(txs) > maxSubmitTxs {
+		http.Error(w,
+			fmt.Sprintf("batch of %d transactions, at most %d allowed", len(txs), maxSubmitTxs),
+			http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	submitter, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		submitter = r.RemoteAddr
+	}
+
+	results := make([]string, len(txs))
+	for i, err := range s.node.SubmitTxs(txs, submitter) {
+		if err != nil {
+			results[i] = err.Error()
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(results)
+}
+
+//readBody reads the body of a request, which must not be larger than max
+//bytes. The status goes with the error: 413 for a body that is too large, 400
+//otherwise.
+func readBody(w http.ResponseWriter, r *http.Request, max int) ([]byte, int, error) {
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, int64(max)))
+	if err != nil {
+		//MaxBytesReader stops after max bytes
+		if len(body) >= max {
+			return nil, http.StatusRequestEntityTooLarge,
+				fmt.Errorf("request body larger than %d bytes", max)
+		}
+		return nil, http.StatusBadRequest, err
+	}
+	return body, http.StatusOK, nil
+}
+
+func intParam(r *http.Request, name string, def int) (int, error) {
+	param := r.URL.Query().Get(name)
+	if param == "" {
+		return def, nil
+	}
+	return strconv.Atoi(param)
+}